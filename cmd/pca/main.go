@@ -14,11 +14,14 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"slices"
+	"strings"
 
+	"github.com/bitjungle/goLV/pkg/crossval"
+	"github.com/bitjungle/goLV/pkg/inspect"
 	"github.com/bitjungle/goLV/pkg/pca"
 	"github.com/bitjungle/goLV/pkg/preprocess"
 	"github.com/bitjungle/goLV/pkg/readdata"
@@ -35,19 +38,91 @@ var (
 	autoScaleFlag     bool
 	numComponentsFlag int
 	outputFile        string
+	confidenceFlag    []float64
+	cvFlag            string
+	cvSegmentsFlag    int
+	cvColumnFlag      string
+	cvSeedFlag        int64
+	algorithmFlag     string
+	toleranceFlag     float64
+	maxIterationsFlag int
+	initFlag          string
+	seedFlag          int64
+	scatterFlag       string
+	emscOrderFlag     int
+	sgWindowFlag      int
+	sgOrderFlag       int
+	sgDerivFlag       int
+	sgEdgeFlag        string
+	preprocessFlag    string
+	scalingFlag       string
+	constantFlag      string
+	constantTolFlag   float64
+	ddofFlag          int
+	weightsFlag       string
+	weightsRowFlag    string
+	blocksFlag        string
+	blockSepFlag      string
+	blockScalingFlag  bool
+	delimiterFlag     string
+	decimalFlag       string
+	commentFlag       string
+	encodingFlag      string
+	metadataFlag      []string
+	allErrorsFlag     bool
+	sheetFlag         string
+	rangeFlag         string
+	spectrumNamesFlag string
+	includeVarsFlag   []string
+	excludeVarsFlag   []string
+	includeObjsFlag   []string
+	excludeObjsFlag   []string
+	outlierFactorFlag float64
 )
 
+// PredictionResults struct to hold the projection of new objects onto a PCA model.
+type PredictionResults struct {
+	VariableNames []string         `json:"variable_names"`
+	ObjectNames   []string         `json:"object_names"`
+	NumComponents int              `json:"num_components"`
+	Scores        utils.JSONMatrix `json:"scores"`       // Null for objects that could not be projected
+	Residuals     utils.JSONMatrix `json:"residuals"`    // Null where the data is missing
+	HotellingT2   utils.JSONVector `json:"hotelling_t2"` // Null for objects that could not be projected
+	SPE           utils.JSONVector `json:"spe"`
+
+	ControlLimits []pca.ControlLimits `json:"control_limits,omitempty"` // With the T² limits of new objects
+}
+
+// SelectionReport lists the variables and objects left out when loading the data.
+type SelectionReport struct {
+	ExcludedVariables []string `json:"excluded_variables,omitempty"`
+	ExcludedObjects   []string `json:"excluded_objects,omitempty"`
+}
+
 // Results struct to hold PCA analysis results.
 type Results struct {
-	VariableNames       []string    `json:"variable_names"`
-	ObjectNames         []string    `json:"object_names"`
-	NumComponents       int         `json:"num_components"`
-	Scores              [][]float64 `json:"scores"`
-	Loadings            [][]float64 `json:"loadings"`
-	Eigenvalues         []float64   `json:"eigenvalues"`
-	VariancePercentages []float64   `json:"variance_percentages"`
-	XMean               []float64   `json:"x_mean"`
-	XStd                []float64   `json:"x_std"`
+	VariableNames       []string            `json:"variable_names"`
+	ObjectNames         []string            `json:"object_names"`
+	NumObjects          int                 `json:"num_objects"`
+	Metadata            []readdata.Metadata `json:"metadata,omitempty"` // Class labels etc. of each object
+	NumComponents       int                 `json:"num_components"`
+	Algorithm           string              `json:"algorithm"`
+	Scores              [][]float64         `json:"scores"`
+	Loadings            [][]float64         `json:"loadings"`
+	Eigenvalues         []float64           `json:"eigenvalues"`
+	VariancePercentages []float64           `json:"variance_percentages"`
+
+	Selection         *SelectionReport     `json:"selection,omitempty"`
+	Preprocessing     *preprocess.Pipeline `json:"preprocessing"`
+	ExcludedVariables []string             `json:"excluded_variables,omitempty"` // Dropped by preprocessing, not in the loadings
+
+	HotellingT2   []float64           `json:"hotelling_t2"`
+	SPE           []float64           `json:"spe"`
+	ControlLimits []pca.ControlLimits `json:"control_limits"`
+
+	BlockVariance   []pca.BlockVariance `json:"block_variance,omitempty"`
+	Convergence     []pca.Convergence   `json:"convergence,omitempty"`
+	CrossValidation *crossval.Result    `json:"cross_validation,omitempty"`
 }
 
 // main function sets up and runs the Cobra command line application.
@@ -57,14 +132,77 @@ func main() {
 		Short: "goLV Principal Component Analysis (PCA)",
 		Long: `goLV Principal Component Analysis (PCA) - Copyright (C) 2024 BITJUNGLE Rune Mathisen. 
 		        This program is distributed under the Apache license version 2.0`,
-		Run: runRootCommand,
+		Args: cobra.ArbitraryArgs,
+		Run:  runRootCommand,
 	}
 
-	// Configuration of persistent flags for Cobra.
-	rootCmd.PersistentFlags().IntVarP(&numComponentsFlag, "comps", "c", -1, "Number of principal components to compute")
-	rootCmd.PersistentFlags().BoolVarP(&autoScaleFlag, "scale", "s", false, "Apply autoscaling")
+	var predictCmd = &cobra.Command{
+		Use:   "predict MODEL DATA",
+		Short: "Project new objects onto a saved PCA model",
+		Args:  cobra.ExactArgs(2),
+		Run:   runPredictCommand,
+	}
+	rootCmd.AddCommand(predictCmd)
+
+	var inspectCmd = &cobra.Command{
+		Use:     "inspect DATA",
+		Aliases: []string{"validate"},
+		Short:   "Report problems and descriptive statistics of a dataset",
+		Args:    cobra.ExactArgs(1),
+		Run:     runInspectCommand,
+	}
+	inspectCmd.Flags().Float64Var(&outlierFactorFlag, "outlier-factor", inspect.DefaultOutlierFactor, "Interquartile ranges beyond the quartiles at which a value is an extreme outlier")
+	inspectCmd.Flags().Float64Var(&constantTolFlag, "constant-tol", preprocess.DefaultConstantTolerance, "Relative standard deviation below which a variable is near-constant")
+	inspectCmd.Flags().IntVar(&ddofFlag, "ddof", 0, "Standard deviation denominator n - ddof: 0 for population, 1 for sample")
+	rootCmd.AddCommand(inspectCmd)
+
+	// Flags for loading data and saving results, shared by all commands
+	rootCmd.PersistentFlags().StringVar(&delimiterFlag, "delimiter", "auto", "CSV field delimiter: auto, a character or tab")
+	rootCmd.PersistentFlags().StringVar(&decimalFlag, "decimal", "auto", "Decimal separator: auto, . or , (auto detects it from the numbers in the data)")
+	rootCmd.PersistentFlags().StringVar(&commentFlag, "comment", "", "Skip CSV lines starting with this character (optional)")
+	rootCmd.PersistentFlags().StringVar(&encodingFlag, "encoding", readdata.EncodingAuto, "Character encoding: auto, utf-8, utf-16, latin1 or windows-1252")
+	rootCmd.PersistentFlags().StringSliceVar(&includeVarsFlag, "include-vars", nil, "Variables to include: names, #i-j index ranges, lo-hi numeric ranges or re:regex (default all)")
+	rootCmd.PersistentFlags().StringSliceVar(&excludeVarsFlag, "exclude-vars", nil, "Variables to exclude, with the same patterns as --include-vars")
+	rootCmd.PersistentFlags().StringSliceVar(&includeObjsFlag, "include-objs", nil, "Objects to include, with the same patterns as --include-vars (default all)")
+	rootCmd.PersistentFlags().StringSliceVar(&excludeObjsFlag, "exclude-objs", nil, "Objects to exclude, with the same patterns as --include-vars")
+	rootCmd.PersistentFlags().StringSliceVar(&metadataFlag, "metadata", nil, "Non-numeric columns, e.g. class labels, batch IDs or dates, kept as metadata (optional)")
+	rootCmd.PersistentFlags().BoolVar(&allErrorsFlag, "all-errors", false, "Report every cell that is not a number, rather than stop at the first")
+	rootCmd.PersistentFlags().StringVar(&sheetFlag, "sheet", "", "Sheet to read from .xlsx files (default the first sheet)")
+	rootCmd.PersistentFlags().StringVar(&rangeFlag, "range", "", "Cell range to read from .xlsx files, e.g. A2:F9 (default all used cells)")
+	rootCmd.PersistentFlags().StringVar(&spectrumNamesFlag, "spectrum-names", readdata.SpectrumNameFile, "Object names of JCAMP-DX and SPC spectra: file or title")
 	rootCmd.PersistentFlags().StringVarP(&outputFile, "output", "o", "", "Path to output results as a JSON file (optional)")
 
+	// Flags of the analysis
+	rootCmd.Flags().IntVarP(&numComponentsFlag, "comps", "c", -1, "Number of principal components to compute")
+	rootCmd.Flags().StringVar(&scalingFlag, "scaling", "none", "Column scaling: none, uv, pareto, range, vast or level")
+	rootCmd.Flags().BoolVarP(&autoScaleFlag, "scale", "s", false, "Apply autoscaling")
+	rootCmd.Flags().MarkDeprecated("scale", "use --scaling uv instead")
+	rootCmd.Flags().StringVar(&constantFlag, "constant", preprocess.ConstantError, "Handling of zero and near-zero variance columns when scaling: error, drop or keep")
+	rootCmd.Flags().Float64Var(&constantTolFlag, "constant-tol", preprocess.DefaultConstantTolerance, "Relative standard deviation below which a column is near-constant")
+	rootCmd.Flags().IntVar(&ddofFlag, "ddof", 0, "Standard deviation denominator n - ddof: 0 for population, 1 for sample")
+	rootCmd.Flags().StringVar(&weightsFlag, "weights", "", "CSV file with a variable name and weight on each line (optional)")
+	rootCmd.Flags().StringVar(&weightsRowFlag, "weights-row", "", "Name of a row in the data holding the variable weights (optional)")
+	rootCmd.Flags().StringVar(&blocksFlag, "blocks", "", "CSV file with a variable name and block name on each line (optional)")
+	rootCmd.Flags().StringVar(&blockSepFlag, "block-sep", "", "Separator between block name and variable in the variable names, e.g. : (optional)")
+	rootCmd.Flags().BoolVar(&blockScalingFlag, "block-scaling", false, "Scale each block of variables to equal total variance")
+	rootCmd.Flags().StringVarP(&preprocessFlag, "preprocess", "p", "", "Preprocessing steps in order, e.g. snv,sg1,center (overrides --scale, --scatter and --sg-*)")
+	rootCmd.Flags().StringVar(&scatterFlag, "scatter", "", "Scatter correction of spectra: snv, msc or emsc (optional)")
+	rootCmd.Flags().IntVar(&emscOrderFlag, "emsc-order", 2, "Polynomial order of the EMSC baseline terms")
+	rootCmd.Flags().IntVar(&sgWindowFlag, "sg-window", 0, "Savitzky-Golay window width, odd (optional, 0 disables the filter)")
+	rootCmd.Flags().IntVar(&sgOrderFlag, "sg-order", 2, "Savitzky-Golay polynomial order")
+	rootCmd.Flags().IntVar(&sgDerivFlag, "sg-deriv", 0, "Savitzky-Golay derivative order: 0, 1 or 2")
+	rootCmd.Flags().StringVar(&sgEdgeFlag, "sg-edge", preprocess.EdgeInterp, "Savitzky-Golay edge handling: interp, nearest or mirror")
+	rootCmd.Flags().StringVarP(&algorithmFlag, "algorithm", "a", pca.AlgorithmNIPALS, "PCA algorithm: nipals, svd or eig")
+	rootCmd.Flags().Float64Var(&toleranceFlag, "tolerance", pca.DefaultNIPALSOptions().Tolerance, "NIPALS convergence tolerance")
+	rootCmd.Flags().IntVar(&maxIterationsFlag, "max-iter", pca.DefaultNIPALSOptions().MaxIterations, "NIPALS maximum number of iterations per component")
+	rootCmd.Flags().StringVar(&initFlag, "init", pca.InitHighestVariance, "NIPALS initialization: variance or random")
+	rootCmd.Flags().Int64Var(&seedFlag, "seed", 1, "Random seed for NIPALS random initialization")
+	rootCmd.Flags().StringVar(&cvFlag, "cv", "", "Cross-validation method: loo, kfold, venetian, blocks or column (optional)")
+	rootCmd.Flags().IntVar(&cvSegmentsFlag, "cv-segments", 7, "Number of cross-validation segments")
+	rootCmd.Flags().StringVar(&cvColumnFlag, "cv-column", "", "Variable holding the segment of each object, used with --cv column")
+	rootCmd.Flags().Int64Var(&cvSeedFlag, "cv-seed", 1, "Random seed for k-fold cross-validation")
+	rootCmd.Flags().Float64SliceVar(&confidenceFlag, "confidence", []float64{0.95, 0.99}, "Confidence level(s) for the T² and SPE control limits")
+
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatalf("Command execution error: %v", err)
 	}
@@ -76,80 +214,568 @@ func runRootCommand(cmd *cobra.Command, args []string) {
 	fmt.Println()
 
 	if len(args) < 1 {
-		log.Fatal("Please provide a CSV file")
+		log.Fatal("Please provide a data file or directory of spectra, or - to read CSV from standard input")
 	}
 
 	doAnalysis(args[0])
 }
 
-// loadData reads and processes CSV data.
-func loadData(filename string) (readdata.ProcessedData, *mat.Dense, error) {
-	records, err := readdata.ProcessCSV(filename)
+// runPredictCommand is executed by Cobra for the predict subcommand.
+func runPredictCommand(cmd *cobra.Command, args []string) {
+	fmt.Println("goLV Principal Component Analysis (PCA) version", AppVersion, "predicting...")
+	fmt.Println()
+
+	doPrediction(args[0], args[1])
+}
+
+// runInspectCommand is executed by Cobra for the inspect subcommand.
+func runInspectCommand(cmd *cobra.Command, args []string) {
+	fmt.Println("goLV Principal Component Analysis (PCA) version", AppVersion, "inspecting...")
+	fmt.Println()
+
+	doInspection(args[0])
+}
+
+// loadData reads the data file with the options given on the command line
+// (see readdata.Load). With collectErrors, every cell that is not a number
+// is reported as readdata.ParseErrors, together with the data.
+func loadData(filename string, collectErrors bool) (readdata.ProcessedData, *mat.Dense, error) {
+	opts, err := csvOptions()
 	if err != nil {
 		return readdata.ProcessedData{}, nil, err
 	}
-	X := utils.SliceToDense(records.Data)
-	return records, X, nil
+	opts.CollectErrors = collectErrors
+	return readdata.Load(filename, readdata.LoadOptions{
+		CSV:     opts,
+		Sheet:   sheetFlag,
+		Range:   rangeFlag,
+		Spectra: readdata.SpectraOptions{NameBy: spectrumNamesFlag},
+	})
+}
+
+// csvOptions collects the CSV dialect options given on the command line.
+func csvOptions() (readdata.CSVOptions, error) {
+	opts, err := readdata.ParseCSVOptions(delimiterFlag, decimalFlag, commentFlag, encodingFlag)
+	opts.Metadata = metadataFlag
+	return opts, err
 }
 
 // determineNumComponents determines the number of PCA components to compute.
+// The default is min(rows-1, cols), the most components centered data can
+// have.
 func determineNumComponents(X *mat.Dense) int {
 	if numComponentsFlag <= 0 {
-		_, cols := X.Dims()
-		return cols // Default to the number of columns
+		rows, cols := X.Dims()
+		return max(min(rows-1, cols), 1)
 	}
 	return numComponentsFlag
 }
 
+// buildPipeline creates the preprocessing pipeline. The --preprocess flag
+// gives the steps directly. Otherwise the pipeline is built from the
+// --scatter, --sg-* and --scaling flags, and always ends with mean centering.
+// Scaling is done before centering, as VAST and level scaling need the
+// column means of the uncentered data. The --constant and --constant-tol
+// flags apply to all scaling steps. Variable weights are applied by the last
+// scaling step, or by a weight step added at the end of the pipeline. Block
+// scaling is added at the end with --block-scaling.
+func buildPipeline(weights []float64, blocks []string) (*preprocess.Pipeline, error) {
+	pipeline, err := parsePipeline()
+	if err != nil {
+		return nil, err
+	}
+	var last *preprocess.Scaling
+	for _, step := range pipeline.Steps {
+		if s, ok := step.(*preprocess.Scaling); ok {
+			s.Constant = constantFlag
+			s.Tolerance = constantTolFlag
+			s.DDOF = ddofFlag
+			last = s
+		}
+	}
+	if weights != nil {
+		if last == nil {
+			last = &preprocess.Scaling{Method: preprocess.ScalingNone}
+			pipeline.Steps = append(pipeline.Steps, last)
+		}
+		last.Weights = weights
+	}
+
+	hasBlockScaling := false
+	for _, step := range pipeline.Steps {
+		if b, ok := step.(*preprocess.BlockScaling); ok {
+			if blocks == nil {
+				return nil, fmt.Errorf("block scaling requires --blocks or --block-sep")
+			}
+			b.Blocks = blocks
+			hasBlockScaling = true
+		}
+	}
+	if blockScalingFlag && !hasBlockScaling {
+		if blocks == nil {
+			return nil, fmt.Errorf("block scaling requires --blocks or --block-sep")
+		}
+		pipeline.Steps = append(pipeline.Steps, &preprocess.BlockScaling{Blocks: blocks})
+		hasBlockScaling = true
+	}
+	if hasBlockScaling && constantFlag == preprocess.ConstantDrop {
+		return nil, fmt.Errorf("block scaling cannot be combined with --constant drop")
+	}
+	return pipeline, nil
+}
+
+// variableBlocks returns the block of each variable, from the --blocks file
+// or by the --block-sep prefix of the variable names, or nil if no blocks
+// are given.
+func variableBlocks(variableNames []string) ([]string, error) {
+	if blocksFlag != "" && blockSepFlag != "" {
+		return nil, fmt.Errorf("use either --blocks or --block-sep, not both")
+	}
+	if blocksFlag != "" {
+		return readdata.ReadBlocks(blocksFlag, variableNames)
+	}
+	if blockSepFlag != "" {
+		return readdata.BlocksFromPrefix(variableNames, blockSepFlag), nil
+	}
+	return nil, nil
+}
+
+// extractWeightsRow removes the --weights-row row from the data, and returns
+// the weights by variable name.
+func extractWeightsRow(records readdata.ProcessedData, X *mat.Dense) (map[string]float64, readdata.ProcessedData, *mat.Dense, error) {
+	if weightsRowFlag == "" {
+		return nil, records, X, nil
+	}
+	values, records, err := records.ExtractRow(weightsRowFlag)
+	if err != nil {
+		return nil, records, X, err
+	}
+	weights := make(map[string]float64, len(values))
+	for j, name := range records.VariableNames {
+		weights[name] = values[j]
+	}
+	X, err = utils.SliceToDense(records.Data)
+	return weights, records, X, err
+}
+
+// variableWeights returns the weights of the variables, from the --weights
+// file or the --weights-row row, or nil if no weights are given.
+func variableWeights(variableNames []string, weightsRow map[string]float64) ([]float64, error) {
+	if weightsFlag != "" && weightsRow != nil {
+		return nil, fmt.Errorf("use either --weights or --weights-row, not both")
+	}
+	if weightsFlag != "" {
+		return readdata.ReadWeights(weightsFlag, variableNames)
+	}
+	if weightsRow == nil {
+		return nil, nil
+	}
+	weights := make([]float64, len(variableNames))
+	for j, name := range variableNames {
+		weights[j] = weightsRow[name]
+	}
+	return weights, nil
+}
+
+// selectedColumns returns the column of each selected variable among all
+// the variables.
+func selectedColumns(allNames, selected []string) []int {
+	index := make(map[string]int, len(allNames))
+	for j := len(allNames) - 1; j >= 0; j-- {
+		index[allNames[j]] = j // The first of duplicate names
+	}
+	cols := make([]int, len(selected))
+	for k, name := range selected {
+		cols[k] = index[name]
+	}
+	return cols
+}
+
+// selectWeightsAndBlocks returns the weights and blocks of the selected
+// columns. Nil weights or blocks stay nil.
+func selectWeightsAndBlocks(weights []float64, blocks []string, cols []int) ([]float64, []string) {
+	var selectedWeights []float64
+	var selectedBlocks []string
+	for _, j := range cols {
+		if weights != nil {
+			selectedWeights = append(selectedWeights, weights[j])
+		}
+		if blocks != nil {
+			selectedBlocks = append(selectedBlocks, blocks[j])
+		}
+	}
+	return selectedWeights, selectedBlocks
+}
+
+// parsePipeline creates the preprocessing steps given on the command line.
+func parsePipeline() (*preprocess.Pipeline, error) {
+	if preprocessFlag != "" {
+		return preprocess.ParsePipeline(preprocessFlag)
+	}
+
+	var steps []string
+	if scatterFlag == preprocess.ScatterEMSC {
+		steps = append(steps, fmt.Sprintf("%s:%d", scatterFlag, emscOrderFlag))
+	} else if scatterFlag != "" {
+		steps = append(steps, scatterFlag)
+	}
+	if sgWindowFlag > 0 {
+		steps = append(steps, fmt.Sprintf("sg%d:%d:%d:%s", sgDerivFlag, sgWindowFlag, sgOrderFlag, sgEdgeFlag))
+	}
+	if autoScaleFlag && scalingFlag != "" && scalingFlag != "none" {
+		return nil, fmt.Errorf("use either --scaling or --scale, not both")
+	}
+	scaling, err := preprocess.ScalingStep(scalingFlag)
+	if err != nil {
+		return nil, err
+	}
+	if autoScaleFlag {
+		scaling = preprocess.StepScale
+	}
+	if scaling != "" {
+		steps = append(steps, scaling)
+	}
+	steps = append(steps, preprocess.StepCenter)
+	return preprocess.ParsePipeline(strings.Join(steps, ","))
+}
+
+// namedConstantColumns adds the names of the variables to an error caused by
+// constant columns, and suggests the --constant flag.
+func namedConstantColumns(err error, variableNames []string) error {
+	var constantErr *preprocess.ConstantColumnsError
+	if !errors.As(err, &constantErr) {
+		return err
+	}
+	names := make([]string, len(constantErr.Columns))
+	for i, j := range constantErr.Columns {
+		names[i] = variableNames[j]
+	}
+	return fmt.Errorf("%v (%s), use --constant drop or --constant keep", err, strings.Join(names, ", "))
+}
+
+// pcaOptions collects the PCA options given on the command line.
+func pcaOptions(numComponents int) pca.Options {
+	return pca.Options{
+		NumComponents: numComponents,
+		Algorithm:     algorithmFlag,
+		NIPALS: pca.NIPALSOptions{
+			Tolerance:     toleranceFlag,
+			MaxIterations: maxIterationsFlag,
+			Init:          initFlag,
+			Seed:          seedFlag,
+		},
+	}
+}
+
 // doAnalysis orchestrates the PCA analysis.
 func doAnalysis(filename string) {
 	// Load data
-	records, X, err := loadData(filename)
+	records, X, err := loadData(filename, allErrorsFlag)
 	if err != nil {
 		log.Fatalf("Error loading data: %v", err)
 	}
 
-	// Determine the number of components
-	numComponents := determineNumComponents(X)
+	// Remove the weights row from the data if used
+	weightsRow, records, X, err := extractWeightsRow(records, X)
+	if err != nil {
+		log.Fatalf("Error reading weights: %v", err)
+	}
 
-	// Preprocess the data (mean centering and optionally autoscaling)
-	var Xpre *mat.Dense
-	var Xmean, Xstd []float64
-	if autoScaleFlag {
-		Xpre, Xmean, Xstd = preprocess.Autoscale(X)
-	} else {
-		Xpre, Xmean = preprocess.MeanCenter(X)
-		Xstd = make([]float64, Xpre.RawMatrix().Cols)
-		for i := range Xstd {
-			Xstd[i] = 1.0
+	// Select objects
+	selection := &SelectionReport{}
+	records, selection.ExcludedObjects, err = records.SelectObjects(readdata.Selection{Include: includeObjsFlag, Exclude: excludeObjsFlag})
+	if err != nil {
+		log.Fatalf("Error loading data: %v", err)
+	}
+
+	// Find the cross-validation segments, and remove the segment column from the data if used
+	var segments [][]int
+	if cvFlag != "" {
+		segments, records, err = crossValidationSegments(records)
+		if err != nil {
+			log.Fatalf("Error creating cross-validation segments: %v", err)
+		}
+	}
+
+	// Read the weights and blocks of all variables, so they may name
+	// variables left out by the selection
+	weights, err := variableWeights(records.VariableNames, weightsRow)
+	if err != nil {
+		log.Fatalf("Error reading weights: %v", err)
+	}
+	blocks, err := variableBlocks(records.VariableNames)
+	if err != nil {
+		log.Fatalf("Error reading blocks: %v", err)
+	}
+
+	// Select variables
+	if variables := (readdata.Selection{Include: includeVarsFlag, Exclude: excludeVarsFlag}); !variables.IsEmpty() {
+		allNames := records.VariableNames
+		records, selection.ExcludedVariables, err = records.SelectVariables(variables)
+		if err != nil {
+			log.Fatalf("Error loading data: %v", err)
+		}
+		weights, blocks = selectWeightsAndBlocks(weights, blocks, selectedColumns(allNames, records.VariableNames))
+	}
+	if X, err = records.Dense(X); err != nil {
+		log.Fatalf("Error loading data: %v", err)
+	}
+
+	// Set up the preprocessing pipeline
+	pipeline, err := buildPipeline(weights, blocks)
+	if err != nil {
+		log.Fatalf("Error in preprocessing: %v", err)
+	}
+
+	// Cross-validate
+	var cv *crossval.Result
+	if segments != nil {
+		cv, err = crossValidate(X, segments, pipeline)
+		if err != nil {
+			log.Fatalf("Error cross-validating: %v", namedConstantColumns(err, records.VariableNames))
 		}
 	}
 
+	// Preprocess the data
+	Xpre, err := pipeline.FitApply(X)
+	if err != nil {
+		log.Fatalf("Error in preprocessing: %v", namedConstantColumns(err, records.VariableNames))
+	}
+
+	// Determine the number of components
+	numComponents := determineNumComponents(Xpre)
+	if cv != nil && numComponentsFlag <= 0 {
+		numComponents = cv.Recommended
+	}
+
 	// Perform PCA
-	T, P, eigv, err := pca.NIPALS(Xpre, numComponents)
+	model, err := pca.Fit(Xpre, pcaOptions(numComponents))
 	if err != nil {
-		log.Fatalf("Error performing NIPALS PCA: %v", err)
+		log.Fatalf("Error performing PCA: %v", err)
 	}
+	T, P, eigv, E := model.Scores, model.Loadings, model.Eigenvalues, model.Residuals
 	variancePercentages := pca.CalculateVariancePercentages(eigv)
 
 	// Prepare and output the results
-	results := prepareResults(records, numComponents, T, P, eigv, variancePercentages, Xmean, Xstd)
+	results := prepareResults(records, numComponents, T, P, eigv, variancePercentages, pipeline)
+	results.Algorithm = algorithmFlag
+	dropped := pipeline.Dropped(len(records.VariableNames))
+	if len(results.ExcludedVariables) > 0 {
+		log.Printf("Warning: excluded constant variables %v", results.ExcludedVariables)
+	}
+	results.Convergence = model.Convergence
+	for a, c := range model.Convergence {
+		if !c.Converged {
+			log.Printf("Warning: NIPALS component %d did not converge in %d iterations (change %g)", a+1, c.Iterations, c.Change)
+		}
+	}
+	results.HotellingT2 = pca.HotellingT2(T, eigv, len(records.ObjectNames))
+	results.SPE = pca.SPE(E)
+	results.ControlLimits = pca.CalculateControlLimits(E, numComponents, confidenceFlag)
+	results.CrossValidation = cv
+	if len(selection.ExcludedObjects) > 0 || len(selection.ExcludedVariables) > 0 {
+		results.Selection = selection
+	}
+	if blocks != nil {
+		var keptBlocks []string
+		for j, block := range blocks {
+			if !slices.Contains(dropped, j) {
+				keptBlocks = append(keptBlocks, block)
+			}
+		}
+		results.BlockVariance, err = pca.BlockExplainedVariance(Xpre, T, P, keptBlocks)
+		if err != nil {
+			log.Fatalf("Error calculating block explained variance: %v", err)
+		}
+	}
 	outputResults(results)
 }
 
-// prepareResults organizes PCA results into a structured format.
+// crossValidationSegments creates the cross-validation segments. With
+// --cv column the segment column is a metadata column, or a numeric column
+// that is removed from the returned data.
+func crossValidationSegments(records readdata.ProcessedData) ([][]int, readdata.ProcessedData, error) {
+	var segments [][]int
+	var err error
+	if cvFlag == crossval.Column {
+		if cvColumnFlag == "" {
+			return nil, records, fmt.Errorf("--cv column requires --cv-column")
+		}
+		var labels []string
+		if m := records.MetadataColumn(cvColumnFlag); m != nil {
+			labels = m.Values // E.g. batch IDs
+		} else {
+			var values []float64
+			values, records, err = records.ExtractColumn(cvColumnFlag)
+			if err != nil {
+				return nil, records, err
+			}
+			labels = make([]string, len(values))
+			for i, v := range values {
+				labels[i] = fmt.Sprint(v)
+			}
+		}
+		segments, err = crossval.SegmentsFromLabels(labels)
+	} else {
+		segments, err = crossval.Segments(cvFlag, len(records.ObjectNames), cvSegmentsFlag, cvSeedFlag)
+	}
+	return segments, records, err
+}
+
+// crossValidate cross-validates PCA models with up to the maximum number of
+// components.
+func crossValidate(X *mat.Dense, segments [][]int, pipeline *preprocess.Pipeline) (*crossval.Result, error) {
+	rows, cols := X.Dims()
+	numComponents := determineNumComponents(X)
+	if numComponentsFlag <= 0 {
+		numComponents = max(min(cols-1, rows-2), 1)
+	}
+	cv, err := crossval.PCA(X, segments, pipeline, pcaOptions(numComponents))
+	if err != nil {
+		return nil, err
+	}
+	cv.Method = cvFlag
+	return cv, nil
+}
+
+// doPrediction applies a saved PCA model to new data.
+func doPrediction(modelFile, dataFile string) {
+	model, err := pca.LoadModel(modelFile)
+	if err != nil {
+		log.Fatalf("Error loading model: %v", err)
+	}
+
+	records, X, err := loadData(dataFile, allErrorsFlag)
+	if err != nil {
+		log.Fatalf("Error loading data: %v", err)
+	}
+	if records, _, err = records.SelectObjects(readdata.Selection{Include: includeObjsFlag, Exclude: excludeObjsFlag}); err != nil {
+		log.Fatalf("Error loading data: %v", err)
+	}
+	if records, err = matchModelVariables(model.InputVariableNames(), records); err != nil {
+		log.Fatalf("Data does not match model: %v", err)
+	}
+	if X, err = records.Dense(X); err != nil {
+		log.Fatalf("Error loading data: %v", err)
+	}
+
+	projection, err := model.Transform(X)
+	if err != nil {
+		log.Fatalf("Error projecting data onto model: %v", err)
+	}
+	for _, u := range projection.Unprojected {
+		log.Printf("Warning: object %s could not be projected and has no scores, T² or SPE: %v", records.ObjectNames[u.Index], u.Err)
+	}
+
+	variableNames := model.VariableNames // Of the residuals
+	if len(variableNames) == 0 {
+		variableNames = records.VariableNames
+	}
+	results := PredictionResults{
+		VariableNames: variableNames,
+		ObjectNames:   records.ObjectNames,
+		NumComponents: model.NumComponents,
+		Scores:        utils.JSONMatrix(utils.DenseToSlice(projection.Scores)),
+		Residuals:     utils.JSONMatrix(utils.DenseToSlice(projection.Residuals)),
+		HotellingT2:   projection.HotellingT2,
+		SPE:           projection.SPE,
+		ControlLimits: model.PredictionLimits(),
+	}
+	if outputFile != "" {
+		saveResultsToFile(results, outputFile)
+	} else {
+		printPredictionResults(results)
+	}
+}
+
+// doInspection reports problems and descriptive statistics of a dataset,
+// after the same selections as the analysis. Cells that are not numbers are
+// reported rather than stop the loading.
+func doInspection(filename string) {
+	records, _, err := loadData(filename, true)
+	var parseErrs readdata.ParseErrors
+	if err != nil && !errors.As(err, &parseErrs) {
+		log.Fatalf("Error loading data: %v", err)
+	}
+	if records, _, err = records.SelectObjects(readdata.Selection{Include: includeObjsFlag, Exclude: excludeObjsFlag}); err != nil {
+		log.Fatalf("Error loading data: %v", err)
+	}
+	if variables := (readdata.Selection{Include: includeVarsFlag, Exclude: excludeVarsFlag}); !variables.IsEmpty() {
+		if records, _, err = records.SelectVariables(variables); err != nil {
+			log.Fatalf("Error loading data: %v", err)
+		}
+	}
+
+	report := inspect.Inspect(records, inspect.Options{
+		ConstantTolerance: constantTolFlag,
+		OutlierFactor:     outlierFactorFlag,
+		DDOF:              ddofFlag,
+		ParseErrors:       parseErrs,
+	})
+	printInspection(report)
+	if outputFile != "" {
+		fmt.Println()
+		saveResultsToFile(report, outputFile)
+	}
+}
+
+// matchModelVariables selects the variables of the model from the data, by
+// name and in the order of the model, so data with more variables than the
+// model (e.g. when the model was fitted to a selection) can be used. Models
+// without variable names are accepted.
+func matchModelVariables(modelNames []string, records readdata.ProcessedData) (readdata.ProcessedData, error) {
+	if len(modelNames) == 0 || slices.Equal(modelNames, records.VariableNames) {
+		return records, nil
+	}
+
+	index := make(map[string]int, len(records.VariableNames))
+	for j, name := range records.VariableNames {
+		index[name] = j
+	}
+	matched := readdata.ProcessedData{
+		VariableNames: modelNames,
+		ObjectNames:   records.ObjectNames,
+		Data:          make([][]float64, len(records.Data)),
+	}
+	for i, row := range records.Data {
+		matched.Data[i] = make([]float64, len(modelNames))
+		for k, name := range modelNames {
+			j, ok := index[name]
+			if !ok {
+				return readdata.ProcessedData{}, fmt.Errorf("variable %q of the model is not in the data", name)
+			}
+			matched.Data[i][k] = row[j]
+		}
+	}
+	return matched, nil
+}
+
+// prepareResults organizes PCA results into a structured format. The
+// variables dropped by the preprocessing are listed as excluded variables,
+// and the variable names are those of the loadings.
 func prepareResults(records readdata.ProcessedData, numComponents int,
-	T, P *mat.Dense, eigv, variancePercentages, Xmean, Xstd []float64) Results {
+	T, P *mat.Dense, eigv, variancePercentages []float64, pipeline *preprocess.Pipeline) Results {
+	dropped := pipeline.Dropped(len(records.VariableNames))
+	var kept, excluded []string
+	for j, name := range records.VariableNames {
+		if slices.Contains(dropped, j) {
+			excluded = append(excluded, name)
+		} else {
+			kept = append(kept, name)
+		}
+	}
 	return Results{
-		VariableNames:       records.VariableNames,
+		VariableNames:       kept,
 		ObjectNames:         records.ObjectNames,
+		NumObjects:          len(records.ObjectNames),
+		Metadata:            records.Metadata,
 		NumComponents:       numComponents,
 		Scores:              utils.DenseToSlice(T),
 		Loadings:            utils.DenseToSlice(P),
 		Eigenvalues:         eigv,
 		VariancePercentages: variancePercentages,
-		XMean:               Xmean,
-		XStd:                Xstd,
+		Preprocessing:       pipeline,
+		ExcludedVariables:   excluded,
 	}
 }
 
@@ -163,14 +789,10 @@ func outputResults(results Results) {
 }
 
 // saveResultsToFile saves PCA results to a JSON file.
-func saveResultsToFile(results Results, filename string) {
-	file, err := os.Create(filename)
-	if err != nil {
-		log.Fatalf("Failed to create output file: %v", err)
+func saveResultsToFile(results any, filename string) {
+	if err := utils.SaveJSON(filename, results); err != nil {
+		log.Fatalf("Failed to save results: %v", err)
 	}
-	defer file.Close()
-
-	json.NewEncoder(file).Encode(results)
 	fmt.Printf("Results saved to %s\n", filename)
 }
 
@@ -178,11 +800,169 @@ func saveResultsToFile(results Results, filename string) {
 func printResults(results Results) {
 	fmt.Printf("Variable names:\n%v\n", results.VariableNames)
 	fmt.Printf("Object names:\n%v\n", results.ObjectNames)
+	for _, m := range results.Metadata {
+		fmt.Printf("%s (%s):\n%v\n", m.Name, m.Type, m.Values)
+	}
+	if results.Selection != nil {
+		fmt.Printf("Excluded when loading: variables %v, objects %v\n", results.Selection.ExcludedVariables, results.Selection.ExcludedObjects)
+	}
 	fmt.Printf("Number of components: %v\n", results.NumComponents)
+	fmt.Printf("Algorithm: %v\n", results.Algorithm)
 	utils.PrettyPrintSlice(results.Scores, "Scores (T)")
 	utils.PrettyPrintSlice(results.Loadings, "Loadings (P)")
 	fmt.Printf("Eigenvalues:\n%v\n", results.Eigenvalues)
 	fmt.Printf("Variance percentages:\n%v\n", results.VariancePercentages)
-	fmt.Printf("X mean:\n%v\n", results.XMean)
-	fmt.Printf("X std:\n%v\n", results.XStd)
+	printPipeline(results.Preprocessing)
+	if len(results.ExcludedVariables) > 0 {
+		fmt.Printf("Excluded variables:\n%v\n", results.ExcludedVariables)
+	}
+	fmt.Printf("Hotelling's T2:\n%v\n", results.HotellingT2)
+	fmt.Printf("SPE (Q residuals):\n%v\n", results.SPE)
+	printControlLimits(results.ControlLimits)
+	printBlockVariance(results.BlockVariance)
+	printConvergence(results.Convergence)
+	printCrossValidation(results.CrossValidation)
+}
+
+// printBlockVariance displays the explained variance of each block in the console.
+func printBlockVariance(blocks []pca.BlockVariance) {
+	if len(blocks) == 0 {
+		return
+	}
+	fmt.Println("Block variance percentages:")
+	for _, b := range blocks {
+		fmt.Printf("%s (%d variables): %v\n", b.Block, b.NumVariables, b.VariancePercentages)
+	}
+}
+
+// printConvergence displays the NIPALS convergence report in the console.
+func printConvergence(report []pca.Convergence) {
+	if len(report) == 0 {
+		return
+	}
+	fmt.Println("NIPALS convergence:")
+	fmt.Printf("%10s %10s %14s %10s\n", "Component", "Iterations", "Change", "Converged")
+	for a, c := range report {
+		fmt.Printf("%10d %10d %14.6g %10v\n", a+1, c.Iterations, c.Change, c.Converged)
+	}
+}
+
+// printPipeline displays the preprocessing steps and their fitted parameters.
+func printPipeline(pipeline *preprocess.Pipeline) {
+	if pipeline == nil {
+		return
+	}
+	fmt.Printf("Preprocessing: %v\n", pipeline)
+	for _, step := range pipeline.Steps {
+		switch s := step.(type) {
+		case *preprocess.Centering:
+			fmt.Printf("X mean:\n%v\n", s.Mean)
+		case *preprocess.Scaling:
+			fmt.Printf("X scaling factors (%s):\n%v\n", s.Name(), s.Factors)
+			if len(s.Weights) > 0 {
+				fmt.Printf("X weights:\n%v\n", s.Weights)
+			}
+		case *preprocess.BlockScaling:
+			fmt.Printf("Block scaling factors:\n%v\n", s.Factors)
+		case *preprocess.SavitzkyGolay:
+			fmt.Printf("Savitzky-Golay: window %d, order %d, derivative %d, edge %s\n", s.Window, s.Order, s.Deriv, s.Edge)
+		}
+	}
+}
+
+// printCrossValidation displays the cross-validation curves in the console.
+func printCrossValidation(cv *crossval.Result) {
+	if cv == nil {
+		return
+	}
+	fmt.Printf("Cross-validation (%s, %d segments):\n", cv.Method, cv.NumSegments)
+	fmt.Printf("%10s %14s %14s %14s\n", "Components", "PRESS", "RMSECV", "Q2")
+	for a := range cv.PRESS {
+		fmt.Printf("%10d %14.6f %14.6f %14.6f\n", a+1, cv.PRESS[a], cv.RMSECV[a], cv.Q2[a])
+	}
+	fmt.Printf("Recommended number of components: %d\n", cv.Recommended)
+}
+
+// printControlLimits displays T² and SPE control limits in the console.
+func printControlLimits(limits []pca.ControlLimits) {
+	if len(limits) == 0 {
+		return
+	}
+	fmt.Println("Control limits:")
+	fmt.Printf("%10s %14s %14s %14s\n", "Confidence", "T2 (F)", "SPE (J-M)", "SPE (Box)")
+	for _, l := range limits {
+		fmt.Printf("%10.3f %14.6f %14.6f %14.6f\n", l.Confidence, l.HotellingT2, l.SPEJackson, l.SPEBox)
+	}
+}
+
+// printPredictionResults displays PCA prediction results in the console.
+func printPredictionResults(results PredictionResults) {
+	fmt.Printf("Variable names:\n%v\n", results.VariableNames)
+	fmt.Printf("Object names:\n%v\n", results.ObjectNames)
+	fmt.Printf("Number of components: %v\n", results.NumComponents)
+	utils.PrettyPrintSlice(results.Scores, "Scores (T)")
+	utils.PrettyPrintSlice(results.Residuals, "Residuals (E)")
+	fmt.Printf("Hotelling's T2:\n%v\n", results.HotellingT2)
+	fmt.Printf("SPE (Q residuals):\n%v\n", results.SPE)
+	printControlLimits(results.ControlLimits)
+}
+
+// printInspection displays the validation report of a dataset in the console.
+func printInspection(r *inspect.Report) {
+	fmt.Printf("Objects: %d, variables: %d, missing values: %d, infinite values: %d\n", r.NumObjects, r.NumVariables, r.NumMissing, r.NumNonFinite)
+	for _, d := range r.DuplicateObjects {
+		fmt.Printf("Duplicate object name %q at rows %v\n", d.Name, d.Positions)
+	}
+	for _, d := range r.DuplicateVariables {
+		fmt.Printf("Duplicate variable name %q at columns %v\n", d.Name, d.Positions)
+	}
+	if len(r.ConstantVariables) > 0 {
+		fmt.Printf("Constant variables: %s\n", strings.Join(r.ConstantVariables, ", "))
+	}
+	for _, e := range r.ParseErrors {
+		if e.Column == 0 {
+			fmt.Printf("Row left out: line %d: %s\n", e.Line, e.Error)
+		} else {
+			fmt.Printf("Not a number, read as missing: line %d, column %d (%s): %q: %s\n", e.Line, e.Column, e.Variable, e.Value, e.Error)
+		}
+	}
+
+	width := len("Variable")
+	for _, s := range r.Variables {
+		width = max(width, min(len(s.Label()), 24))
+	}
+	fmt.Println("Variables:")
+	fmt.Printf("%-*s %7s %7s %7s %12s %12s %12s %12s %12s %12s %12s %8s\n", width, "Variable",
+		"Count", "Missing", "Inf", "Mean", "Std", "Min", "Q1", "Median", "Q3", "Max", "Outliers")
+	for _, s := range r.Variables {
+		fmt.Printf("%-*s %7d %7d %7d %12.6g %12.6g %12.6g %12.6g %12.6g %12.6g %12.6g %8d\n", width, s.Label(),
+			s.Count, s.Missing, s.NonFinite, s.Mean, s.Std, s.Min, s.Q1, s.Median, s.Q3, s.Max, len(s.Outliers))
+	}
+
+	header := false
+	for _, o := range r.Objects {
+		if o.Missing+o.NonFinite == 0 {
+			continue
+		}
+		if !header {
+			fmt.Println("Objects with missing or infinite values:")
+			fmt.Printf("%-24s %7s %7s\n", "Object", "Missing", "Inf")
+			header = true
+		}
+		fmt.Printf("%-24s %7d %7d\n", o.Name, o.Missing, o.NonFinite)
+	}
+	for _, s := range r.Variables {
+		for _, o := range s.Outliers {
+			fmt.Printf("Extreme outlier: %s = %g in object %s\n", s.Label(), o.Value, o.Object)
+		}
+	}
+
+	if len(r.Problems) == 0 {
+		fmt.Println("No problems found")
+		return
+	}
+	fmt.Println("Problems:")
+	for _, p := range r.Problems {
+		fmt.Printf("  %s\n", p)
+	}
 }
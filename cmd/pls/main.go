@@ -0,0 +1,471 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bitjungle/goLV/pkg/pls"
+	"github.com/bitjungle/goLV/pkg/preprocess"
+	"github.com/bitjungle/goLV/pkg/readdata"
+	"github.com/bitjungle/goLV/pkg/utils"
+	"github.com/spf13/cobra"
+	"gonum.org/v1/gonum/mat"
+)
+
+// AppVersion will be set at compile time using -ldflags
+var AppVersion string
+
+// Command line flags.
+var (
+	scalingFlag       string
+	autoScaleFlag     bool
+	numComponentsFlag int
+	responseFlag      []string
+	outputFile        string
+	delimiterFlag     string
+	decimalFlag       string
+	commentFlag       string
+	encodingFlag      string
+	metadataFlag      []string
+	allErrorsFlag     bool
+	sheetFlag         string
+	rangeFlag         string
+)
+
+// Results struct to hold PLS regression results.
+type Results struct {
+	VariableNames  []string             `json:"variable_names"`
+	ResponseNames  []string             `json:"response_names"`
+	ObjectNames    []string             `json:"object_names"`
+	Metadata       []readdata.Metadata  `json:"metadata,omitempty"` // Class labels etc. of each object
+	NumComponents  int                  `json:"num_components"`
+	Scores         [][]float64          `json:"scores"`
+	YScores        [][]float64          `json:"y_scores"`
+	Weights        [][]float64          `json:"weights"`
+	Loadings       [][]float64          `json:"loadings"`
+	YLoadings      [][]float64          `json:"y_loadings"`
+	Coefficients   [][]float64          `json:"coefficients"`
+	FittedY        [][]float64          `json:"fitted_y"`
+	XVariance      []float64            `json:"x_variance_percentages"`
+	YVariance      []float64            `json:"y_variance_percentages"`
+	R2             []float64            `json:"r2"`
+	Q2             []float64            `json:"q2"`
+	XPreprocessing *preprocess.Pipeline `json:"x_preprocessing"`
+	YPreprocessing *preprocess.Pipeline `json:"y_preprocessing"`
+}
+
+// main function sets up and runs the Cobra command line application.
+func main() {
+	var rootCmd = &cobra.Command{
+		Use:   "pls",
+		Short: "goLV Partial Least Squares (PLS) regression",
+		Long: `goLV Partial Least Squares (PLS) regression - Copyright (C) 2024 BITJUNGLE Rune Mathisen. 
+		        This program is distributed under the Apache license version 2.0`,
+		Run: runRootCommand,
+	}
+
+	// Configuration of persistent flags for Cobra.
+	rootCmd.PersistentFlags().IntVarP(&numComponentsFlag, "comps", "c", -1, "Number of PLS components to compute")
+	rootCmd.PersistentFlags().StringVar(&scalingFlag, "scaling", "none", "Column scaling of X and Y: none, uv, pareto, range, vast or level")
+	rootCmd.PersistentFlags().BoolVarP(&autoScaleFlag, "scale", "s", false, "Apply autoscaling")
+	rootCmd.PersistentFlags().MarkDeprecated("scale", "use --scaling uv instead")
+	rootCmd.PersistentFlags().StringSliceVarP(&responseFlag, "response", "y", nil, "Name of response column(s), may be repeated or comma separated")
+	rootCmd.PersistentFlags().StringVar(&delimiterFlag, "delimiter", "auto", "CSV field delimiter: auto, a character or tab")
+	rootCmd.PersistentFlags().StringVar(&decimalFlag, "decimal", "auto", "Decimal separator: auto, . or , (auto detects it from the numbers in the data)")
+	rootCmd.PersistentFlags().StringVar(&commentFlag, "comment", "", "Skip CSV lines starting with this character (optional)")
+	rootCmd.PersistentFlags().StringVar(&encodingFlag, "encoding", readdata.EncodingAuto, "Character encoding: auto, utf-8, utf-16, latin1 or windows-1252")
+	rootCmd.PersistentFlags().StringSliceVar(&metadataFlag, "metadata", nil, "Non-numeric columns, e.g. class labels, batch IDs or dates, kept as metadata (optional)")
+	rootCmd.PersistentFlags().BoolVar(&allErrorsFlag, "all-errors", false, "Report every cell that is not a number, rather than stop at the first")
+	rootCmd.PersistentFlags().StringVar(&sheetFlag, "sheet", "", "Sheet to read from .xlsx files (default the first sheet)")
+	rootCmd.PersistentFlags().StringVar(&rangeFlag, "range", "", "Cell range to read from .xlsx files, e.g. A2:F9 (default all used cells)")
+	rootCmd.PersistentFlags().StringVarP(&outputFile, "output", "o", "", "Path to output results as a JSON file (optional)")
+
+	if err := rootCmd.Execute(); err != nil {
+		log.Fatalf("Command execution error: %v", err)
+	}
+}
+
+// runRootCommand is the primary function executed by Cobra on run.
+func runRootCommand(cmd *cobra.Command, args []string) {
+	fmt.Println("goLV Partial Least Squares (PLS) regression version", AppVersion, "running...")
+	fmt.Println()
+
+	if len(args) < 1 {
+		log.Fatal("Please provide a data file, or - to read CSV from standard input")
+	}
+	if len(responseFlag) == 0 {
+		log.Fatal("Please name at least one response column with --response")
+	}
+
+	doAnalysis(args[0])
+}
+
+// loadData reads the data file (see readdata.Load) and splits it into
+// predictor and response matrices.
+func loadData(filename string, responses []string) (readdata.ProcessedData, []string, *mat.Dense, *mat.Dense, error) {
+	opts, err := csvOptions()
+	if err != nil {
+		return readdata.ProcessedData{}, nil, nil, nil, err
+	}
+	records, _, err := readdata.Load(filename, readdata.LoadOptions{CSV: opts, Sheet: sheetFlag, Range: rangeFlag})
+	if err != nil {
+		return readdata.ProcessedData{}, nil, nil, nil, err
+	}
+	xNames, X, Y, err := splitResponses(records, responses)
+	if err != nil {
+		return readdata.ProcessedData{}, nil, nil, nil, err
+	}
+	return records, xNames, X, Y, nil
+}
+
+// csvOptions collects the CSV dialect options given on the command line.
+func csvOptions() (readdata.CSVOptions, error) {
+	opts, err := readdata.ParseCSVOptions(delimiterFlag, decimalFlag, commentFlag, encodingFlag)
+	opts.Metadata = metadataFlag
+	opts.CollectErrors = allErrorsFlag
+	return opts, err
+}
+
+// splitResponses separates the named response columns from the predictor
+// columns. It returns the predictor names, the X matrix and the Y matrix.
+func splitResponses(records readdata.ProcessedData, responses []string) ([]string, *mat.Dense, *mat.Dense, error) {
+	index := make(map[string]int, len(records.VariableNames))
+	for j, name := range records.VariableNames {
+		index[name] = j
+	}
+
+	isResponse := make(map[int]bool, len(responses))
+	yCols := make([]int, len(responses))
+	for k, name := range responses {
+		j, ok := index[name]
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("response column %q not found", name)
+		}
+		if isResponse[j] {
+			return nil, nil, nil, fmt.Errorf("response column %q given more than once", name)
+		}
+		isResponse[j] = true
+		yCols[k] = j
+	}
+
+	var xNames []string
+	var xCols []int
+	for j, name := range records.VariableNames {
+		if !isResponse[j] {
+			xNames = append(xNames, name)
+			xCols = append(xCols, j)
+		}
+	}
+	if len(xCols) == 0 {
+		return nil, nil, nil, fmt.Errorf("no predictor columns left after removing responses")
+	}
+
+	rows := len(records.Data)
+	X := mat.NewDense(rows, len(xCols), nil)
+	Y := mat.NewDense(rows, len(yCols), nil)
+	for i, row := range records.Data {
+		if len(row) != len(records.VariableNames) {
+			return nil, nil, nil, fmt.Errorf("row %d has %d values, expected %d", i+1, len(row), len(records.VariableNames))
+		}
+		for k, j := range xCols {
+			X.Set(i, k, row[j])
+		}
+		for k, j := range yCols {
+			Y.Set(i, k, row[j])
+		}
+	}
+	return xNames, X, Y, nil
+}
+
+// determineNumComponents determines the number of PLS components to compute.
+// At most min(cols, rows-2) components are computed, as the leave-one-out
+// cross-validation fits models to rows-1 objects. A warning is given if more
+// components were requested.
+func determineNumComponents(X *mat.Dense) (int, error) {
+	rows, cols := X.Dims()
+	if rows < 3 {
+		return 0, fmt.Errorf("at least 3 objects are needed for cross-validation, got %d", rows)
+	}
+	maxComponents := min(cols, rows-2)
+	if numComponentsFlag <= 0 {
+		return maxComponents, nil
+	}
+	if numComponentsFlag > maxComponents {
+		log.Printf("Warning: %d components requested, using %d (at most min(predictors, objects-2))", numComponentsFlag, maxComponents)
+		return maxComponents, nil
+	}
+	return numComponentsFlag, nil
+}
+
+// buildPipeline creates the preprocessing of X or Y given by the --scaling
+// flag: the scaling, if any, followed by mean centering, as in pca.
+func buildPipeline() (*preprocess.Pipeline, error) {
+	if autoScaleFlag && scalingFlag != "" && scalingFlag != preprocess.ScalingNone {
+		return nil, fmt.Errorf("use either --scaling or --scale, not both")
+	}
+	scaling, err := preprocess.ScalingStep(scalingFlag)
+	if err != nil {
+		return nil, err
+	}
+	if autoScaleFlag {
+		scaling = preprocess.StepScale
+	}
+	var steps []string
+	if scaling != "" {
+		steps = append(steps, scaling)
+	}
+	steps = append(steps, preprocess.StepCenter)
+	return preprocess.ParsePipeline(strings.Join(steps, ","))
+}
+
+// preprocessData fits a new preprocessing pipeline to a matrix, returning
+// the fitted pipeline and the preprocessed matrix.
+func preprocessData(X *mat.Dense) (*preprocess.Pipeline, *mat.Dense, error) {
+	pipeline, err := buildPipeline()
+	if err != nil {
+		return nil, nil, err
+	}
+	Xpre, err := pipeline.FitApply(X)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pipeline, Xpre, nil
+}
+
+// doAnalysis orchestrates the PLS regression.
+func doAnalysis(filename string) {
+	// Load data
+	records, xNames, X, Y, err := loadData(filename, responseFlag)
+	if err != nil {
+		log.Fatalf("Error loading data: %v", err)
+	}
+
+	// Determine the number of components
+	numComponents, err := determineNumComponents(X)
+	if err != nil {
+		log.Fatalf("Error determining the number of components: %v", err)
+	}
+
+	// Preprocess the data (optional scaling and mean centering)
+	xPipeline, Xpre, err := preprocessData(X)
+	if err != nil {
+		log.Fatalf("Error preprocessing X: %v", err)
+	}
+	yPipeline, Ypre, err := preprocessData(Y)
+	if err != nil {
+		log.Fatalf("Error preprocessing Y: %v", err)
+	}
+
+	// Perform PLS
+	model, err := pls.NIPALS(Xpre, Ypre, numComponents)
+	if err != nil {
+		log.Fatalf("Error performing NIPALS PLS: %v", err)
+	}
+	fittedY, err := yPipeline.Invert(pls.Predict(Xpre, model.B))
+	if err != nil {
+		log.Fatalf("Error calculating fitted Y: %v", err)
+	}
+
+	r2, err := calculateR2(Xpre, Y, model, yPipeline)
+	if err != nil {
+		log.Fatalf("Error calculating R2: %v", err)
+	}
+	q2, err := calculateQ2(X, Y, numComponents)
+	if err != nil {
+		log.Fatalf("Error calculating Q2: %v", err)
+	}
+
+	// Prepare and output the results
+	results := Results{
+		VariableNames:  xNames,
+		ResponseNames:  responseFlag,
+		ObjectNames:    records.ObjectNames,
+		Metadata:       records.Metadata,
+		NumComponents:  numComponents,
+		Scores:         utils.DenseToSlice(model.T),
+		YScores:        utils.DenseToSlice(model.U),
+		Weights:        utils.DenseToSlice(model.W),
+		Loadings:       utils.DenseToSlice(model.P),
+		YLoadings:      utils.DenseToSlice(model.Q),
+		Coefficients:   utils.DenseToSlice(model.B),
+		FittedY:        utils.DenseToSlice(fittedY),
+		XVariance:      model.XVariance,
+		YVariance:      model.YVariance,
+		R2:             r2,
+		Q2:             q2,
+		XPreprocessing: xPipeline,
+		YPreprocessing: yPipeline,
+	}
+	outputResults(results)
+}
+
+// coefficientsForComponents calculates the regression coefficients of a
+// model truncated to its first a components.
+func coefficientsForComponents(model *pls.Result, a int) (*mat.Dense, error) {
+	xCols, _ := model.W.Dims()
+	yCols, _ := model.Q.Dims()
+	return pls.Coefficients(
+		model.W.Slice(0, xCols, 0, a),
+		model.P.Slice(0, xCols, 0, a),
+		model.Q.Slice(0, yCols, 0, a),
+	)
+}
+
+// calculateR2 calculates the cumulative explained variance of Y (R²) for
+// 1..numComponents components, in the original units of Y.
+func calculateR2(Xpre, Y *mat.Dense, model *pls.Result, yPipeline *preprocess.Pipeline) ([]float64, error) {
+	_, numComponents := model.T.Dims()
+	_, Ymean := preprocess.MeanCenter(Y)
+	ssTotal := totalSumOfSquares(Y, Ymean)
+	r2 := make([]float64, numComponents)
+	for a := 1; a <= numComponents; a++ {
+		B, err := coefficientsForComponents(model, a)
+		if err != nil {
+			return nil, err
+		}
+		fitted, err := yPipeline.Invert(pls.Predict(Xpre, B))
+		if err != nil {
+			return nil, err
+		}
+		r2[a-1] = 1 - residualSumOfSquares(Y, fitted)/ssTotal
+	}
+	return r2, nil
+}
+
+// calculateQ2 calculates the cumulative cross-validated explained variance
+// of Y (Q²) for 1..numComponents components using leave-one-out
+// cross-validation. The preprocessing is refitted for every left out object,
+// so numComponents must be at most rows-2.
+func calculateQ2(X, Y *mat.Dense, numComponents int) ([]float64, error) {
+	rows, xCols := X.Dims()
+	_, yCols := Y.Dims()
+	if numComponents > rows-2 {
+		return nil, fmt.Errorf("%d components cannot be cross-validated with %d objects", numComponents, rows)
+	}
+
+	_, Ymean := preprocess.MeanCenter(Y)
+	ssTotal := totalSumOfSquares(Y, Ymean)
+	press := make([]float64, numComponents)
+
+	for out := 0; out < rows; out++ {
+		Xtrain := mat.NewDense(rows-1, xCols, nil)
+		Ytrain := mat.NewDense(rows-1, yCols, nil)
+		k := 0
+		for i := 0; i < rows; i++ {
+			if i == out {
+				continue
+			}
+			Xtrain.SetRow(k, mat.Row(nil, i, X))
+			Ytrain.SetRow(k, mat.Row(nil, i, Y))
+			k++
+		}
+
+		xPipeline, XtrainPre, err := preprocessData(Xtrain)
+		if err != nil {
+			return nil, err
+		}
+		yPipeline, YtrainPre, err := preprocessData(Ytrain)
+		if err != nil {
+			return nil, err
+		}
+		model, err := pls.NIPALS(XtrainPre, YtrainPre, numComponents)
+		if err != nil {
+			return nil, err
+		}
+
+		Xout, err := xPipeline.Apply(mat.DenseCopyOf(X.Slice(out, out+1, 0, xCols)))
+		if err != nil {
+			return nil, err
+		}
+		Yout := Y.Slice(out, out+1, 0, yCols).(*mat.Dense)
+		for a := 1; a <= numComponents; a++ {
+			B, err := coefficientsForComponents(model, a)
+			if err != nil {
+				return nil, err
+			}
+			predicted, err := yPipeline.Invert(pls.Predict(Xout, B))
+			if err != nil {
+				return nil, err
+			}
+			press[a-1] += residualSumOfSquares(Yout, predicted)
+		}
+	}
+
+	q2 := make([]float64, numComponents)
+	for a := range press {
+		q2[a] = 1 - press[a]/ssTotal
+	}
+	return q2, nil
+}
+
+// totalSumOfSquares calculates the sum of squared deviations from the column means.
+func totalSumOfSquares(Y *mat.Dense, mean []float64) float64 {
+	r, c := Y.Dims()
+	var ss float64
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			d := Y.At(i, j) - mean[j]
+			ss += d * d
+		}
+	}
+	return ss
+}
+
+// residualSumOfSquares calculates the sum of squared differences between two matrices.
+func residualSumOfSquares(Y, Yhat mat.Matrix) float64 {
+	var diff mat.Dense
+	diff.Sub(Y, Yhat)
+	norm := mat.Norm(&diff, 2)
+	return norm * norm
+}
+
+// outputResults handles outputting the results either to console or file.
+func outputResults(results Results) {
+	if outputFile != "" {
+		saveResultsToFile(results, outputFile)
+	} else {
+		printResults(results)
+	}
+}
+
+// saveResultsToFile saves PLS results to a JSON file.
+func saveResultsToFile(results Results, filename string) {
+	if err := utils.SaveJSON(filename, results); err != nil {
+		log.Fatalf("Failed to save results: %v", err)
+	}
+	fmt.Printf("Results saved to %s\n", filename)
+}
+
+// printResults displays PLS results in the console.
+func printResults(results Results) {
+	fmt.Printf("Variable names:\n%v\n", results.VariableNames)
+	fmt.Printf("Response names:\n%v\n", results.ResponseNames)
+	fmt.Printf("Object names:\n%v\n", results.ObjectNames)
+	fmt.Printf("Number of components: %v\n", results.NumComponents)
+	utils.PrettyPrintSlice(results.Scores, "Scores (T)")
+	utils.PrettyPrintSlice(results.Weights, "Weights (W)")
+	utils.PrettyPrintSlice(results.Loadings, "Loadings (P)")
+	utils.PrettyPrintSlice(results.YLoadings, "Y loadings (Q)")
+	utils.PrettyPrintSlice(results.Coefficients, "Regression coefficients (B)")
+	utils.PrettyPrintSlice(results.FittedY, "Fitted Y")
+	fmt.Printf("X variance percentages:\n%v\n", results.XVariance)
+	fmt.Printf("Y variance percentages:\n%v\n", results.YVariance)
+	fmt.Printf("R2:\n%v\n", results.R2)
+	fmt.Printf("Q2:\n%v\n", results.Q2)
+	fmt.Printf("X preprocessing: %v\n", results.XPreprocessing)
+	fmt.Printf("Y preprocessing: %v\n", results.YPreprocessing)
+}
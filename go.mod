@@ -2,10 +2,14 @@ module github.com/bitjungle/goLV
 
 go 1.22.1
 
-require gonum.org/v1/gonum v0.15.0
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/spf13/cobra v1.8.0
+	gonum.org/v1/gonum v0.15.0
+)
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/cobra v1.8.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/exp v0.0.0-20231110203233-9a3e6036ecaa // indirect
 )
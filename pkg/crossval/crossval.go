@@ -0,0 +1,323 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This package contains cross-validation segmentation and
+// cross-validation of PCA models for choosing the number of components.
+package crossval
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/bitjungle/goLV/pkg/pca"
+	"github.com/bitjungle/goLV/pkg/preprocess"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Cross-validation segmentation methods.
+const (
+	LeaveOneOut      = "loo"      // One object per segment
+	KFold            = "kfold"    // Random segments of (nearly) equal size
+	VenetianBlinds   = "venetian" // Object i in segment i mod k
+	ContiguousBlocks = "blocks"   // Consecutive objects in each segment
+	Column           = "column"   // Segments given by a user supplied column
+)
+
+// Result holds the cross-validation curves of a PCA model, with one element
+// per number of components (1, 2, ...).
+type Result struct {
+	Method      string    `json:"method"`
+	NumSegments int       `json:"num_segments"`
+	PRESS       []float64 `json:"press"`
+	RMSECV      []float64 `json:"rmsecv"`
+	Q2          []float64 `json:"q2"`
+	Recommended int       `json:"recommended_components"`
+}
+
+// Segments splits numObjects objects into numSegments cross-validation
+// segments using the given method. Each segment is a sorted list of the
+// indices of the objects left out in that segment. The seed is only used by
+// the k-fold method.
+func Segments(method string, numObjects, numSegments int, seed int64) ([][]int, error) {
+	if method == LeaveOneOut {
+		numSegments = numObjects
+	}
+	if numSegments < 2 || numSegments > numObjects {
+		return nil, fmt.Errorf("number of segments must be between 2 and %d, got %d", numObjects, numSegments)
+	}
+
+	segments := make([][]int, numSegments)
+	switch method {
+	case LeaveOneOut, VenetianBlinds:
+		for i := 0; i < numObjects; i++ {
+			segments[i%numSegments] = append(segments[i%numSegments], i)
+		}
+	case ContiguousBlocks:
+		for i := 0; i < numObjects; i++ {
+			s := i * numSegments / numObjects
+			segments[s] = append(segments[s], i)
+		}
+	case KFold:
+		order := rand.New(rand.NewSource(seed)).Perm(numObjects)
+		for k, i := range order {
+			segments[k%numSegments] = append(segments[k%numSegments], i)
+		}
+		for _, segment := range segments {
+			sort.Ints(segment)
+		}
+	default:
+		return nil, fmt.Errorf("unknown cross-validation method %q", method)
+	}
+	return segments, nil
+}
+
+// SegmentsFromLabels creates one cross-validation segment for each distinct
+// label, in order of first appearance.
+func SegmentsFromLabels(labels []string) ([][]int, error) {
+	index := make(map[string]int)
+	var segments [][]int
+	for i, label := range labels {
+		s, ok := index[label]
+		if !ok {
+			s = len(segments)
+			index[label] = s
+			segments = append(segments, nil)
+		}
+		segments[s] = append(segments[s], i)
+	}
+	if len(segments) < 2 {
+		return nil, fmt.Errorf("at least 2 distinct segment labels are needed, got %d", len(segments))
+	}
+	return segments, nil
+}
+
+// PCA cross-validates PCA models with 1..opts.NumComponents components.
+//
+// X: Raw data matrix.
+// pipeline: The preprocessing, which is refitted on the training objects of
+// each segment. Columns dropped by the preprocessing are those dropped when
+// fitted to all of X. The pipeline itself is not changed.
+// segments: The objects left out in each segment, see Segments.
+// opts: The PCA options used to fit the model of each segment.
+//
+// For each left out object, every variable is predicted from a projection of
+// the object's other variables onto the model. With orthonormal loadings P
+// this has the closed form
+//
+//	e_ij = r_ij / (1 - h_j)
+//
+// where r_ij is the ordinary residual of the element and h_j the sum of the
+// squared loadings of variable j. This avoids the overly optimistic PRESS
+// values obtained by projecting the complete left out object. Since a
+// variable cannot be predicted once the model spans all variables, at most
+// cols-1 components are meaningful. Missing (NaN) values are skipped.
+func PCA(X *mat.Dense, segments [][]int, pipeline *preprocess.Pipeline, opts pca.Options) (*Result, error) {
+	rows, cols := X.Dims()
+	numComponents := opts.NumComponents
+	left := make([]bool, rows)
+	for _, segment := range segments {
+		for _, i := range segment {
+			if i < 0 || i >= rows {
+				return nil, fmt.Errorf("segment index %d out of range", i)
+			}
+			left[i] = true
+		}
+		if len(segment) >= rows-1 {
+			return nil, fmt.Errorf("a segment leaves out %d of %d objects, too few remain for training", len(segment), rows)
+		}
+	}
+	for i, ok := range left {
+		if !ok {
+			return nil, fmt.Errorf("object %d is not in any segment", i+1)
+		}
+	}
+
+	// Settle the columns dropped by the preprocessing, e.g. constant
+	// columns, on all the data, so every segment models the same variables
+	settled := pipeline.Clone()
+	if _, err := settled.FitApply(X); err != nil {
+		return nil, err
+	}
+
+	press := make([]float64, numComponents)
+	var ssTotal float64
+	var numElements int
+
+	for _, segment := range segments {
+		train, test := split(X, segment)
+		trainRows, _ := train.Dims()
+		comps := min(numComponents, cols, trainRows-1)
+
+		segmentPipeline := settled.Clone()
+		segmentPipeline.SettleDropped()
+		Xpre, err := segmentPipeline.FitApply(train)
+		if err != nil {
+			return nil, err
+		}
+		testPre, err := segmentPipeline.Apply(test)
+		if err != nil {
+			return nil, err
+		}
+		_, preCols := Xpre.Dims()
+		comps = min(comps, preCols)
+
+		segmentOpts := opts
+		segmentOpts.NumComponents = comps
+		model, err := pca.Fit(Xpre, segmentOpts)
+		if err != nil {
+			return nil, err
+		}
+		P := model.Loadings
+
+		testRows, _ := testPre.Dims()
+		for i := 0; i < testRows; i++ {
+			x := testPre.RawRowView(i)
+			for j := range x {
+				if math.IsNaN(x[j]) {
+					continue
+				}
+				ssTotal += x[j] * x[j]
+				numElements++
+			}
+			for a := 1; a <= numComponents; a++ {
+				press[a-1] += elementPRESS(x, P, min(a, comps))
+			}
+		}
+	}
+
+	result := &Result{
+		NumSegments: len(segments),
+		PRESS:       press,
+		RMSECV:      make([]float64, numComponents),
+		Q2:          make([]float64, numComponents),
+	}
+	for a := range press {
+		result.RMSECV[a] = math.Sqrt(press[a] / float64(numElements))
+		result.Q2[a] = 1 - press[a]/ssTotal
+	}
+	result.Recommended = RecommendComponents(press, ssTotal)
+	return result, nil
+}
+
+// RecommendComponents recommends a number of components from a PRESS curve.
+// Components are added as long as each new component reduces PRESS by at
+// least 5% (Wold's R criterion, R = PRESS(a)/PRESS(a-1) < 0.95), where
+// PRESS(0) is the total sum of squares. At least one component is recommended.
+func RecommendComponents(press []float64, ssTotal float64) int {
+	previous := ssTotal
+	recommended := 0
+	for a, p := range press {
+		if previous == 0 || p/previous >= 0.95 {
+			break
+		}
+		recommended = a + 1
+		previous = p
+	}
+	return max(recommended, 1)
+}
+
+// leverageTol is the smallest 1-h for which an element is predicted from
+// the other variables of its object. A variable with a leverage h closer to
+// one is almost entirely described by the loadings of its own, so it cannot
+// be predicted from the others.
+const leverageTol = 1e-6
+
+// elementPRESS calculates the sum of squared cross-validated prediction
+// errors for the elements of a preprocessed object x, using the first a
+// loadings in P. Each observed element is predicted by the least squares
+// scores of the other observed elements of the object, as when projecting an
+// object with missing values. An element that cannot be predicted counts
+// with its full value.
+func elementPRESS(x []float64, P *mat.Dense, a int) float64 {
+	var observed []int
+	for j := range x {
+		if !math.IsNaN(x[j]) {
+			observed = append(observed, j)
+		}
+	}
+
+	unpredictable := func() float64 {
+		var ss float64
+		for _, j := range observed {
+			ss += x[j] * x[j]
+		}
+		return ss
+	}
+	if len(observed) <= a {
+		return unpredictable()
+	}
+
+	// Least squares scores of the observed elements, t = (PᵀP)⁻¹Pᵀx, where
+	// PᵀP is the identity matrix for an object without missing values
+	Pobs := mat.NewDense(len(observed), a, nil)
+	xObs := mat.NewVecDense(len(observed), nil)
+	for k, j := range observed {
+		Pobs.SetRow(k, P.RawRowView(j)[:a])
+		xObs.SetVec(k, x[j])
+	}
+	var G mat.SymDense
+	G.SymOuterK(1, Pobs.T())
+	var chol mat.Cholesky
+	if !chol.Factorize(&G) {
+		return unpredictable()
+	}
+	var Ptx, t mat.VecDense
+	Ptx.MulVec(Pobs.T(), xObs)
+	if err := chol.SolveVecTo(&t, &Ptx); err != nil {
+		return unpredictable()
+	}
+
+	// The prediction error of each element left out of the fit is the
+	// residual divided by 1-h, with the leverage h = pᵀ(PᵀP)⁻¹p
+	var press float64
+	var Gp mat.VecDense
+	for k, j := range observed {
+		p := Pobs.RowView(k)
+		if err := chol.SolveVecTo(&Gp, p); err != nil {
+			return unpredictable()
+		}
+		h := mat.Dot(p, &Gp)
+		e := x[j]
+		if 1-h > leverageTol {
+			e = (x[j] - mat.Dot(p, &t)) / (1 - h)
+		}
+		press += e * e
+	}
+	return press
+}
+
+// split divides the rows of X into a training set and a test set.
+func split(X *mat.Dense, test []int) (*mat.Dense, *mat.Dense) {
+	rows, cols := X.Dims()
+	isTest := make(map[int]bool, len(test))
+	for _, i := range test {
+		isTest[i] = true
+	}
+
+	train := mat.NewDense(rows-len(test), cols, nil)
+	testX := mat.NewDense(len(test), cols, nil)
+	var k int
+	for i := 0; i < rows; i++ {
+		if !isTest[i] {
+			train.SetRow(k, X.RawRowView(i))
+			k++
+		}
+	}
+	for k, i := range test {
+		testX.SetRow(k, X.RawRowView(i))
+	}
+	return train, testX
+}
@@ -0,0 +1,146 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the crossval package.
+package crossval
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/bitjungle/goLV/pkg/pca"
+	"github.com/bitjungle/goLV/pkg/preprocess"
+	"gonum.org/v1/gonum/mat"
+)
+
+// TestSegments checks the segmentation methods.
+func TestSegments(t *testing.T) {
+	testCases := []struct {
+		name     string
+		method   string
+		segments int
+		want     [][]int
+	}{
+		{"Leave one out", LeaveOneOut, 0, [][]int{{0}, {1}, {2}, {3}, {4}}},
+		{"Venetian blinds", VenetianBlinds, 2, [][]int{{0, 2, 4}, {1, 3}}},
+		{"Contiguous blocks", ContiguousBlocks, 2, [][]int{{0, 1, 2}, {3, 4}}},
+	}
+
+	for _, tc := range testCases {
+		got, err := Segments(tc.method, 5, tc.segments, 0)
+		if err != nil {
+			t.Errorf("%s: Segments() error = %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: Segments() got = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// k-fold must cover every object exactly once
+	got, err := Segments(KFold, 10, 3, 42)
+	if err != nil {
+		t.Fatalf("Segments() error = %v", err)
+	}
+	seen := make(map[int]int)
+	for _, segment := range got {
+		for _, i := range segment {
+			seen[i]++
+		}
+	}
+	if len(seen) != 10 {
+		t.Errorf("k-fold segments cover %d objects, want 10", len(seen))
+	}
+
+	if _, err := Segments("unknown", 5, 2, 0); err == nil {
+		t.Errorf("Segments() expected an error for an unknown method")
+	}
+}
+
+// TestSegmentsFromLabels checks that segments follow the labels.
+func TestSegmentsFromLabels(t *testing.T) {
+	got, err := SegmentsFromLabels([]string{"b", "a", "b", "c", "a"})
+	if err != nil {
+		t.Fatalf("SegmentsFromLabels() error = %v", err)
+	}
+	want := [][]int{{0, 2}, {1, 4}, {3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SegmentsFromLabels() got = %v, want %v", got, want)
+	}
+}
+
+// TestPCA checks that cross-validation recommends one component for data
+// with one strong underlying component.
+func TestPCA(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	data := []float64{}
+	for i := 0; i < 20; i++ {
+		s := float64(i) - 9.5
+		for j := 0; j < 4; j++ {
+			data = append(data, s*float64(j+1)+0.01*rnd.NormFloat64())
+		}
+	}
+	X := mat.NewDense(20, 4, data)
+
+	segments, err := Segments(VenetianBlinds, 20, 5, 0)
+	if err != nil {
+		t.Fatalf("Segments() error = %v", err)
+	}
+	pipeline := &preprocess.Pipeline{Steps: []preprocess.Step{&preprocess.Centering{}}}
+	result, err := PCA(X, segments, pipeline, pca.Options{NumComponents: 3})
+	if err != nil {
+		t.Fatalf("PCA() error = %v", err)
+	}
+	if result.Recommended != 1 {
+		t.Errorf("PCA() recommended %d components, want 1 (PRESS: %v)", result.Recommended, result.PRESS)
+	}
+	if result.Q2[0] < 0.99 {
+		t.Errorf("PCA() Q2 for one component = %v, want > 0.99", result.Q2[0])
+	}
+}
+
+// TestPCAMissing checks that objects with missing values are predicted from
+// their other variables, so PRESS stays below the total sum of squares.
+func TestPCAMissing(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	X := mat.NewDense(12, 5, nil)
+	for i := 0; i < 12; i++ {
+		s1, s2 := rnd.NormFloat64(), rnd.NormFloat64()
+		for j := 0; j < 5; j++ {
+			X.Set(i, j, s1*float64(j+1)+s2*float64(5-j)+0.05*rnd.NormFloat64())
+		}
+	}
+	X.Set(3, 1, math.NaN())
+	X.Set(7, 4, math.NaN())
+
+	segments, err := Segments(LeaveOneOut, 12, 0, 0)
+	if err != nil {
+		t.Fatalf("Segments() error = %v", err)
+	}
+	pipeline := &preprocess.Pipeline{Steps: []preprocess.Step{&preprocess.Centering{}}}
+	result, err := PCA(X, segments, pipeline, pca.Options{NumComponents: 4})
+	if err != nil {
+		t.Fatalf("PCA() error = %v", err)
+	}
+	for a, q2 := range result.Q2 {
+		if !(q2 > 0) {
+			t.Errorf("PCA() Q2 for %d components = %v, want PRESS below the total sum of squares (PRESS: %v)", a+1, q2, result.PRESS)
+		}
+	}
+	if result.Recommended != 2 {
+		t.Errorf("PCA() recommended %d components, want 2 (PRESS: %v)", result.Recommended, result.PRESS)
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This package contains a validation report of a dataset, to
+// sanity-check data before modeling: duplicate names, missing and
+// non-finite values, constant variables, extreme outliers and descriptive
+// statistics.
+package inspect
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/bitjungle/goLV/pkg/preprocess"
+	"github.com/bitjungle/goLV/pkg/readdata"
+	"gonum.org/v1/gonum/mat"
+)
+
+// DefaultOutlierFactor is the default multiple of the interquartile range
+// beyond the quartiles at which a value is an extreme outlier (Tukey's
+// outer fences).
+const DefaultOutlierFactor = 3.0
+
+// Options configures the report.
+type Options struct {
+	ConstantTolerance float64 // Relative tolerance for near-constant variables, preprocess.DefaultConstantTolerance if zero
+	OutlierFactor     float64 // Multiple of the interquartile range for extreme outliers, DefaultOutlierFactor if zero
+	DDOF              int     // Standard deviation denominator n - DDOF: 0 for population, 1 for sample
+
+	// ParseErrors are the cells and rows that could not be read when the
+	// data was loaded with CSVOptions.CollectErrors, to include in the report
+	ParseErrors readdata.ParseErrors
+}
+
+// Report is the validation report of a dataset. Positions are counted
+// from 1.
+type Report struct {
+	NumObjects         int             `json:"num_objects"`
+	NumVariables       int             `json:"num_variables"`
+	NumMissing         int             `json:"num_missing"`
+	NumNonFinite       int             `json:"num_non_finite"` // Infinite values
+	DuplicateObjects   []Duplicate     `json:"duplicate_objects,omitempty"`
+	DuplicateVariables []Duplicate     `json:"duplicate_variables,omitempty"`
+	ConstantVariables  []string        `json:"constant_variables,omitempty"` // By VariableStats.Label
+	ParseErrors        []ParseError    `json:"parse_errors,omitempty"`
+	Objects            []ObjectStats   `json:"objects"`
+	Variables          []VariableStats `json:"variables"`
+	Problems           []string        `json:"problems,omitempty"` // Summary of the problems found, if any
+}
+
+// ParseError is a cell that is not a number, or a row with the wrong number
+// of fields, found when loading the data. Bad cells are missing values in
+// the report, and bad rows are left out.
+type ParseError struct {
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"` // Zero for a whole row
+	Variable string `json:"variable,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Error    string `json:"error"`
+}
+
+// Duplicate is a name used for more than one object or variable.
+type Duplicate struct {
+	Name      string `json:"name"`
+	Positions []int  `json:"positions"`
+}
+
+// ObjectStats counts the missing and non-finite values of an object.
+type ObjectStats struct {
+	Name      string `json:"name"`
+	Missing   int    `json:"missing"`
+	NonFinite int    `json:"non_finite"`
+}
+
+// VariableStats holds the descriptive statistics of a variable. They are
+// calculated from the finite values, and are zero if there are none. The
+// quartiles are interpolated linearly between the sorted values.
+type VariableStats struct {
+	Name      string    `json:"name"`
+	Column    int       `json:"column"` // Column of the variable, from 1
+	Count     int       `json:"count"`  // Number of finite values
+	Missing   int       `json:"missing"`
+	NonFinite int       `json:"non_finite"`
+	Mean      float64   `json:"mean"`
+	Std       float64   `json:"std"` // Zero if Count - DDOF is not positive
+	Min       float64   `json:"min"`
+	Q1        float64   `json:"q1"`
+	Median    float64   `json:"median"`
+	Q3        float64   `json:"q3"`
+	Max       float64   `json:"max"`
+	Constant  bool      `json:"constant"`
+	Outliers  []Outlier `json:"outliers,omitempty"`
+}
+
+// Label returns the name of the variable, or its column if the name is
+// empty, e.g. in a workbook without a header row.
+func (s VariableStats) Label() string {
+	if s.Name == "" {
+		return fmt.Sprintf("column %d", s.Column)
+	}
+	return s.Name
+}
+
+// Outlier is a value outside the outer fences of its variable, i.e. more
+// than OutlierFactor interquartile ranges below Q1 or above Q3.
+type Outlier struct {
+	Object string  `json:"object"`
+	Value  float64 `json:"value"`
+}
+
+// Inspect creates the validation report of the data. Missing values are
+// NaN, and non-finite values are the infinities, which are not counted as
+// outliers. Variables with an interquartile range of zero have no outliers,
+// as every value different from the quartiles would be one.
+func Inspect(d readdata.ProcessedData, opts Options) *Report {
+	if opts.ConstantTolerance == 0 {
+		opts.ConstantTolerance = preprocess.DefaultConstantTolerance
+	}
+	if opts.OutlierFactor == 0 {
+		opts.OutlierFactor = DefaultOutlierFactor
+	}
+
+	rows, cols := len(d.Data), len(d.VariableNames)
+	r := &Report{
+		NumObjects:         rows,
+		NumVariables:       cols,
+		DuplicateObjects:   duplicates(d.ObjectNames),
+		DuplicateVariables: duplicates(d.VariableNames),
+		Objects:            make([]ObjectStats, rows),
+		Variables:          make([]VariableStats, cols),
+	}
+	for _, e := range opts.ParseErrors {
+		r.ParseErrors = append(r.ParseErrors, ParseError{Line: e.Line, Column: e.Column, Variable: e.Variable, Value: e.Value, Error: e.Err.Error()})
+	}
+
+	for i, row := range d.Data {
+		r.Objects[i].Name = d.ObjectNames[i]
+		for _, v := range row {
+			if math.IsNaN(v) {
+				r.Objects[i].Missing++
+			} else if math.IsInf(v, 0) {
+				r.Objects[i].NonFinite++
+			}
+		}
+		r.NumMissing += r.Objects[i].Missing
+		r.NumNonFinite += r.Objects[i].NonFinite
+	}
+
+	// Copy the finite values to a matrix, with missing and non-finite
+	// values as NaN, to find the constant variables
+	var X *mat.Dense
+	if rows > 0 && cols > 0 {
+		X = mat.NewDense(rows, cols, nil)
+	}
+	values := make([]float64, 0, rows)
+	for j, name := range d.VariableNames {
+		s := &r.Variables[j]
+		s.Name = name
+		s.Column = j + 1
+		values = values[:0]
+		for i, row := range d.Data {
+			v := row[j]
+			switch {
+			case math.IsNaN(v):
+				s.Missing++
+			case math.IsInf(v, 0):
+				s.NonFinite++
+				v = math.NaN()
+			default:
+				values = append(values, v)
+			}
+			X.Set(i, j, v)
+		}
+		describe(s, values, opts.DDOF)
+
+		if iqr := s.Q3 - s.Q1; iqr > 0 {
+			low, high := s.Q1-opts.OutlierFactor*iqr, s.Q3+opts.OutlierFactor*iqr
+			for i, row := range d.Data {
+				if v := row[j]; !math.IsInf(v, 0) && (v < low || v > high) {
+					s.Outliers = append(s.Outliers, Outlier{Object: d.ObjectNames[i], Value: v})
+				}
+			}
+		}
+	}
+	if X != nil {
+		for _, j := range preprocess.ConstantColumns(X, opts.ConstantTolerance) {
+			r.Variables[j].Constant = true
+			r.ConstantVariables = append(r.ConstantVariables, r.Variables[j].Label())
+		}
+	}
+
+	r.Problems = r.problems()
+	return r
+}
+
+// duplicates returns the names used more than once, in the order of their
+// first use.
+func duplicates(names []string) []Duplicate {
+	positions := make(map[string][]int, len(names))
+	for i, name := range names {
+		positions[name] = append(positions[name], i+1)
+	}
+	var dups []Duplicate
+	for i, name := range names {
+		if p := positions[name]; len(p) > 1 && p[0] == i+1 {
+			dups = append(dups, Duplicate{Name: name, Positions: p})
+		}
+	}
+	return dups
+}
+
+// describe calculates the descriptive statistics of the finite values of a
+// variable.
+func describe(s *VariableStats, values []float64, ddof int) {
+	s.Count = len(values)
+	if s.Count == 0 {
+		return
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	s.Mean = sum / float64(s.Count)
+	if s.Count-ddof > 0 {
+		var sumSq float64
+		for _, v := range values {
+			sumSq += (v - s.Mean) * (v - s.Mean)
+		}
+		s.Std = math.Sqrt(sumSq / float64(s.Count-ddof))
+	}
+
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	s.Min, s.Max = sorted[0], sorted[len(sorted)-1]
+	s.Q1, s.Median, s.Q3 = quantile(sorted, 0.25), quantile(sorted, 0.5), quantile(sorted, 0.75)
+}
+
+// quantile returns the p-quantile of sorted values, interpolating linearly
+// between the values at the positions (n-1)p rounded down and up.
+func quantile(sorted []float64, p float64) float64 {
+	h := float64(len(sorted)-1) * p
+	lo := int(h)
+	if lo+1 >= len(sorted) {
+		return sorted[lo]
+	}
+	return sorted[lo] + (h-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
+
+// problems summarizes the problems in the report.
+func (r *Report) problems() []string {
+	var problems []string
+	var badCells, badRows int
+	for _, e := range r.ParseErrors {
+		if e.Column == 0 {
+			badRows++
+		} else {
+			badCells++
+		}
+	}
+	if badCells > 0 {
+		problems = append(problems, fmt.Sprintf("cells that are not numbers (read as missing): %d", badCells))
+	}
+	if badRows > 0 {
+		problems = append(problems, fmt.Sprintf("rows with the wrong number of fields (left out): %d", badRows))
+	}
+	for _, d := range r.DuplicateObjects {
+		problems = append(problems, fmt.Sprintf("object name %q is used at rows %v", d.Name, d.Positions))
+	}
+	for _, d := range r.DuplicateVariables {
+		problems = append(problems, fmt.Sprintf("variable name %q is used at columns %v", d.Name, d.Positions))
+	}
+	if r.NumMissing > 0 {
+		problems = append(problems, fmt.Sprintf("missing values: %d", r.NumMissing))
+	}
+	if r.NumNonFinite > 0 {
+		problems = append(problems, fmt.Sprintf("infinite values: %d", r.NumNonFinite))
+	}
+	for _, s := range r.Variables {
+		switch {
+		case s.Count == 0:
+			problems = append(problems, fmt.Sprintf("variable %q has no finite values", s.Label()))
+		case s.Constant:
+			problems = append(problems, fmt.Sprintf("variable %q is constant", s.Label()))
+		}
+		if len(s.Outliers) > 0 {
+			problems = append(problems, fmt.Sprintf("extreme outliers in variable %q: %d", s.Label(), len(s.Outliers)))
+		}
+	}
+	for _, o := range r.Objects {
+		if o.Missing+o.NonFinite == r.NumVariables && r.NumVariables > 0 {
+			problems = append(problems, fmt.Sprintf("object %q has no finite values", o.Name))
+		}
+	}
+	return problems
+}
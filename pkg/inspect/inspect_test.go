@@ -0,0 +1,142 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the validation report.
+package inspect
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/bitjungle/goLV/pkg/readdata"
+)
+
+// TestInspect checks the report of data with duplicate names, missing and
+// infinite values, a constant variable and an extreme outlier.
+func TestInspect(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+	d := readdata.ProcessedData{
+		VariableNames: []string{"a", "b", "c", "a"},
+		ObjectNames:   []string{"o1", "o2", "o3", "o2", "o5", "o6"},
+		Data: [][]float64{
+			{1, 5, 2, 0},
+			{2, 5, 4, nan},
+			{3, 5, 6, nan},
+			{4, 5, inf, 1},
+			{5, 5, 8, 2},
+			{100, nan, 10, 3},
+		},
+	}
+	r := Inspect(d, Options{})
+
+	if r.NumObjects != 6 || r.NumVariables != 4 || r.NumMissing != 3 || r.NumNonFinite != 1 {
+		t.Errorf("Inspect() counts = %d, %d, %d, %d, want 6, 4, 3, 1", r.NumObjects, r.NumVariables, r.NumMissing, r.NumNonFinite)
+	}
+	if want := []Duplicate{{Name: "o2", Positions: []int{2, 4}}}; !reflect.DeepEqual(r.DuplicateObjects, want) {
+		t.Errorf("Inspect() duplicate objects = %v, want %v", r.DuplicateObjects, want)
+	}
+	if want := []Duplicate{{Name: "a", Positions: []int{1, 4}}}; !reflect.DeepEqual(r.DuplicateVariables, want) {
+		t.Errorf("Inspect() duplicate variables = %v, want %v", r.DuplicateVariables, want)
+	}
+	if want := []string{"b"}; !reflect.DeepEqual(r.ConstantVariables, want) {
+		t.Errorf("Inspect() constant variables = %v, want %v", r.ConstantVariables, want)
+	}
+	if got := r.Objects[1]; got != (ObjectStats{Name: "o2", Missing: 1}) {
+		t.Errorf("Inspect() object 2 = %+v", got)
+	}
+	if got := r.Objects[3]; got != (ObjectStats{Name: "o2", NonFinite: 1}) {
+		t.Errorf("Inspect() object 4 = %+v", got)
+	}
+
+	// Quartiles of 1 2 3 4 5 100 are 2.25 and 4.75, so the upper fence is 12.25
+	a := r.Variables[0]
+	want := VariableStats{Name: "a", Column: 1, Count: 6, Mean: 115.0 / 6, Min: 1, Q1: 2.25, Median: 3.5, Q3: 4.75, Max: 100,
+		Outliers: []Outlier{{Object: "o6", Value: 100}}}
+	want.Std = a.Std
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("Inspect() variable a = %+v, want %+v", a, want)
+	}
+	if math.Abs(a.Std-36.1728) > 1e-4 {
+		t.Errorf("Inspect() std of a = %v, want 36.1728 (population)", a.Std)
+	}
+	if c := r.Variables[2]; c.Count != 5 || c.NonFinite != 1 || c.Max != 10 || c.Outliers != nil {
+		t.Errorf("Inspect() variable c = %+v", c)
+	}
+	if len(r.Problems) != 6 {
+		t.Errorf("Inspect() problems = %q, want 6", r.Problems)
+	}
+	if _, err := json.Marshal(r); err != nil {
+		t.Errorf("json.Marshal() error = %v", err)
+	}
+}
+
+// TestInspectUnnamed checks that variables without a name, e.g. from a
+// workbook without a header row, are reported by their column.
+func TestInspectUnnamed(t *testing.T) {
+	d := readdata.ProcessedData{
+		VariableNames: []string{"a", ""},
+		ObjectNames:   []string{"o1", "o2", "o3"},
+		Data:          [][]float64{{1, 2}, {2, 2}, {3, 2}},
+	}
+	r := Inspect(d, Options{})
+	if want := []string{"column 2"}; !reflect.DeepEqual(r.ConstantVariables, want) {
+		t.Errorf("Inspect() constant variables = %q, want %q", r.ConstantVariables, want)
+	}
+	if want := []string{`variable "column 2" is constant`}; !reflect.DeepEqual(r.Problems, want) {
+		t.Errorf("Inspect() problems = %q, want %q", r.Problems, want)
+	}
+	if got := r.Variables[0].Label(); got != "a" {
+		t.Errorf("Label() = %q, want a", got)
+	}
+}
+
+// TestInspectParseErrors checks that the errors found when loading the data
+// are reported as problems.
+func TestInspectParseErrors(t *testing.T) {
+	d := readdata.ProcessedData{
+		VariableNames: []string{"a", "b"},
+		ObjectNames:   []string{"o1", "o2", "o3"},
+		Data:          [][]float64{{1, 2}, {math.NaN(), 3}, {2, 5}},
+	}
+	errs := readdata.ParseErrors{
+		{Line: 3, Column: 2, Variable: "a", Value: "x", Err: strconv.ErrSyntax},
+		{Line: 4, Err: csv.ErrFieldCount},
+	}
+	r := Inspect(d, Options{ParseErrors: errs})
+
+	want := []ParseError{
+		{Line: 3, Column: 2, Variable: "a", Value: "x", Error: strconv.ErrSyntax.Error()},
+		{Line: 4, Error: csv.ErrFieldCount.Error()},
+	}
+	if !reflect.DeepEqual(r.ParseErrors, want) {
+		t.Errorf("Inspect() parse errors = %+v, want %+v", r.ParseErrors, want)
+	}
+	if len(r.Problems) != 3 { // A bad cell, a bad row and the missing value
+		t.Errorf("Inspect() problems = %q, want 3", r.Problems)
+	}
+}
+
+// TestQuantile checks linear interpolation between sorted values.
+func TestQuantile(t *testing.T) {
+	sorted := []float64{1, 2, 4, 8}
+	for p, want := range map[float64]float64{0: 1, 0.25: 1.75, 0.5: 3, 1: 8} {
+		if got := quantile(sorted, p); got != want {
+			t.Errorf("quantile(%v) = %v, want %v", p, got, want)
+		}
+	}
+}
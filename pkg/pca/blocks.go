@@ -0,0 +1,96 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains the explained variance of each block of
+// variables in multi-block data.
+package pca
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// BlockVariance holds the variance of one block of variables explained by
+// each component.
+type BlockVariance struct {
+	Block               string    `json:"block"`
+	NumVariables        int       `json:"num_variables"`
+	VariancePercentages []float64 `json:"variance_percentages"` // Percentage explained by each component
+}
+
+// BlockExplainedVariance calculates the percentage of the variance of each
+// block of variables explained by each component.
+//
+// X: The preprocessed data matrix the model was fitted to.
+// T, P: The scores and loadings of the model.
+// blocks: The block name of each column of X.
+//
+// The explained variance of a block after a components is 1 - ||E_b||²/||X_b||²,
+// where E_b are the residuals of the block columns after a components. The
+// percentage of each component is the increase over the previous component.
+// The blocks are returned in order of first appearance. Missing (NaN) values
+// are skipped.
+func BlockExplainedVariance(X, T, P mat.Matrix, blocks []string) ([]BlockVariance, error) {
+	rows, cols := X.Dims()
+	_, numComponents := T.Dims()
+	if len(blocks) != cols {
+		return nil, fmt.Errorf("data has %d variables, but blocks are given for %d", cols, len(blocks))
+	}
+
+	index := make(map[string]int)
+	var result []BlockVariance
+	for _, block := range blocks {
+		if _, ok := index[block]; !ok {
+			index[block] = len(result)
+			result = append(result, BlockVariance{Block: block, VariancePercentages: make([]float64, numComponents)})
+		}
+		result[index[block]].NumVariables++
+	}
+
+	ssTotal := make([]float64, len(result))
+	ssResidual := make([][]float64, len(result)) // Per block, after each number of components
+	for b := range result {
+		ssResidual[b] = make([]float64, numComponents)
+	}
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			x := X.At(i, j)
+			if math.IsNaN(x) {
+				continue
+			}
+			b := index[blocks[j]]
+			ssTotal[b] += x * x
+			e := x
+			for a := 0; a < numComponents; a++ {
+				e -= T.At(i, a) * P.At(j, a)
+				ssResidual[b][a] += e * e
+			}
+		}
+	}
+
+	for b := range result {
+		if ssTotal[b] == 0 {
+			continue
+		}
+		previous := 0.0
+		for a := 0; a < numComponents; a++ {
+			explained := 100 * (1 - ssResidual[b][a]/ssTotal[b])
+			result[b].VariancePercentages[a] = explained - previous
+			previous = explained
+		}
+	}
+	return result, nil
+}
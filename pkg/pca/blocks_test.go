@@ -0,0 +1,73 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the block explained variance.
+package pca
+
+import (
+	"math"
+	"testing"
+)
+
+// TestBlockExplainedVariance checks that all variance is explained by a full
+// rank model, and that the blocks together give the total explained variance.
+func TestBlockExplainedVariance(t *testing.T) {
+	X := getTestX()
+	_, cols := X.Dims()
+	res, err := Fit(X, Options{NumComponents: cols, Algorithm: AlgorithmSVD})
+	if err != nil {
+		t.Fatalf("Fit() error = %v", err)
+	}
+
+	blocks := []string{"A", "A", "B", "B", "B"}
+	got, err := BlockExplainedVariance(X, res.Scores, res.Loadings, blocks)
+	if err != nil {
+		t.Fatalf("BlockExplainedVariance() error = %v", err)
+	}
+	if len(got) != 2 || got[0].Block != "A" || got[0].NumVariables != 2 || got[1].NumVariables != 3 {
+		t.Fatalf("BlockExplainedVariance() blocks = %+v", got)
+	}
+
+	var ssA, ssB float64 // Sum of squares of each block
+	rows, _ := X.Dims()
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			if blocks[j] == "A" {
+				ssA += X.At(i, j) * X.At(i, j)
+			} else {
+				ssB += X.At(i, j) * X.At(i, j)
+			}
+		}
+	}
+	total := CalculateVariancePercentages(res.Eigenvalues)
+	for a := range total {
+		combined := (ssA*got[0].VariancePercentages[a] + ssB*got[1].VariancePercentages[a]) / (ssA + ssB)
+		if math.Abs(combined-total[a]) > 1e-6 {
+			t.Errorf("Component %d: combined block variance %v, want %v", a+1, combined, total[a])
+		}
+	}
+	for _, bv := range got {
+		var sum float64
+		for _, v := range bv.VariancePercentages {
+			sum += v
+		}
+		if math.Abs(sum-100) > 1e-6 {
+			t.Errorf("Block %s: total explained variance %v, want 100", bv.Block, sum)
+		}
+	}
+
+	if _, err := BlockExplainedVariance(X, res.Scores, res.Loadings, blocks[:2]); err == nil {
+		t.Errorf("BlockExplainedVariance() expected an error for the wrong number of blocks")
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains per-object diagnostics for PCA models.
+package pca
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// HotellingT2 calculates Hotelling's T² for each row of the scores matrix T.
+//
+// eigenvalues: The eigenvalues (sum of squared calibration scores) of each component.
+// numObjects: The number of objects the model was calibrated on.
+//
+// The variance of component a is estimated as eigenvalues[a]/(numObjects-1).
+func HotellingT2(T mat.Matrix, eigenvalues []float64, numObjects int) []float64 {
+	rows, cols := T.Dims()
+	dof := float64(numObjects - 1)
+	if dof <= 0 {
+		dof = 1
+	}
+
+	t2 := make([]float64, rows)
+	for i := 0; i < rows; i++ {
+		for a := 0; a < cols && a < len(eigenvalues); a++ {
+			variance := eigenvalues[a] / dof
+			if variance == 0 {
+				continue
+			}
+			t := T.At(i, a)
+			t2[i] += t * t / variance
+		}
+	}
+	return t2
+}
+
+// SPE calculates the squared prediction error (Q residual) for each row of the
+// residual matrix E, i.e. the sum of squared residuals of each object.
+// Missing (NaN) residuals are skipped.
+func SPE(E mat.Matrix) []float64 {
+	rows, cols := E.Dims()
+	spe := make([]float64, rows)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			e := E.At(i, j)
+			if math.IsNaN(e) {
+				continue
+			}
+			spe[i] += e * e
+		}
+	}
+	return spe
+}
+
+// ControlLimits holds the Hotelling's T² and SPE control limits at one
+// confidence level. A limit is zero when it cannot be calculated, e.g. when
+// the model leaves no residual variance.
+type ControlLimits struct {
+	Confidence  float64 `json:"confidence"`
+	HotellingT2 float64 `json:"hotelling_t2"`
+	SPEJackson  float64 `json:"spe_jackson_mudholkar"`
+	SPEBox      float64 `json:"spe_box"`
+}
+
+// CalculateControlLimits calculates T² and SPE control limits for each of
+// the given confidence levels (e.g. 0.95).
+//
+// E: The residual matrix of the calibration objects.
+// numComponents: The number of components in the model.
+func CalculateControlLimits(E mat.Matrix, numComponents int, confidence []float64) []ControlLimits {
+	numObjects, _ := E.Dims()
+	theta := residualTheta(E)
+
+	limits := make([]ControlLimits, len(confidence))
+	for i, c := range confidence {
+		limits[i] = ControlLimits{
+			Confidence:  c,
+			HotellingT2: HotellingT2Limit(numComponents, numObjects, c),
+			SPEJackson:  speLimitJacksonMudholkar(theta, c),
+			SPEBox:      speLimitBox(theta, c),
+		}
+	}
+	return limits
+}
+
+// HotellingT2Limit calculates the F-distribution based control limit for
+// Hotelling's T² of the calibration objects,
+//
+//	T²lim = A(n-1)/(n-A) * F(A, n-A; confidence)
+//
+// where A is the number of components and n the number of objects.
+func HotellingT2Limit(numComponents, numObjects int, confidence float64) float64 {
+	a := float64(numComponents)
+	n := float64(numObjects)
+	if numComponents < 1 || numObjects <= numComponents || confidence <= 0 || confidence >= 1 {
+		return 0
+	}
+	f := distuv.F{D1: a, D2: n - a}
+	return a * (n - 1) / (n - a) * f.Quantile(confidence)
+}
+
+// HotellingT2PredictionLimit calculates the F-distribution based control
+// limit for Hotelling's T² of new objects, which are independent of the
+// model,
+//
+//	T²lim = A(n²-1)/(n(n-A)) * F(A, n-A; confidence)
+//
+// where A is the number of components and n the number of calibration
+// objects.
+func HotellingT2PredictionLimit(numComponents, numObjects int, confidence float64) float64 {
+	a := float64(numComponents)
+	n := float64(numObjects)
+	if numComponents < 1 || numObjects <= numComponents || confidence <= 0 || confidence >= 1 {
+		return 0
+	}
+	f := distuv.F{D1: a, D2: n - a}
+	return a * (n*n - 1) / (n * (n - a)) * f.Quantile(confidence)
+}
+
+// SPELimitJacksonMudholkar calculates the Jackson–Mudholkar control limit for
+// the squared prediction error from the residual matrix E.
+func SPELimitJacksonMudholkar(E mat.Matrix, confidence float64) float64 {
+	return speLimitJacksonMudholkar(residualTheta(E), confidence)
+}
+
+// SPELimitBox calculates Box's chi-square approximation of the control limit
+// for the squared prediction error from the residual matrix E.
+func SPELimitBox(E mat.Matrix, confidence float64) float64 {
+	return speLimitBox(residualTheta(E), confidence)
+}
+
+// residualTheta calculates theta_i, the sum of the i-th power of the
+// eigenvalues of the residual covariance matrix, for i = 1, 2, 3. The traces
+// of the powers of the smaller of the Gram matrices EᵀE and EEᵀ are used,
+// which share their nonzero eigenvalues, so no eigendecomposition is needed
+// and spectra with many variables do not need a cols×cols matrix. Missing
+// (NaN) residuals are treated as zero.
+func residualTheta(E mat.Matrix) [3]float64 {
+	rows, cols := E.Dims()
+	var theta [3]float64
+	if rows < 2 {
+		return theta
+	}
+
+	Ec := mat.DenseCopyOf(E)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			if math.IsNaN(Ec.At(i, j)) {
+				Ec.Set(i, j, 0)
+			}
+		}
+	}
+
+	var G mat.SymDense
+	if cols <= rows {
+		G.SymOuterK(1/float64(rows-1), Ec.T()) // EᵀE/(n-1)
+	} else {
+		G.SymOuterK(1/float64(rows-1), Ec) // EEᵀ/(n-1)
+	}
+	var G2 mat.Dense
+	G2.Mul(&G, &G)
+
+	k := G.SymmetricDim()
+	for i := 0; i < k; i++ {
+		theta[0] += G.At(i, i)
+		for j := 0; j < k; j++ {
+			g := G.At(i, j)
+			theta[1] += g * g           // tr(G²), as G is symmetric
+			theta[2] += G2.At(i, j) * g // tr(G³)
+		}
+	}
+	return theta
+}
+
+// speLimitJacksonMudholkar calculates the Jackson–Mudholkar SPE limit,
+//
+//	Qlim = θ1 [z sqrt(2 θ2 h0²)/θ1 + 1 + θ2 h0 (h0-1)/θ1²]^(1/h0)
+//
+// where h0 = 1 - 2 θ1 θ3 / (3 θ2²) and z is the standard normal quantile.
+func speLimitJacksonMudholkar(theta [3]float64, confidence float64) float64 {
+	t1, t2, t3 := theta[0], theta[1], theta[2]
+	if t1 <= 0 || t2 <= 0 || confidence <= 0 || confidence >= 1 {
+		return 0
+	}
+	h0 := 1 - 2*t1*t3/(3*t2*t2)
+	if h0 <= 0 {
+		return 0
+	}
+	z := distuv.UnitNormal.Quantile(confidence)
+	base := z*math.Sqrt(2*t2*h0*h0)/t1 + 1 + t2*h0*(h0-1)/(t1*t1)
+	if base <= 0 {
+		return 0
+	}
+	return t1 * math.Pow(base, 1/h0)
+}
+
+// speLimitBox calculates the SPE limit from Box's approximation of a weighted
+// sum of chi-square variables, Qlim = g chi²(h; confidence), with g = θ2/θ1
+// and h = θ1²/θ2.
+func speLimitBox(theta [3]float64, confidence float64) float64 {
+	t1, t2 := theta[0], theta[1]
+	if t1 <= 0 || t2 <= 0 || confidence <= 0 || confidence >= 1 {
+		return 0
+	}
+	g := t2 / t1
+	h := t1 * t1 / t2
+	return g * distuv.ChiSquared{K: h}.Quantile(confidence)
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the PCA diagnostics.
+package pca
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// TestHotellingT2Limit checks the F based T² limit against a tabulated value.
+func TestHotellingT2Limit(t *testing.T) {
+	// F(2, 10; 0.95) = 4.102821
+	want := 2.0 * 11 / 10 * 4.102821
+	got := HotellingT2Limit(2, 12, 0.95)
+	if math.Abs(got-want) > 1e-4 {
+		t.Errorf("HotellingT2Limit() = %v, want %v", got, want)
+	}
+
+	if got := HotellingT2Limit(3, 3, 0.95); got != 0 {
+		t.Errorf("HotellingT2Limit() with too few objects = %v, want 0", got)
+	}
+}
+
+// TestHotellingT2PredictionLimit checks the T² limit of new objects against
+// a tabulated value, and that it is above the calibration limit.
+func TestHotellingT2PredictionLimit(t *testing.T) {
+	// F(2, 10; 0.95) = 4.102821
+	want := 2.0 * 143 / 120 * 4.102821
+	got := HotellingT2PredictionLimit(2, 12, 0.95)
+	if math.Abs(got-want) > 1e-4 {
+		t.Errorf("HotellingT2PredictionLimit() = %v, want %v", got, want)
+	}
+	if calibration := HotellingT2Limit(2, 12, 0.95); got <= calibration {
+		t.Errorf("HotellingT2PredictionLimit() = %v, want above the calibration limit %v", got, calibration)
+	}
+}
+
+// TestSPELimits checks the SPE limits for residuals with a single variable,
+// where both approximations reduce to a scaled chi-square with one degree of
+// freedom.
+func TestSPELimits(t *testing.T) {
+	E := mat.NewDense(5, 1, []float64{-2, -1, 0, 1, 2})
+	// Residual variance is 10/4 = 2.5, chi²(1; 0.95) = 3.841459
+	want := 2.5 * 3.841459
+
+	if got := SPELimitBox(E, 0.95); math.Abs(got-want) > 1e-4 {
+		t.Errorf("SPELimitBox() = %v, want %v", got, want)
+	}
+	// Jackson-Mudholkar is an approximation, so allow a wider tolerance
+	if got := SPELimitJacksonMudholkar(E, 0.95); math.Abs(got-want)/want > 0.05 {
+		t.Errorf("SPELimitJacksonMudholkar() = %v, want approximately %v", got, want)
+	}
+
+	if got := SPELimitBox(mat.NewDense(5, 1, nil), 0.95); got != 0 {
+		t.Errorf("SPELimitBox() without residual variance = %v, want 0", got)
+	}
+}
+
+// TestResidualTheta checks that theta of wide residuals, with more variables
+// than objects, matches the traces of the powers of the variable covariance
+// matrix, with NaN counted as zero.
+func TestResidualTheta(t *testing.T) {
+	E := mat.NewDense(3, 5, []float64{
+		1, -2, 0.5, 3, math.NaN(),
+		-1, 0, 2, -0.5, 1,
+		0.5, 2, -1, 1, -2,
+	})
+	// With 3 objects, the divisor n-1 is 2 either way
+	wide := residualTheta(E)
+	Ez := mat.DenseCopyOf(E)
+	Ez.Set(0, 4, 0)
+	var C, C2, C3 mat.Dense
+	C.Mul(Ez.T(), Ez)
+	C.Scale(0.5, &C)
+	C2.Mul(&C, &C)
+	C3.Mul(&C2, &C)
+	want := []float64{mat.Trace(&C), mat.Trace(&C2), mat.Trace(&C3)}
+	if !slicesAlmostEqual(wide[:], want, 1e-9) {
+		t.Errorf("residualTheta() = %v, want %v", wide, want)
+	}
+}
+
+// TestSPE checks the squared prediction error of each object.
+func TestSPE(t *testing.T) {
+	E := mat.NewDense(2, 2, []float64{1, 2, -3, 0})
+	if got := SPE(E); !slicesAlmostEqual(got, []float64{5, 9}, 1e-12) {
+		t.Errorf("SPE() = %v, want [5 9]", got)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains the common PCA entry point and the SVD and
+// eigendecomposition PCA algorithms.
+package pca
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bitjungle/goLV/pkg/utils"
+	"gonum.org/v1/gonum/mat"
+)
+
+// PCA algorithms that can be selected in Options.
+const (
+	AlgorithmNIPALS = "nipals" // Iterative, handles missing values
+	AlgorithmSVD    = "svd"    // Singular value decomposition of X
+	AlgorithmEigen  = "eig"    // Eigendecomposition of X'X
+)
+
+// Options controls how a PCA model is fitted.
+type Options struct {
+	NumComponents int           // Number of principal components to compute
+	Algorithm     string        // One of the Algorithm constants, NIPALS if empty
+	NIPALS        NIPALSOptions // Iteration settings, only used by NIPALS
+}
+
+// Result holds a fitted PCA model.
+type Result struct {
+	Scores      *mat.Dense // Scores matrix (T)
+	Loadings    *mat.Dense // Loadings matrix (P)
+	Eigenvalues []float64  // Eigenvalues (sum of squared scores) for each component
+	Residuals   *mat.Dense // Residual matrix (E)
+
+	Convergence []Convergence // Convergence of each component, NIPALS only
+}
+
+// Fit performs PCA on X with the algorithm selected in opts.
+//
+// The sign of each component is chosen so that the loading with the largest
+// absolute value is positive, which makes results from the different
+// algorithms directly comparable.
+func Fit(X mat.Matrix, opts Options) (*Result, error) {
+	rows, cols := X.Dims()
+	if opts.NumComponents < 1 || opts.NumComponents > cols {
+		return nil, fmt.Errorf("number of components must be between 1 and %d, got %d", cols, opts.NumComponents)
+	}
+
+	var res *Result
+	var err error
+	switch opts.Algorithm {
+	case "", AlgorithmNIPALS:
+		res, err = NIPALSWithOptions(X, opts.NumComponents, opts.NIPALS)
+	case AlgorithmSVD, AlgorithmEigen:
+		if utils.HasMissing(X) {
+			return nil, fmt.Errorf("the %s algorithm does not support missing values, use %s", opts.Algorithm, AlgorithmNIPALS)
+		}
+		if opts.Algorithm == AlgorithmSVD {
+			// The thin SVD has min(rows, cols) singular values
+			if opts.NumComponents > rows {
+				return nil, fmt.Errorf("the %s algorithm can compute at most %d components", opts.Algorithm, rows)
+			}
+			res, err = fitSVD(X, opts.NumComponents)
+		} else {
+			res, err = fitEigen(X, opts.NumComponents)
+		}
+	default:
+		return nil, fmt.Errorf("unknown PCA algorithm %q", opts.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	alignSigns(res.Scores, res.Loadings)
+	return res, nil
+}
+
+// fitSVD computes PCA from the thin singular value decomposition X = U S V'.
+// The scores are U S, the loadings V and the eigenvalues the squared
+// singular values.
+func fitSVD(X mat.Matrix, numComponents int) (*Result, error) {
+	var svd mat.SVD
+	if ok := svd.Factorize(X, mat.SVDThin); !ok {
+		return nil, fmt.Errorf("SVD factorization failed")
+	}
+
+	var U, V mat.Dense
+	svd.UTo(&U)
+	svd.VTo(&V)
+	values := svd.Values(nil)
+
+	rows, _ := U.Dims()
+	cols, _ := V.Dims()
+	T := mat.NewDense(rows, numComponents, nil)
+	P := mat.DenseCopyOf(V.Slice(0, cols, 0, numComponents))
+	eigenvalues := make([]float64, numComponents)
+	for a := 0; a < numComponents; a++ {
+		for i := 0; i < rows; i++ {
+			T.Set(i, a, U.At(i, a)*values[a])
+		}
+		eigenvalues[a] = values[a] * values[a]
+	}
+
+	return &Result{Scores: T, Loadings: P, Eigenvalues: eigenvalues, Residuals: residuals(X, T, P)}, nil
+}
+
+// fitEigen computes PCA from the eigendecomposition of the symmetric matrix
+// X'X. The loadings are the eigenvectors with the largest eigenvalues and the
+// scores are X P.
+func fitEigen(X mat.Matrix, numComponents int) (*Result, error) {
+	_, cols := X.Dims()
+	XtX := mat.NewSymDense(cols, nil)
+	XtX.SymOuterK(1, X.T())
+
+	var eig mat.EigenSym
+	if ok := eig.Factorize(XtX, true); !ok {
+		return nil, fmt.Errorf("eigendecomposition failed")
+	}
+	var vectors mat.Dense
+	eig.VectorsTo(&vectors)
+	values := eig.Values(nil) // In ascending order
+
+	P := mat.NewDense(cols, numComponents, nil)
+	eigenvalues := make([]float64, numComponents)
+	for a := 0; a < numComponents; a++ {
+		k := cols - 1 - a
+		P.SetCol(a, mat.Col(nil, k, &vectors))
+		eigenvalues[a] = math.Max(values[k], 0) // Remove tiny negative round-off
+	}
+
+	var T mat.Dense
+	T.Mul(X, P)
+	return &Result{Scores: &T, Loadings: P, Eigenvalues: eigenvalues, Residuals: residuals(X, &T, P)}, nil
+}
+
+// residuals calculates the residual matrix E = X - T P'.
+func residuals(X mat.Matrix, T, P *mat.Dense) *mat.Dense {
+	var E mat.Dense
+	E.Mul(T, P.T())
+	E.Sub(X, &E)
+	return &E
+}
+
+// alignSigns flips the sign of each component so that the loading with the
+// largest absolute value is positive.
+func alignSigns(T, P *mat.Dense) {
+	rows, comps := T.Dims()
+	cols, _ := P.Dims()
+	for a := 0; a < comps; a++ {
+		var largest float64
+		for j := 0; j < cols; j++ {
+			if v := P.At(j, a); math.Abs(v) > math.Abs(largest) {
+				largest = v
+			}
+		}
+		if largest >= 0 {
+			continue
+		}
+		for j := 0; j < cols; j++ {
+			P.Set(j, a, -P.At(j, a))
+		}
+		for i := 0; i < rows; i++ {
+			T.Set(i, a, -T.At(i, a))
+		}
+	}
+}
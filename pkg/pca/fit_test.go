@@ -0,0 +1,90 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the PCA algorithms.
+package pca
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// TestFitAlgorithmsAgree checks that the NIPALS, SVD and eigendecomposition
+// algorithms give the same scores, loadings and eigenvalues.
+func TestFitAlgorithmsAgree(t *testing.T) {
+	X := getTestX()
+	reference, err := Fit(X, Options{NumComponents: 3, Algorithm: AlgorithmSVD})
+	if err != nil {
+		t.Fatalf("Fit() with SVD returned an error: %v", err)
+	}
+
+	// Expected values for the first principal component loadings P
+	expectedPLoadings := []float64{0.390972, 0.486678, 0.454000, 0.426498, 0.471455}
+	if !slicesAlmostEqual(mat.Col(nil, 0, reference.Loadings), expectedPLoadings, 0.01) {
+		t.Errorf("SVD loadings do not match expected values. Got: %v, Want: %v", mat.Col(nil, 0, reference.Loadings), expectedPLoadings)
+	}
+
+	for _, algorithm := range []string{AlgorithmNIPALS, AlgorithmEigen} {
+		res, err := Fit(X, Options{NumComponents: 3, Algorithm: algorithm})
+		if err != nil {
+			t.Fatalf("Fit() with %s returned an error: %v", algorithm, err)
+		}
+		if !mat.EqualApprox(res.Loadings, reference.Loadings, 1e-3) {
+			t.Errorf("%s loadings differ from SVD.\nGot: %v\nWant: %v", algorithm, mat.Formatted(res.Loadings), mat.Formatted(reference.Loadings))
+		}
+		if !mat.EqualApprox(res.Scores, reference.Scores, 1e-3) {
+			t.Errorf("%s scores differ from SVD.\nGot: %v\nWant: %v", algorithm, mat.Formatted(res.Scores), mat.Formatted(reference.Scores))
+		}
+		if !slicesAlmostEqual(res.Eigenvalues, reference.Eigenvalues, 1e-3) {
+			t.Errorf("%s eigenvalues differ from SVD. Got: %v, Want: %v", algorithm, res.Eigenvalues, reference.Eigenvalues)
+		}
+	}
+}
+
+// TestFitErrors checks that invalid options and missing values are rejected.
+func TestFitErrors(t *testing.T) {
+	X := getTestX()
+	if _, err := Fit(X, Options{NumComponents: 2, Algorithm: "unknown"}); err == nil {
+		t.Errorf("Fit() expected an error for an unknown algorithm")
+	}
+	if _, err := Fit(X, Options{NumComponents: 6}); err == nil {
+		t.Errorf("Fit() expected an error for too many components")
+	}
+
+	X.Set(0, 0, math.NaN())
+	if _, err := Fit(X, Options{NumComponents: 2, Algorithm: AlgorithmSVD}); err == nil {
+		t.Errorf("Fit() with SVD expected an error for missing values")
+	}
+	if _, err := Fit(X, Options{NumComponents: 2, Algorithm: AlgorithmNIPALS}); err != nil {
+		t.Errorf("Fit() with NIPALS returned an error for missing values: %v", err)
+	}
+}
+
+// TestFitWideData checks the number of components of data with more
+// variables than objects: SVD is limited by the number of objects, the
+// eigendecomposition of X'X by the number of variables.
+func TestFitWideData(t *testing.T) {
+	X := mat.DenseCopyOf(getTestX().T()) // 5 objects, 7 variables
+	if _, err := Fit(X, Options{NumComponents: 5, Algorithm: AlgorithmSVD}); err != nil {
+		t.Errorf("Fit() with SVD returned an error: %v", err)
+	}
+	if _, err := Fit(X, Options{NumComponents: 6, Algorithm: AlgorithmSVD}); err == nil {
+		t.Errorf("Fit() with SVD expected an error for more components than objects")
+	}
+	if _, err := Fit(X, Options{NumComponents: 6, Algorithm: AlgorithmEigen}); err != nil {
+		t.Errorf("Fit() with eig returned an error: %v", err)
+	}
+}
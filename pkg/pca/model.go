@@ -0,0 +1,278 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains the PCA model type used to project new
+// objects onto a previously fitted model.
+package pca
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/bitjungle/goLV/pkg/preprocess"
+	"github.com/bitjungle/goLV/pkg/utils"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Model holds the parts of a fitted PCA model needed to project new objects.
+// The JSON field names match the results written by the pca command, so a
+// saved results file can be loaded directly as a model.
+type Model struct {
+	VariableNames []string    `json:"variable_names"`
+	ObjectNames   []string    `json:"object_names"` // Calibration object names
+	NumObjects    int         `json:"num_objects"`  // Number of calibration objects
+	NumComponents int         `json:"num_components"`
+	Loadings      [][]float64 `json:"loadings"`
+	Eigenvalues   []float64   `json:"eigenvalues"`
+	XMean         []float64   `json:"x_mean,omitempty"` // Centering of models without a pipeline
+	XStd          []float64   `json:"x_std,omitempty"`  // Scaling of models without a pipeline
+
+	Preprocessing     *preprocess.Pipeline `json:"preprocessing,omitempty"`
+	ExcludedVariables []string             `json:"excluded_variables,omitempty"` // Dropped by the preprocessing
+	ControlLimits     []ControlLimits      `json:"control_limits,omitempty"`
+}
+
+// Projection holds the result of projecting objects onto a PCA model.
+type Projection struct {
+	Scores      *mat.Dense // Scores matrix (T)
+	Residuals   *mat.Dense // Residual matrix (E)
+	HotellingT2 []float64  // Hotelling's T² for each object
+	SPE         []float64  // Squared prediction error (Q residual) for each object
+
+	// Objects that could not be projected, e.g. with fewer available values
+	// than components. Their scores, residuals, T² and SPE are NaN.
+	Unprojected []UnprojectedObject
+}
+
+// UnprojectedObject is an object that could not be projected onto a model.
+type UnprojectedObject struct {
+	Index int   // Row of the object, from 0
+	Err   error // Why the object could not be projected
+}
+
+// LoadModel reads a PCA model from a JSON file.
+func LoadModel(filename string) (*Model, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadModel(file)
+}
+
+// ReadModel reads a PCA model in JSON format from r and validates it.
+func ReadModel(r io.Reader) (*Model, error) {
+	var m Model
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("error decoding PCA model: %v", err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// validate checks that the dimensions of the model parts agree. Models
+// saved without the number of objects get the number of object names.
+func (m *Model) validate() error {
+	cols := len(m.Loadings)
+	if cols == 0 {
+		return fmt.Errorf("PCA model has no variables")
+	}
+	if m.NumObjects == 0 {
+		m.NumObjects = len(m.ObjectNames)
+	}
+	if m.NumObjects <= m.NumComponents {
+		return fmt.Errorf("PCA model has %d components but %d calibration objects", m.NumComponents, m.NumObjects)
+	}
+	if m.Preprocessing == nil && (len(m.XMean) != cols || len(m.XStd) != cols) {
+		return fmt.Errorf("PCA model has %d loading rows but %d means and %d standard deviations", cols, len(m.XMean), len(m.XStd))
+	}
+	for _, row := range m.Loadings {
+		if len(row) != m.NumComponents {
+			return fmt.Errorf("PCA model loadings must have %d columns, got %d", m.NumComponents, len(row))
+		}
+	}
+	if len(m.Eigenvalues) < m.NumComponents {
+		return fmt.Errorf("PCA model has %d components but %d eigenvalues", m.NumComponents, len(m.Eigenvalues))
+	}
+	if len(m.ExcludedVariables) > 0 && m.Preprocessing != nil && len(m.VariableNames) == cols {
+		if dropped := m.Preprocessing.Dropped(cols + len(m.ExcludedVariables)); len(dropped) != len(m.ExcludedVariables) {
+			return fmt.Errorf("PCA model has %d excluded variables, but the preprocessing drops %d", len(m.ExcludedVariables), len(dropped))
+		}
+	}
+	return nil
+}
+
+// InputVariableNames returns the names of the variables the model is
+// applied to: the model variables and the variables dropped by the
+// preprocessing, in the order of the calibration data.
+func (m *Model) InputVariableNames() []string {
+	cols := len(m.Loadings)
+	if len(m.ExcludedVariables) == 0 || m.Preprocessing == nil || len(m.VariableNames) != cols {
+		return m.VariableNames // Nothing dropped, or saved with the input names
+	}
+	n := cols + len(m.ExcludedVariables)
+	dropped := m.Preprocessing.Dropped(n)
+	names := make([]string, 0, n)
+	k, d := 0, 0
+	for j := 0; j < n; j++ {
+		if d < len(dropped) && dropped[d] == j {
+			names = append(names, m.ExcludedVariables[d])
+			d++
+		} else {
+			names = append(names, m.VariableNames[k])
+			k++
+		}
+	}
+	return names
+}
+
+// PredictionLimits returns the stored control limits for new objects, with
+// the T² limits of the calibration objects replaced by those of new objects
+// (see HotellingT2PredictionLimit). The SPE limits are the same.
+func (m *Model) PredictionLimits() []ControlLimits {
+	limits := make([]ControlLimits, len(m.ControlLimits))
+	for i, l := range m.ControlLimits {
+		l.HotellingT2 = HotellingT2PredictionLimit(m.NumComponents, m.NumObjects, l.Confidence)
+		limits[i] = l
+	}
+	return limits
+}
+
+// LoadingsMatrix returns the loadings of the model as a matrix.
+func (m *Model) LoadingsMatrix() *mat.Dense {
+	P := mat.NewDense(len(m.Loadings), m.NumComponents, nil)
+	for i, row := range m.Loadings {
+		P.SetRow(i, row)
+	}
+	return P
+}
+
+// Preprocess applies the stored preprocessing pipeline to X. Models saved
+// without a pipeline are centered and scaled using the stored means and
+// standard deviations, where a standard deviation of zero leaves the column
+// unscaled, as when fitting.
+func (m *Model) Preprocess(X mat.Matrix) (*mat.Dense, error) {
+	var Xpre *mat.Dense
+	if m.Preprocessing != nil {
+		var err error
+		if Xpre, err = m.Preprocessing.Apply(mat.DenseCopyOf(X)); err != nil {
+			return nil, err
+		}
+	} else {
+		r, c := X.Dims()
+		if c != len(m.XMean) {
+			return nil, fmt.Errorf("data has %d variables, the model expects %d", c, len(m.XMean))
+		}
+		Xpre = mat.NewDense(r, c, nil)
+		for j := 0; j < c; j++ {
+			std := m.XStd[j]
+			if std == 0 {
+				std = 1 // Constant column
+			}
+			for i := 0; i < r; i++ {
+				Xpre.Set(i, j, (X.At(i, j)-m.XMean[j])/std)
+			}
+		}
+	}
+
+	if _, c := Xpre.Dims(); c != len(m.Loadings) {
+		return nil, fmt.Errorf("preprocessed data has %d variables, the model expects %d", c, len(m.Loadings))
+	}
+	return Xpre, nil
+}
+
+// Transform applies the stored preprocessing to X and projects it onto the
+// model loadings. It returns the scores, the residuals and per-object
+// Hotelling's T² and squared prediction error.
+//
+// Objects with missing (NaN) values are projected by a least squares fit of
+// the available values to the corresponding rows of the loadings, and their
+// missing residuals are NaN. An object that cannot be projected this way is
+// listed in Unprojected, and the rest of X is still projected.
+func (m *Model) Transform(X mat.Matrix) (*Projection, error) {
+	Xpre, err := m.Preprocess(X)
+	if err != nil {
+		return nil, err
+	}
+	P := m.LoadingsMatrix()
+
+	var T, That, E mat.Dense
+	T.Mul(Xpre, P) // Scores
+	rows, _ := Xpre.Dims()
+	var unprojected []UnprojectedObject
+	for i := 0; i < rows; i++ {
+		if !utils.HasMissing(Xpre.RowView(i)) {
+			continue
+		}
+		t, err := projectMissing(Xpre.RawRowView(i), P)
+		if err != nil {
+			unprojected = append(unprojected, UnprojectedObject{Index: i, Err: err})
+			t = make([]float64, m.NumComponents)
+			for a := range t {
+				t[a] = math.NaN()
+			}
+		}
+		T.SetRow(i, t)
+	}
+	That.Mul(&T, P.T()) // Reconstructed X
+	E.Sub(Xpre, &That)  // Residuals (NaN where X is missing)
+
+	projection := &Projection{
+		Scores:      &T,
+		Residuals:   &E,
+		HotellingT2: HotellingT2(&T, m.Eigenvalues[:m.NumComponents], m.NumObjects),
+		SPE:         SPE(&E),
+		Unprojected: unprojected,
+	}
+	for _, u := range unprojected {
+		projection.HotellingT2[u.Index] = math.NaN()
+		projection.SPE[u.Index] = math.NaN() // SPE skips the NaN residuals
+	}
+	return projection, nil
+}
+
+// projectMissing calculates the scores of a single object with missing values
+// by solving the least squares problem P_obs t = x_obs, where only the
+// variables available in x are used.
+func projectMissing(x []float64, P *mat.Dense) ([]float64, error) {
+	_, comps := P.Dims()
+	var observed []int
+	for j, v := range x {
+		if !math.IsNaN(v) {
+			observed = append(observed, j)
+		}
+	}
+	if len(observed) < comps {
+		return nil, fmt.Errorf("%d available values is too few for %d components", len(observed), comps)
+	}
+
+	Pobs := mat.NewDense(len(observed), comps, nil)
+	xObs := mat.NewVecDense(len(observed), nil)
+	for k, j := range observed {
+		Pobs.SetRow(k, P.RawRowView(j))
+		xObs.SetVec(k, x[j])
+	}
+
+	var t mat.VecDense
+	if err := t.SolveVec(Pobs, xObs); err != nil {
+		return nil, err
+	}
+	return t.RawVector().Data, nil
+}
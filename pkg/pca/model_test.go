@@ -0,0 +1,168 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the PCA model.
+package pca
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// TestModelTransform checks that projecting the calibration data onto a model
+// reproduces the NIPALS scores.
+func TestModelTransform(t *testing.T) {
+	X := getTestX()
+	T, P, eigv, err := NIPALS(X, 2)
+	if err != nil {
+		t.Fatalf("NIPALS returned an error: %v", err)
+	}
+
+	model := &Model{
+		NumObjects:    7,
+		NumComponents: 2,
+		Loadings:      [][]float64{},
+		Eigenvalues:   eigv,
+		XMean:         make([]float64, 5),
+		XStd:          []float64{1, 1, 1, 1, 1},
+	}
+	for i := 0; i < 5; i++ {
+		model.Loadings = append(model.Loadings, mat.Row(nil, i, P))
+	}
+
+	projection, err := model.Transform(X)
+	if err != nil {
+		t.Fatalf("Transform returned an error: %v", err)
+	}
+	if !mat.EqualApprox(projection.Scores, T, 0.01) {
+		t.Errorf("Projected scores do not match NIPALS scores.\nGot: %v\nWant: %v", mat.Formatted(projection.Scores), mat.Formatted(T))
+	}
+
+	// The mean T² of the calibration objects is A(n-1)/n
+	sum := 0.0
+	for _, v := range projection.HotellingT2 {
+		sum += v
+	}
+	if want := 2.0 * 6 / 7; !slicesAlmostEqual([]float64{sum / 7}, []float64{want}, 1e-3) {
+		t.Errorf("Mean Hotelling's T2 = %v, want %v", sum/7, want)
+	}
+}
+
+// TestModelTransformUnprojected checks that an object with fewer available
+// values than components gets NaN scores, T² and SPE, while the other
+// objects are still projected.
+func TestModelTransformUnprojected(t *testing.T) {
+	X := getTestX()
+	T, P, eigv, err := NIPALS(X, 2)
+	if err != nil {
+		t.Fatalf("NIPALS returned an error: %v", err)
+	}
+	model := &Model{
+		NumObjects:    7,
+		NumComponents: 2,
+		Eigenvalues:   eigv,
+		XMean:         make([]float64, 5),
+		XStd:          []float64{1, 1, 1, 1, 1},
+	}
+	for i := 0; i < 5; i++ {
+		model.Loadings = append(model.Loadings, mat.Row(nil, i, P))
+	}
+
+	Xnew := mat.DenseCopyOf(X)
+	for j := 1; j < 5; j++ {
+		Xnew.Set(2, j, math.NaN())
+	}
+	projection, err := model.Transform(Xnew)
+	if err != nil {
+		t.Fatalf("Transform returned an error: %v", err)
+	}
+	if len(projection.Unprojected) != 1 || projection.Unprojected[0].Index != 2 || projection.Unprojected[0].Err == nil {
+		t.Fatalf("Unprojected = %v, want object 2", projection.Unprojected)
+	}
+	if !math.IsNaN(projection.Scores.At(2, 0)) || !math.IsNaN(projection.HotellingT2[2]) || !math.IsNaN(projection.SPE[2]) {
+		t.Errorf("Object 2 has scores %v, T² %v and SPE %v, want NaN", projection.Scores.RawRowView(2), projection.HotellingT2[2], projection.SPE[2])
+	}
+	for _, i := range []int{0, 6} {
+		if !slicesAlmostEqual(projection.Scores.RawRowView(i), T.RawRowView(i), 0.01) {
+			t.Errorf("Scores of object %d = %v, want %v", i, projection.Scores.RawRowView(i), T.RawRowView(i))
+		}
+	}
+}
+
+// TestReadModel checks that a model can be read from results JSON and that
+// inconsistent models are rejected.
+func TestReadModel(t *testing.T) {
+	valid := `{"variable_names":["a","b"],"object_names":["o1","o2","o3"],"num_components":1,
+		"loadings":[[0.6],[0.8]],"eigenvalues":[2.0,0.5],"x_mean":[1,2],"x_std":[1,1]}`
+	model, err := ReadModel(strings.NewReader(valid))
+	if err != nil {
+		t.Fatalf("ReadModel returned an error: %v", err)
+	}
+
+	X := mat.NewDense(1, 2, []float64{2, 3})
+	projection, err := model.Transform(X)
+	if err != nil {
+		t.Fatalf("Transform returned an error: %v", err)
+	}
+	if got := projection.Scores.At(0, 0); !slicesAlmostEqual([]float64{got}, []float64{1.4}, 1e-9) {
+		t.Errorf("Score = %v, want 1.4", got)
+	}
+
+	// A standard deviation of zero leaves the column unscaled
+	model.XStd[1] = 0
+	if projection, err = model.Transform(X); err != nil {
+		t.Fatalf("Transform returned an error: %v", err)
+	}
+	if got := projection.Scores.At(0, 0); got != 1.4 {
+		t.Errorf("Score with zero standard deviation = %v, want 1.4", got)
+	}
+
+	// The number of objects is stored, or taken from the object names
+	if model.NumObjects != 3 {
+		t.Errorf("NumObjects = %d, want 3 from the object names", model.NumObjects)
+	}
+	stored := strings.Replace(valid, `"object_names":["o1","o2","o3"]`, `"num_objects":10`, 1)
+	if model, err = ReadModel(strings.NewReader(stored)); err != nil || model.NumObjects != 10 {
+		t.Errorf("ReadModel() with num_objects = %v, %v, want 10 objects", model, err)
+	}
+
+	invalid := `{"num_components":2,"loadings":[[0.6],[0.8]],"eigenvalues":[2.0],"x_mean":[1,2],"x_std":[1,1]}`
+	if _, err := ReadModel(strings.NewReader(invalid)); err == nil {
+		t.Errorf("Expected an error for an inconsistent model, got nil")
+	}
+}
+
+// TestInputVariableNames checks that the variables dropped by the
+// preprocessing are put back in the order of the calibration data.
+func TestInputVariableNames(t *testing.T) {
+	model := `{"variable_names":["a","c"],"excluded_variables":["b","d"],"num_objects":3,"num_components":1,
+		"loadings":[[0.6],[0.8]],"eigenvalues":[2.0,0.5],
+		"preprocessing":[{"name":"scale","params":{"factors":[1,1,1,1],"constant":"drop","dropped":[1,3]}}]}`
+	m, err := ReadModel(strings.NewReader(model))
+	if err != nil {
+		t.Fatalf("ReadModel returned an error: %v", err)
+	}
+	if got, want := m.InputVariableNames(), []string{"a", "b", "c", "d"}; !slices.Equal(got, want) {
+		t.Errorf("InputVariableNames() = %v, want %v", got, want)
+	}
+
+	inconsistent := strings.Replace(model, `"dropped":[1,3]`, `"dropped":[1]`, 1)
+	if _, err := ReadModel(strings.NewReader(inconsistent)); err == nil {
+		t.Errorf("Expected an error for excluded variables the preprocessing does not drop, got nil")
+	}
+}
@@ -16,8 +16,11 @@
 package pca
 
 import (
+	"fmt"
+	"math"
 	"math/rand"
 
+	"github.com/bitjungle/goLV/pkg/utils"
 	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
 )
@@ -52,9 +55,79 @@ import (
 // Step 6: Repeat Steps 1 to 5 to find the next principal component
 // using the deflated X. Continue until the desired number of
 // principal components is obtained.
+//
+// Missing values in X are given as NaN. When X contains missing values,
+// the matrix products in Steps 2 and 3 are replaced by regressions over the
+// available elements only, so each element of p (or t) is computed from the
+// non-missing elements of the corresponding column (or row) of X. Missing
+// elements remain NaN in the residual matrix.
 func NIPALS(X mat.Matrix, numComponents int) (*mat.Dense, *mat.Dense, []float64, error) {
-	epsilon := 1e-6
-	maxIterations := 500
+	T, P, Eigenvalues, _, err := NIPALSWithResiduals(X, numComponents)
+	return T, P, Eigenvalues, err
+}
+
+// NIPALSWithResiduals performs PCA like NIPALS, and also returns the residual
+// matrix (E) left after deflating X by all computed components.
+func NIPALSWithResiduals(X mat.Matrix, numComponents int) (*mat.Dense, *mat.Dense, []float64, *mat.Dense, error) {
+	res, err := NIPALSWithOptions(X, numComponents, DefaultNIPALSOptions())
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return res.Scores, res.Loadings, res.Eigenvalues, res.Residuals, nil
+}
+
+// NIPALS initialization strategies.
+const (
+	InitHighestVariance = "variance" // Start from the column of X with the highest variance
+	InitRandom          = "random"   // Start from a random vector
+)
+
+// NIPALSOptions controls the NIPALS iterations.
+type NIPALSOptions struct {
+	Tolerance     float64 // Convergence tolerance for the relative change of t
+	MaxIterations int     // Maximum number of iterations per component
+	Init          string  // Initialization strategy, InitHighestVariance if empty
+	Seed          int64   // Random seed used with InitRandom
+}
+
+// Convergence reports how the NIPALS iterations went for one component.
+type Convergence struct {
+	Iterations int     `json:"iterations"` // Number of iterations used
+	Change     float64 `json:"change"`     // Relative change of t in the last iteration
+	Converged  bool    `json:"converged"`  // Whether the change fell below the tolerance
+}
+
+// DefaultNIPALSOptions returns the default NIPALS options.
+func DefaultNIPALSOptions() NIPALSOptions {
+	return NIPALSOptions{
+		Tolerance:     1e-6,
+		MaxIterations: 500,
+		Init:          InitHighestVariance,
+	}
+}
+
+// NIPALSWithOptions performs PCA like NIPALS, with configurable tolerance,
+// maximum number of iterations and initialization. Zero valued options are
+// replaced by their defaults. The returned result includes a convergence
+// report for each component.
+//
+// Convergence is reached when the relative change of the score vector,
+// ||t_new - t|| / ||t_new||, is below the tolerance.
+func NIPALSWithOptions(X mat.Matrix, numComponents int, opts NIPALSOptions) (*Result, error) {
+	defaults := DefaultNIPALSOptions()
+	if opts.Tolerance <= 0 {
+		opts.Tolerance = defaults.Tolerance
+	}
+	if opts.MaxIterations <= 0 {
+		opts.MaxIterations = defaults.MaxIterations
+	}
+	if opts.Init == "" {
+		opts.Init = defaults.Init
+	}
+	if opts.Init != InitHighestVariance && opts.Init != InitRandom {
+		return nil, fmt.Errorf("unknown NIPALS initialization %q", opts.Init)
+	}
+	rnd := rand.New(rand.NewSource(opts.Seed))
 
 	rows, cols := X.Dims()
 
@@ -62,35 +135,59 @@ func NIPALS(X mat.Matrix, numComponents int) (*mat.Dense, *mat.Dense, []float64,
 	P := mat.NewDense(cols, numComponents, nil)   // Loadings matrix
 	Eigenvalues := make([]float64, numComponents) // Eigenvalues for each component
 	XRes := mat.DenseCopyOf(X)                    // Residual X matrix
+	missing := utils.HasMissing(XRes)             // Use missing value tolerant regressions
+	report := make([]Convergence, numComponents)  // Convergence of each component
 
-	var t, p, tNew, outerProduct, sub mat.Dense
+	var t, p, tNew, diff, outerProduct, sub mat.Dense
 
 	for i := 0; i < numComponents; i++ { // Repeat for each component
 
-		// Use the column from XRes with the highest variance as the initial t
-		t.CloneFrom(initialScoreVector(XRes))
-		// Use a random vector as the initial t
-		//t.CloneFrom(initialRandomScoreVector(rows))
+		if opts.Init == InitRandom {
+			// Use a random vector as the initial t
+			t.CloneFrom(initialRandomScoreVector(rows, rnd))
+		} else {
+			// Use the column from XRes with the highest variance as the initial t
+			t.CloneFrom(utils.HighestVarianceColumn(XRes))
+		}
+
+		for j := 0; j < opts.MaxIterations; j++ { // Repeat until convergence
+			report[i].Iterations = j + 1
 
-		for j := 0; j < maxIterations; j++ { // Repeat until convergence
 			// Compute loading vector p
-			p.Mul(XRes.T(), &t)
+			if missing {
+				p.CloneFrom(regressOnScores(XRes, &t))
+			} else {
+				p.Mul(XRes.T(), &t)
+			}
 
 			// Normalize p to length 1
 			pNorm := floats.Norm(p.RawMatrix().Data, 2)
 			if pNorm == 0 {
-				break // Avoid division by zero
+				report[i].Converged = true // No variance left to explain
+				break                      // Avoid division by zero
 			}
 			p.Scale(1/pNorm, &p)
 
 			// Compute score vector t
-			tNew.Mul(XRes, &p)
+			if missing {
+				tNew.CloneFrom(regressOnLoadings(XRes, &p))
+			} else {
+				tNew.Mul(XRes, &p)
+			}
 
 			// Check for convergence
-			if mat.Norm(&tNew, 2)-mat.Norm(&t, 2) < epsilon {
-				break
+			diff.Sub(&tNew, &t)
+			change := mat.Norm(&diff, 2)
+			if tNorm := mat.Norm(&tNew, 2); tNorm > 0 {
+				change /= tNorm
 			}
+			diff.Reset()
+			report[i].Change = change
 			t.CloneFrom(&tNew)
+			if change < opts.Tolerance {
+				report[i].Converged = true
+				break
+			}
 		}
 
 		T.SetCol(i, t.RawMatrix().Data) // Store the score vector in the scores matrix
@@ -108,43 +205,71 @@ func NIPALS(X mat.Matrix, numComponents int) (*mat.Dense, *mat.Dense, []float64,
 		Eigenvalues[i] *= Eigenvalues[i]
 	}
 
-	return T, P, Eigenvalues, nil
+	return &Result{
+		Scores:      T,
+		Loadings:    P,
+		Eigenvalues: Eigenvalues,
+		Residuals:   XRes,
+		Convergence: report,
+	}, nil
 }
 
-// initialScoreVector selects the column from a given matrix that has the highest variance
-func initialScoreVector(X *mat.Dense) *mat.Dense {
+// regressOnScores computes the loading vector p for a given score vector t,
+// using only the available (non-NaN) elements of X:
+//
+//	p_j = sum_i x_ij t_i / sum_i t_i²  (over i where x_ij is available)
+func regressOnScores(X *mat.Dense, t *mat.Dense) *mat.Dense {
 	rows, cols := X.Dims()
-	maxVariance := 0.0
-	var columnIndex int
-
+	p := mat.NewDense(cols, 1, nil)
 	for j := 0; j < cols; j++ {
-		var mean, variance float64
+		var xt, tt float64
 		for i := 0; i < rows; i++ {
-			value := X.At(i, j)
-			mean += value             // Accumulate the sum of values
-			variance += value * value // Accumulate the sum of squares
+			x := X.At(i, j)
+			if math.IsNaN(x) {
+				continue
+			}
+			ti := t.At(i, 0)
+			xt += x * ti
+			tt += ti * ti
 		}
-		mean /= float64(rows)                         // Calculate the mean
-		variance = variance/float64(rows) - mean*mean // Calculate the variance
-
-		if variance > maxVariance { // Check if this column has higher variance
-			maxVariance = variance
-			columnIndex = j
+		if tt > 0 {
+			p.Set(j, 0, xt/tt)
 		}
 	}
+	return p
+}
 
-	// Extract the column with the highest variance
-	highestVarianceColumn := mat.NewDense(rows, 1, nil)
-	mat.Col(highestVarianceColumn.RawMatrix().Data, columnIndex, X)
-	return highestVarianceColumn
+// regressOnLoadings computes the score vector t for a given loading vector p,
+// using only the available (non-NaN) elements of X:
+//
+//	t_i = sum_j x_ij p_j / sum_j p_j²  (over j where x_ij is available)
+func regressOnLoadings(X *mat.Dense, p *mat.Dense) *mat.Dense {
+	rows, cols := X.Dims()
+	t := mat.NewDense(rows, 1, nil)
+	for i := 0; i < rows; i++ {
+		var xp, pp float64
+		for j := 0; j < cols; j++ {
+			x := X.At(i, j)
+			if math.IsNaN(x) {
+				continue
+			}
+			pj := p.At(j, 0)
+			xp += x * pj
+			pp += pj * pj
+		}
+		if pp > 0 {
+			t.Set(i, 0, xp/pp)
+		}
+	}
+	return t
 }
 
 // initialRandomScoreVector creates a random and normalized vector of scores
-func initialRandomScoreVector(rows int) *mat.Dense {
+func initialRandomScoreVector(rows int, rnd *rand.Rand) *mat.Dense {
 	var t mat.Dense
 	tRaw := make([]float64, rows) // Create a raw vector to store the random values
 	for j := range tRaw {
-		tRaw[j] = rand.Float64() // Assign a random value
+		tRaw[j] = rnd.Float64() // Assign a random value
 	}
 	tNorm := floats.Norm(tRaw, 2)   // Calculate the norm of the vector
 	t = *mat.NewDense(rows, 1, nil) // Create a new matrix to store the normalized vector
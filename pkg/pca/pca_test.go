@@ -35,8 +35,8 @@ func slicesAlmostEqual(a, b []float64, tol float64) bool {
 	return true
 }
 
-func TestNIPALS(t *testing.T) {
-	// Setup test data
+// getTestX returns an autoscaled 7x5 test matrix.
+func getTestX() *mat.Dense {
 	data := []float64{
 		-1.18, -1.43, -1.17, -1.37, -1.61,
 		-0.59, -0.99, -0.82, -1.12, -0.89,
@@ -46,7 +46,12 @@ func TestNIPALS(t *testing.T) {
 		0.59, 0.99, 0.70, 1.06, 1.05,
 		1.77, 1.65, 1.99, 0.81, 1.21,
 	}
-	X := mat.NewDense(7, 5, data)
+	return mat.NewDense(7, 5, data)
+}
+
+func TestNIPALS(t *testing.T) {
+	// Setup test data
+	X := getTestX()
 
 	// Perform PCA
 	T, P, _, err := NIPALS(X, 5)
@@ -78,3 +83,72 @@ func TestNIPALS(t *testing.T) {
 		t.Errorf("Principal component loadings P do not match expected values. Got: %v, Want: %v", actualPLoadings, expectedPLoadings)
 	}
 }
+
+// TestNIPALSMissing checks that NIPALS handles a missing value and gives
+// results close to those of the complete data.
+func TestNIPALSMissing(t *testing.T) {
+	X := getTestX()
+	Tfull, Pfull, _, err := NIPALS(X, 1)
+	if err != nil {
+		t.Fatalf("NIPALS returned an error: %v", err)
+	}
+
+	X.Set(3, 2, math.NaN())
+	T, P, eigv, err := NIPALS(X, 2)
+	if err != nil {
+		t.Fatalf("NIPALS returned an error: %v", err)
+	}
+
+	for _, v := range append(T.RawMatrix().Data, append(P.RawMatrix().Data, eigv...)...) {
+		if math.IsNaN(v) {
+			t.Fatalf("NIPALS results should not contain NaN, got T: %v, P: %v", T, P)
+		}
+	}
+
+	// The object with the missing value is allowed to move more than the others
+	tolerance := 0.1
+	if !slicesAlmostEqual(mat.Col(nil, 0, T), mat.Col(nil, 0, Tfull), 2*tolerance) {
+		t.Errorf("Scores with a missing value differ too much. Got: %v, Want: %v", mat.Col(nil, 0, T), mat.Col(nil, 0, Tfull))
+	}
+	if !slicesAlmostEqual(mat.Col(nil, 0, P), mat.Col(nil, 0, Pfull), tolerance) {
+		t.Errorf("Loadings with a missing value differ too much. Got: %v, Want: %v", mat.Col(nil, 0, P), mat.Col(nil, 0, Pfull))
+	}
+}
+
+// TestNIPALSWithOptions checks the convergence report and that random
+// initialization converges to the same components.
+func TestNIPALSWithOptions(t *testing.T) {
+	X := getTestX()
+
+	res, err := NIPALSWithOptions(X, 2, NIPALSOptions{Tolerance: 1e-10})
+	if err != nil {
+		t.Fatalf("NIPALSWithOptions returned an error: %v", err)
+	}
+	for a, c := range res.Convergence {
+		if !c.Converged || c.Change >= 1e-10 {
+			t.Errorf("Component %d should have converged, got %+v", a+1, c)
+		}
+	}
+
+	random, err := NIPALSWithOptions(X, 2, NIPALSOptions{Tolerance: 1e-10, Init: InitRandom, Seed: 7})
+	if err != nil {
+		t.Fatalf("NIPALSWithOptions returned an error: %v", err)
+	}
+	alignSigns(res.Scores, res.Loadings)
+	alignSigns(random.Scores, random.Loadings)
+	if !mat.EqualApprox(random.Loadings, res.Loadings, 1e-6) {
+		t.Errorf("Random initialization gave different loadings.\nGot: %v\nWant: %v", mat.Formatted(random.Loadings), mat.Formatted(res.Loadings))
+	}
+
+	limited, err := NIPALSWithOptions(X, 1, NIPALSOptions{Tolerance: 1e-12, MaxIterations: 1})
+	if err != nil {
+		t.Fatalf("NIPALSWithOptions returned an error: %v", err)
+	}
+	if c := limited.Convergence[0]; c.Converged || c.Iterations != 1 {
+		t.Errorf("Component should not converge in one iteration, got %+v", c)
+	}
+
+	if _, err := NIPALSWithOptions(X, 1, NIPALSOptions{Init: "unknown"}); err == nil {
+		t.Errorf("NIPALSWithOptions expected an error for an unknown initialization")
+	}
+}
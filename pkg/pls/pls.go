@@ -0,0 +1,210 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains the NIPALS PLS regression algorithm and helper functions.
+package pls
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bitjungle/goLV/pkg/utils"
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Result holds the output of a PLS regression.
+type Result struct {
+	T         *mat.Dense // X scores (rows x comps)
+	U         *mat.Dense // Y scores (rows x comps)
+	P         *mat.Dense // X loadings (xcols x comps)
+	W         *mat.Dense // X weights (xcols x comps)
+	Q         *mat.Dense // Y loadings (ycols x comps)
+	B         *mat.Dense // Regression coefficients (xcols x ycols)
+	XVariance []float64  // Percentage of X variance explained by each component
+	YVariance []float64  // Percentage of Y variance explained by each component
+}
+
+// NIPALS performs Partial Least Squares (PLS) regression using the Non-linear
+// Iterative Partial Least Squares (NIPALS) algorithm.
+//
+// X: Predictor matrix (rows x xcols), usually mean centered.
+// Y: Response matrix (rows x ycols), usually mean centered. A single column
+// gives PLS1, more than one column gives PLS2.
+// numComponents: Number of latent variables to compute.
+//
+// Returns the scores, loadings, weights, regression coefficients and the
+// explained variance for X and Y per component.
+//
+// The NIPALS algorithm:
+//
+// Step 1: Choose the column of Y with the highest variance as an initial
+// estimate of the Y score vector u.
+//
+// Step 2: Compute the X weight vector w as the matrix product of the
+// transpose of X and u, and normalize w to have unit length.
+//
+// Step 3: Compute the X score vector t as the matrix product of X and w.
+//
+// Step 4: Compute the Y loading vector q by regressing Y on t, and update
+// u as the matrix product of Y and q divided by q'q.
+//
+// Step 5: Check the convergence of u. If the convergence criterion is met,
+// stop the iteration. Otherwise, go back to Step 2. For PLS1 the
+// iteration converges after the first pass.
+//
+// Step 6: Compute the X loading vector p by regressing X on t, and deflate
+// X and Y by subtracting the outer products t p' and t q'.
+//
+// Step 7: Repeat Steps 1 to 6 on the deflated matrices until the desired
+// number of components is obtained. The regression coefficients are then
+// B = W (P'W)^-1 Q'.
+func NIPALS(X, Y mat.Matrix, numComponents int) (*Result, error) {
+	epsilon := 1e-10
+	maxIterations := 500
+
+	rows, xCols := X.Dims()
+	yRows, yCols := Y.Dims()
+	if rows != yRows {
+		return nil, fmt.Errorf("X and Y must have the same number of rows, got %d and %d", rows, yRows)
+	}
+	if utils.HasMissing(X) || utils.HasMissing(Y) {
+		return nil, fmt.Errorf("PLS regression does not support missing values")
+	}
+	if numComponents < 1 || numComponents > xCols || numComponents > rows {
+		return nil, fmt.Errorf("number of components must be between 1 and %d, got %d", min(xCols, rows), numComponents)
+	}
+
+	res := &Result{
+		T:         mat.NewDense(rows, numComponents, nil),
+		U:         mat.NewDense(rows, numComponents, nil),
+		P:         mat.NewDense(xCols, numComponents, nil),
+		W:         mat.NewDense(xCols, numComponents, nil),
+		Q:         mat.NewDense(yCols, numComponents, nil),
+		XVariance: make([]float64, numComponents),
+		YVariance: make([]float64, numComponents),
+	}
+
+	XRes := mat.DenseCopyOf(X) // Residual X matrix
+	YRes := mat.DenseCopyOf(Y) // Residual Y matrix
+	xTotal := sumOfSquares(XRes)
+	yTotal := sumOfSquares(YRes)
+
+	var u, w, t, q, uNew, p, outerProduct mat.Dense
+
+	for i := 0; i < numComponents; i++ { // Repeat for each component
+		u.CloneFrom(utils.HighestVarianceColumn(YRes))
+
+		for j := 0; j < maxIterations; j++ { // Repeat until convergence
+			// Compute weight vector w and normalize it to length 1
+			w.Mul(XRes.T(), &u)
+			wNorm := floats.Norm(w.RawMatrix().Data, 2)
+			if wNorm == 0 {
+				return nil, fmt.Errorf("X has no variance left to explain at component %d", i+1)
+			}
+			w.Scale(1/wNorm, &w)
+
+			// Compute X score vector t
+			t.Mul(XRes, &w)
+			tt := mat.Dot(t.ColView(0), t.ColView(0))
+
+			// Compute Y loading vector q
+			q.Mul(YRes.T(), &t)
+			q.Scale(1/tt, &q)
+
+			if yCols == 1 {
+				u.Mul(YRes, &q)
+				u.Scale(1/mat.Dot(q.ColView(0), q.ColView(0)), &u)
+				break // PLS1 needs no iteration
+			}
+
+			// Compute Y score vector u
+			uNew.Mul(YRes, &q)
+			uNew.Scale(1/mat.Dot(q.ColView(0), q.ColView(0)), &uNew)
+
+			// Check for convergence
+			var diff mat.Dense
+			diff.Sub(&uNew, &u)
+			converged := mat.Norm(&diff, 2) < epsilon*mat.Norm(&uNew, 2)
+			u.CloneFrom(&uNew)
+			if converged {
+				break
+			}
+		}
+
+		// Compute X loading vector p
+		tt := mat.Dot(t.ColView(0), t.ColView(0))
+		p.Mul(XRes.T(), &t)
+		p.Scale(1/tt, &p)
+
+		res.T.SetCol(i, t.RawMatrix().Data)
+		res.U.SetCol(i, u.RawMatrix().Data)
+		res.P.SetCol(i, p.RawMatrix().Data)
+		res.W.SetCol(i, w.RawMatrix().Data)
+		res.Q.SetCol(i, q.RawMatrix().Data)
+
+		// Deflate X and Y by the outer products of t and the loadings
+		outerProduct.Mul(&t, p.T())
+		res.XVariance[i] = percentage(sumOfSquares(&outerProduct), xTotal)
+		XRes.Sub(XRes, &outerProduct)
+		outerProduct.Reset()
+
+		outerProduct.Mul(&t, q.T())
+		res.YVariance[i] = percentage(sumOfSquares(&outerProduct), yTotal)
+		YRes.Sub(YRes, &outerProduct)
+		outerProduct.Reset()
+	}
+
+	B, err := Coefficients(res.W, res.P, res.Q)
+	if err != nil {
+		return nil, err
+	}
+	res.B = B
+
+	return res, nil
+}
+
+// Coefficients calculates the regression coefficients B = W (P'W)^-1 Q'
+// from the weights, X loadings and Y loadings of a PLS model.
+func Coefficients(W, P, Q mat.Matrix) (*mat.Dense, error) {
+	var PtW, inv, WInv, B mat.Dense
+	PtW.Mul(P.T(), W)
+	if err := inv.Inverse(&PtW); err != nil {
+		return nil, fmt.Errorf("error inverting P'W: %v", err)
+	}
+	WInv.Mul(W, &inv)
+	B.Mul(&WInv, Q.T())
+	return &B, nil
+}
+
+// Predict calculates the fitted responses Y = X B for a (preprocessed) X.
+func Predict(X mat.Matrix, B mat.Matrix) *mat.Dense {
+	var Y mat.Dense
+	Y.Mul(X, B)
+	return &Y
+}
+
+// sumOfSquares calculates the sum of the squared elements of a matrix.
+func sumOfSquares(X mat.Matrix) float64 {
+	norm := mat.Norm(X, 2)
+	return norm * norm
+}
+
+// percentage returns part as a percentage of total, or zero if total is zero.
+func percentage(part, total float64) float64 {
+	if total == 0 || math.IsNaN(total) {
+		return 0
+	}
+	return part / total * 100
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the pls package.
+package pls
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// helper function to check if slices are almost equal
+func slicesAlmostEqual(a, b []float64, tol float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > tol {
+			return false
+		}
+	}
+	return true
+}
+
+// getTestX returns an autoscaled 7x5 test matrix.
+func getTestX() *mat.Dense {
+	data := []float64{
+		-1.18, -1.43, -1.17, -1.37, -1.61,
+		-0.59, -0.99, -0.82, -1.12, -0.89,
+		0.59, -0.44, -0.58, -0.93, -0.48,
+		-1.18, 0.00, 0.23, 0.62, -0.16,
+		0.00, 0.22, -0.35, 0.93, 0.89,
+		0.59, 0.99, 0.70, 1.06, 1.05,
+		1.77, 1.65, 1.99, 0.81, 1.21,
+	}
+	return mat.NewDense(7, 5, data)
+}
+
+// TestNIPALSFullRank checks that a full rank PLS1 model recovers the
+// coefficients of an exact linear relationship.
+func TestNIPALSFullRank(t *testing.T) {
+	X := getTestX()
+	b := mat.NewDense(5, 1, []float64{0.5, -1.0, 2.0, 0.0, 1.5})
+	var Y mat.Dense
+	Y.Mul(X, b)
+
+	res, err := NIPALS(X, &Y, 5)
+	if err != nil {
+		t.Fatalf("NIPALS returned an error: %v", err)
+	}
+
+	tolerance := 1e-6
+	if !slicesAlmostEqual(mat.Col(nil, 0, res.B), mat.Col(nil, 0, b), tolerance) {
+		t.Errorf("Regression coefficients do not match. Got: %v, Want: %v", mat.Col(nil, 0, res.B), mat.Col(nil, 0, b))
+	}
+
+	yVarTotal := 0.0
+	for _, v := range res.YVariance {
+		yVarTotal += v
+	}
+	if math.Abs(yVarTotal-100) > tolerance {
+		t.Errorf("Explained Y variance should sum to 100, got %v", yVarTotal)
+	}
+}
+
+// TestNIPALSPLS2 checks that scores are orthogonal and that the coefficients
+// reproduce the fitted responses for a model with two responses.
+func TestNIPALSPLS2(t *testing.T) {
+	X := getTestX()
+	Y := mat.NewDense(7, 2, []float64{
+		-1.2, 0.3,
+		-0.8, 0.1,
+		-0.3, -0.4,
+		0.1, 0.6,
+		0.4, -0.2,
+		0.7, 0.1,
+		1.1, -0.5,
+	})
+
+	res, err := NIPALS(X, Y, 2)
+	if err != nil {
+		t.Fatalf("NIPALS returned an error: %v", err)
+	}
+
+	dot := mat.Dot(res.T.ColView(0), res.T.ColView(1))
+	if math.Abs(dot) > 1e-8 {
+		t.Errorf("X scores should be orthogonal, got t1't2 = %v", dot)
+	}
+
+	// Fitted Y from the scores (T Q') should equal X B
+	var fromScores mat.Dense
+	fromScores.Mul(res.T, res.Q.T())
+	fromCoefficients := Predict(X, res.B)
+	if !mat.EqualApprox(&fromScores, fromCoefficients, 1e-8) {
+		t.Errorf("T Q' and X B should be equal.\nGot: %v\nWant: %v", mat.Formatted(fromCoefficients), mat.Formatted(&fromScores))
+	}
+}
+
+// TestNIPALSDimensionMismatch checks that mismatched X and Y are rejected.
+func TestNIPALSDimensionMismatch(t *testing.T) {
+	X := getTestX()
+	Y := mat.NewDense(6, 1, nil)
+	if _, err := NIPALS(X, Y, 2); err == nil {
+		t.Errorf("Expected an error for mismatched rows, got nil")
+	}
+}
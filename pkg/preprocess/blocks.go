@@ -0,0 +1,91 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains block scaling of multi-block data, where
+// the variables come from several instruments.
+package preprocess
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// StepBlock is the name of the block scaling step.
+const StepBlock = "block"
+
+// BlockScaling divides the columns of each block of variables by the square
+// root of the total variance of the block, so every block gets total variance
+// 1. Without block scaling, the block with the most variables dominates the
+// model. Block scaling is usually applied after centering and scaling the
+// individual variables.
+type BlockScaling struct {
+	Blocks  []string           `json:"blocks"`  // Block name of each column
+	Factors map[string]float64 `json:"factors"` // Divisor of each block
+}
+
+// Name returns the step name.
+func (b *BlockScaling) Name() string { return StepBlock }
+
+// Clone returns a copy of the step.
+func (b *BlockScaling) Clone() Step {
+	return &BlockScaling{Blocks: slices.Clone(b.Blocks), Factors: maps.Clone(b.Factors)}
+}
+
+// Fit calculates the total variance of each block of X. Missing (NaN) values
+// are ignored.
+func (b *BlockScaling) Fit(X *mat.Dense) error {
+	_, cols := X.Dims()
+	if len(b.Blocks) != cols {
+		return fmt.Errorf("data has %d variables, but blocks are given for %d", cols, len(b.Blocks))
+	}
+
+	stds := colStdDev(X)
+	total := make(map[string]float64)
+	for j, block := range b.Blocks {
+		if !math.IsNaN(stds[j]) {
+			total[block] += stds[j] * stds[j]
+		}
+	}
+	b.Factors = make(map[string]float64, len(total))
+	for _, block := range b.Blocks {
+		if total[block] == 0 {
+			return fmt.Errorf("block %q has no variance", block)
+		}
+		b.Factors[block] = math.Sqrt(total[block])
+	}
+	return nil
+}
+
+// Apply divides each column of X by the fitted factor of its block.
+func (b *BlockScaling) Apply(X *mat.Dense) (*mat.Dense, error) {
+	r, cols := X.Dims()
+	if cols != len(b.Blocks) {
+		return nil, fmt.Errorf("data has %d variables, block scaling was fitted to %d", cols, len(b.Blocks))
+	}
+	scaledX := mat.NewDense(r, cols, nil)
+	for j, block := range b.Blocks {
+		factor, ok := b.Factors[block]
+		if !ok {
+			return nil, fmt.Errorf("block scaling is not fitted for block %q", block)
+		}
+		for i := 0; i < r; i++ {
+			scaledX.Set(i, j, X.At(i, j)/factor)
+		}
+	}
+	return scaledX, nil
+}
@@ -0,0 +1,52 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for block scaling.
+package preprocess
+
+import (
+	"math"
+	"testing"
+)
+
+// TestBlockScaling checks that each block gets total variance 1 after
+// autoscaling, regardless of the number of variables in the block.
+func TestBlockScaling(t *testing.T) {
+	X, _, _ := Autoscale(getTestData("raw"))
+	b := &BlockScaling{Blocks: []string{"A", "A", "A", "A", "B"}}
+	if err := b.Fit(X); err != nil {
+		t.Fatalf("Fit() error = %v", err)
+	}
+	if math.Abs(b.Factors["A"]-2) > 1e-9 || math.Abs(b.Factors["B"]-1) > 1e-9 {
+		t.Errorf("Fit() factors = %v, want A: 2, B: 1", b.Factors)
+	}
+
+	scaled, err := b.Apply(X)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	total := map[string]float64{}
+	for j, std := range colStdDev(scaled) {
+		total[b.Blocks[j]] += std * std
+	}
+	for block, v := range total {
+		if math.Abs(v-1) > 1e-9 {
+			t.Errorf("Block %s has total variance %v, want 1", block, v)
+		}
+	}
+
+	if err := (&BlockScaling{Blocks: []string{"A"}}).Fit(X); err == nil {
+		t.Errorf("Fit() expected an error for the wrong number of blocks")
+	}
+}
@@ -0,0 +1,388 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains the preprocessing pipeline, an ordered list
+// of steps that are fitted to calibration data and applied to new data.
+package preprocess
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Step is a preprocessing step. Fit estimates the parameters of the step from
+// calibration data, and Apply transforms data using the fitted parameters.
+// Clone returns a deep copy of the step and its parameters. The parameters
+// are serialized to JSON together with the step name.
+type Step interface {
+	Name() string
+	Fit(X *mat.Dense) error
+	Apply(X *mat.Dense) (*mat.Dense, error)
+	Clone() Step
+}
+
+// Pipeline is an ordered list of preprocessing steps.
+type Pipeline struct {
+	Steps []Step
+}
+
+// Centering subtracts the column means of the calibration data.
+type Centering struct {
+	Mean []float64 `json:"mean"`
+}
+
+// Step names used in pipeline specifications and in JSON.
+const (
+	StepCenter    = "center"
+	StepScale     = "scale"
+	StepAutoscale = "autoscale" // Shorthand for center followed by scale
+	StepSavgol    = "savgol"
+)
+
+// Fit fits each step in order, each on the output of the previous step.
+func (p *Pipeline) Fit(X *mat.Dense) error {
+	_, err := p.FitApply(X)
+	return err
+}
+
+// FitApply fits each step in order and returns the transformed data.
+func (p *Pipeline) FitApply(X *mat.Dense) (*mat.Dense, error) {
+	for _, step := range p.Steps {
+		if err := step.Fit(X); err != nil {
+			return nil, fmt.Errorf("error fitting %s: %w", step.Name(), err)
+		}
+		var err error
+		if X, err = step.Apply(X); err != nil {
+			return nil, fmt.Errorf("error applying %s: %v", step.Name(), err)
+		}
+	}
+	return X, nil
+}
+
+// Apply applies the fitted steps in order to X.
+func (p *Pipeline) Apply(X *mat.Dense) (*mat.Dense, error) {
+	for _, step := range p.Steps {
+		var err error
+		if X, err = step.Apply(X); err != nil {
+			return nil, fmt.Errorf("error applying %s: %v", step.Name(), err)
+		}
+	}
+	return X, nil
+}
+
+// Invert undoes the fitted steps in reverse order, e.g. to express
+// predictions in the original units. Every step must have an Invert method.
+func (p *Pipeline) Invert(X *mat.Dense) (*mat.Dense, error) {
+	for i := len(p.Steps) - 1; i >= 0; i-- {
+		step, ok := p.Steps[i].(interface {
+			Invert(X *mat.Dense) (*mat.Dense, error)
+		})
+		if !ok {
+			return nil, fmt.Errorf("%s cannot be inverted", p.Steps[i].Name())
+		}
+		var err error
+		if X, err = step.Invert(X); err != nil {
+			return nil, fmt.Errorf("error inverting %s: %v", p.Steps[i].Name(), err)
+		}
+	}
+	return X, nil
+}
+
+// Clone returns a deep copy of the pipeline, so it can be refitted without
+// changing the original (e.g. in cross-validation).
+func (p *Pipeline) Clone() *Pipeline {
+	clone := &Pipeline{Steps: make([]Step, len(p.Steps))}
+	for i, step := range p.Steps {
+		clone.Steps[i] = step.Clone()
+	}
+	return clone
+}
+
+// Dropped returns the indices of the input columns removed by the fitted
+// steps, e.g. constant columns dropped by scaling, given the number of input
+// columns.
+func (p *Pipeline) Dropped(cols int) []int {
+	kept := make([]int, cols) // Input column of each column after each step
+	for j := range kept {
+		kept[j] = j
+	}
+	for _, step := range p.Steps {
+		d, ok := step.(interface{ DroppedColumns() []int })
+		if !ok {
+			continue
+		}
+		var next []int
+		for j, col := range kept {
+			if !slices.Contains(d.DroppedColumns(), j) {
+				next = append(next, col)
+			}
+		}
+		kept = next
+	}
+
+	var dropped []int
+	for j := 0; j < cols; j++ {
+		if !slices.Contains(kept, j) {
+			dropped = append(dropped, j)
+		}
+	}
+	return dropped
+}
+
+// SettleDropped makes the fitted steps drop the same columns when they are
+// refitted, e.g. to the training objects of cross-validation, so the same
+// variables are modeled. Other columns that are constant in the data they
+// are refitted to are left unscaled. Clones do not keep this setting.
+func (p *Pipeline) SettleDropped() {
+	for _, step := range p.Steps {
+		if s, ok := step.(*Scaling); ok {
+			s.settled = true
+		}
+	}
+}
+
+// String returns the names of the steps separated by commas.
+func (p *Pipeline) String() string {
+	names := make([]string, len(p.Steps))
+	for i, step := range p.Steps {
+		names[i] = step.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// stepJSON is the serialized form of a pipeline step.
+type stepJSON struct {
+	Name   string          `json:"name"`
+	Params json.RawMessage `json:"params"`
+}
+
+// MarshalJSON serializes the pipeline as a list of named steps with parameters.
+func (p *Pipeline) MarshalJSON() ([]byte, error) {
+	steps := make([]stepJSON, len(p.Steps))
+	for i, step := range p.Steps {
+		params, err := json.Marshal(step)
+		if err != nil {
+			return nil, err
+		}
+		steps[i] = stepJSON{Name: step.Name(), Params: params}
+	}
+	return json.Marshal(steps)
+}
+
+// UnmarshalJSON restores a pipeline serialized by MarshalJSON.
+func (p *Pipeline) UnmarshalJSON(data []byte) error {
+	var steps []stepJSON
+	if err := json.Unmarshal(data, &steps); err != nil {
+		return err
+	}
+	p.Steps = make([]Step, len(steps))
+	for i, s := range steps {
+		step, err := newStep(s.Name)
+		if err != nil {
+			return err
+		}
+		if len(s.Params) > 0 {
+			if err := json.Unmarshal(s.Params, step); err != nil {
+				return fmt.Errorf("error decoding %s parameters: %v", s.Name, err)
+			}
+		}
+		p.Steps[i] = step
+	}
+	return nil
+}
+
+// newStep creates an unfitted step from its name.
+func newStep(name string) (Step, error) {
+	switch name {
+	case StepCenter:
+		return &Centering{}, nil
+	case StepScale:
+		return &Scaling{Method: ScalingUV}, nil
+	case ScalingPareto, ScalingRange, ScalingVast, ScalingLevel:
+		return &Scaling{Method: name}, nil
+	case StepWeight:
+		return &Scaling{Method: ScalingNone}, nil
+	case StepBlock:
+		return &BlockScaling{}, nil
+	case ScatterSNV, ScatterMSC, ScatterEMSC:
+		return &ScatterCorrection{Method: name}, nil
+	case StepSavgol:
+		return &SavitzkyGolay{}, nil
+	}
+	return nil, fmt.Errorf("unknown preprocessing step %q", name)
+}
+
+// ParsePipeline creates an unfitted pipeline from a comma separated list of
+// steps, e.g. "snv,sg1,center". The available steps are:
+//
+//	center               mean centering
+//	scale                scaling to unit variance
+//	pareto               Pareto scaling (by the square root of the standard deviation)
+//	range                range scaling (by max - min)
+//	vast                 VAST scaling (by the variance divided by the mean)
+//	level                level scaling (by the mean)
+//	weight               variable weights only, the weights are set separately
+//	block                block scaling to equal total variance, the blocks are set separately
+//	autoscale            center followed by scale
+//	snv                  Standard Normal Variate
+//	msc                  Multiplicative Scatter Correction
+//	emsc[:order]         Extended MSC, polynomial order 2 by default
+//	sgD[:window[:order[:edge]]]
+//	                     Savitzky–Golay filter with derivative D (0, 1 or 2),
+//	                     window 11, order 2 and edge mode interp by default
+func ParsePipeline(spec string) (*Pipeline, error) {
+	p := &Pipeline{}
+	for _, token := range strings.Split(spec, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		fields := strings.Split(token, ":")
+		name, args := strings.ToLower(fields[0]), fields[1:]
+
+		switch {
+		case name == StepAutoscale && len(args) == 0:
+			p.Steps = append(p.Steps, &Centering{}, &Scaling{Method: ScalingUV})
+		case name == ScatterEMSC && len(args) <= 1:
+			order := 2
+			if len(args) == 1 {
+				var err error
+				if order, err = strconv.Atoi(args[0]); err != nil {
+					return nil, fmt.Errorf("invalid EMSC order in %q", token)
+				}
+			}
+			p.Steps = append(p.Steps, &ScatterCorrection{Method: ScatterEMSC, Order: order})
+		case len(name) == 3 && strings.HasPrefix(name, "sg") && len(args) <= 3:
+			sg, err := parseSavgol(name, args)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Savitzky-Golay step %q: %v", token, err)
+			}
+			p.Steps = append(p.Steps, sg)
+		case len(args) == 0:
+			step, err := newStep(name)
+			if err != nil {
+				return nil, err
+			}
+			p.Steps = append(p.Steps, step)
+		default:
+			return nil, fmt.Errorf("invalid preprocessing step %q", token)
+		}
+	}
+	return p, nil
+}
+
+// parseSavgol parses a Savitzky–Golay step of the form sgD[:window[:order[:edge]]].
+func parseSavgol(name string, args []string) (*SavitzkyGolay, error) {
+	deriv, err := strconv.Atoi(name[2:])
+	if err != nil {
+		return nil, err
+	}
+	sg := &SavitzkyGolay{Window: 11, Order: 2, Deriv: deriv, Edge: EdgeInterp}
+	if len(args) > 0 {
+		if sg.Window, err = strconv.Atoi(args[0]); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) > 1 {
+		if sg.Order, err = strconv.Atoi(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) > 2 {
+		sg.Edge = args[2]
+	}
+	return sg, nil
+}
+
+// Name returns the step name.
+func (c *Centering) Name() string { return StepCenter }
+
+// Clone returns a copy of the step.
+func (c *Centering) Clone() Step { return &Centering{Mean: slices.Clone(c.Mean)} }
+
+// Invert adds the fitted column means back to centered data.
+func (c *Centering) Invert(X *mat.Dense) (*mat.Dense, error) {
+	r, cols := X.Dims()
+	if cols != len(c.Mean) {
+		return nil, fmt.Errorf("data has %d variables, centering was fitted to %d", cols, len(c.Mean))
+	}
+	origX := mat.NewDense(r, cols, nil)
+	for i := 0; i < r; i++ {
+		for j := 0; j < cols; j++ {
+			origX.Set(i, j, X.At(i, j)+c.Mean[j])
+		}
+	}
+	return origX, nil
+}
+
+// Fit calculates the column means of X.
+func (c *Centering) Fit(X *mat.Dense) error {
+	c.Mean = colMean(X)
+	return nil
+}
+
+// Apply subtracts the fitted column means from X.
+func (c *Centering) Apply(X *mat.Dense) (*mat.Dense, error) {
+	r, cols := X.Dims()
+	if cols != len(c.Mean) {
+		return nil, fmt.Errorf("data has %d variables, centering was fitted to %d", cols, len(c.Mean))
+	}
+	centeredX := mat.NewDense(r, cols, nil)
+	for i := 0; i < r; i++ {
+		for j := 0; j < cols; j++ {
+			centeredX.Set(i, j, X.At(i, j)-c.Mean[j])
+		}
+	}
+	return centeredX, nil
+}
+
+// Name returns the step name, which is the scatter correction method.
+func (s *ScatterCorrection) Name() string { return s.Method }
+
+// Clone returns a copy of the step.
+func (s *ScatterCorrection) Clone() Step {
+	clone := *s
+	clone.Reference = slices.Clone(s.Reference)
+	return &clone
+}
+
+// Fit fits the reference spectrum of MSC and EMSC to X.
+func (s *ScatterCorrection) Fit(X *mat.Dense) error {
+	fitted, err := FitScatterCorrection(X, s.Method, s.Order)
+	if err != nil {
+		return err
+	}
+	*s = *fitted
+	return nil
+}
+
+// Name returns the step name.
+func (sg *SavitzkyGolay) Name() string { return StepSavgol }
+
+// Clone returns a copy of the step.
+func (sg *SavitzkyGolay) Clone() Step {
+	clone := *sg
+	return &clone
+}
+
+// Fit checks the filter settings against X. The filter has no fitted parameters.
+func (sg *SavitzkyGolay) Fit(X *mat.Dense) error {
+	_, c := X.Dims()
+	return sg.validate(c)
+}
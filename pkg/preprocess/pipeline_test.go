@@ -0,0 +1,205 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the preprocessing pipeline.
+package preprocess
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// TestParsePipeline checks the pipeline specification parser.
+func TestParsePipeline(t *testing.T) {
+	p, err := ParsePipeline("snv, sg1:7:3, emsc:1, autoscale")
+	if err != nil {
+		t.Fatalf("ParsePipeline() error = %v", err)
+	}
+	if got, want := p.String(), "snv,savgol,emsc,center,scale"; got != want {
+		t.Errorf("ParsePipeline() steps = %q, want %q", got, want)
+	}
+	sg := p.Steps[1].(*SavitzkyGolay)
+	if sg.Window != 7 || sg.Order != 3 || sg.Deriv != 1 {
+		t.Errorf("ParsePipeline() Savitzky-Golay settings = %+v", sg)
+	}
+	if order := p.Steps[2].(*ScatterCorrection).Order; order != 1 {
+		t.Errorf("ParsePipeline() EMSC order = %d, want 1", order)
+	}
+
+	for _, spec := range []string{"unknown", "center:1", "sgx", "emsc:a"} {
+		if _, err := ParsePipeline(spec); err == nil {
+			t.Errorf("ParsePipeline(%q) expected an error", spec)
+		}
+	}
+}
+
+// TestPipelineAutoscale checks that an autoscale pipeline gives the same
+// result as Autoscale, also after a JSON round trip.
+func TestPipelineAutoscale(t *testing.T) {
+	X := getTestData("raw")
+	p, err := ParsePipeline("autoscale")
+	if err != nil {
+		t.Fatalf("ParsePipeline() error = %v", err)
+	}
+	got, err := p.FitApply(X)
+	if err != nil {
+		t.Fatalf("FitApply() error = %v", err)
+	}
+	if expected := getTestData("autoscaled"); !almostEqual(got, expected, 0.01) {
+		t.Errorf("Pipeline autoscaling was incorrect, got: %v, want: %v.", got, expected)
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var restored Pipeline
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	again, err := restored.Apply(X)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !almostEqual(again, got, 1e-12) {
+		t.Errorf("Restored pipeline gave a different result, got: %v, want: %v.", again, got)
+	}
+
+	if _, err := restored.Apply(getTestData("raw").Slice(0, 7, 0, 3).(*mat.Dense)); err == nil {
+		t.Errorf("Apply() expected an error for data with the wrong number of variables")
+	}
+}
+
+// TestPipelineInvert checks that a fitted scaling and centering pipeline
+// can be inverted, and that steps without an inverse are reported.
+func TestPipelineInvert(t *testing.T) {
+	X := getTestData("raw")
+	p, err := ParsePipeline("pareto,center")
+	if err != nil {
+		t.Fatalf("ParsePipeline() error = %v", err)
+	}
+	Xpre, err := p.FitApply(X)
+	if err != nil {
+		t.Fatalf("FitApply() error = %v", err)
+	}
+	restored, err := p.Invert(Xpre)
+	if err != nil {
+		t.Fatalf("Invert() error = %v", err)
+	}
+	if !almostEqual(restored, X, 1e-9) {
+		t.Errorf("Invert() did not restore the data, got: %v, want: %v.", restored, X)
+	}
+
+	snv, err := ParsePipeline("snv,center")
+	if err != nil {
+		t.Fatalf("ParsePipeline() error = %v", err)
+	}
+	if Xpre, err = snv.FitApply(X); err != nil {
+		t.Fatalf("FitApply() error = %v", err)
+	}
+	if _, err := snv.Invert(Xpre); err == nil {
+		t.Errorf("Invert() expected an error for SNV")
+	}
+}
+
+// TestPipelineClone checks that a clone is independent of the original and
+// keeps NaN parameters, e.g. the mean of a column without values.
+func TestPipelineClone(t *testing.T) {
+	X := mat.NewDense(3, 2, []float64{1, math.NaN(), 2, math.NaN(), 4, math.NaN()})
+	p, err := ParsePipeline("center")
+	if err != nil {
+		t.Fatalf("ParsePipeline() error = %v", err)
+	}
+	if err := p.Fit(X); err != nil {
+		t.Fatalf("Fit() error = %v", err)
+	}
+	clone := p.Clone()
+	mean := clone.Steps[0].(*Centering).Mean
+	if len(mean) != 2 || mean[0] != 7.0/3 || !math.IsNaN(mean[1]) {
+		t.Errorf("Clone() mean = %v, want [7/3 NaN]", mean)
+	}
+	mean[0] = 0
+	if p.Steps[0].(*Centering).Mean[0] == 0 {
+		t.Errorf("Clone() shares the fitted mean with the original")
+	}
+}
+
+// TestPipelineDropped checks that the columns dropped by scaling are reported
+// as input columns, also after a JSON round trip.
+func TestPipelineDropped(t *testing.T) {
+	X := mat.NewDense(3, 4, []float64{
+		1, 5, 2, 0,
+		2, 5, 4, 0,
+		3, 5, 9, 0,
+	})
+	p, err := ParsePipeline("center,scale")
+	if err != nil {
+		t.Fatalf("ParsePipeline() error = %v", err)
+	}
+	p.Steps[1].(*Scaling).Constant = ConstantDrop
+	got, err := p.FitApply(X)
+	if err != nil {
+		t.Fatalf("FitApply() error = %v", err)
+	}
+	if _, c := got.Dims(); c != 2 {
+		t.Errorf("FitApply() gave %d columns, want 2", c)
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var restored Pipeline
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	dropped := restored.Dropped(4)
+	if len(dropped) != 2 || dropped[0] != 1 || dropped[1] != 3 {
+		t.Errorf("Dropped() = %v, want [1 3]", dropped)
+	}
+}
+
+// TestPipelineSettleDropped checks that a settled pipeline drops the same
+// columns when refitted, and leaves other constant columns unscaled.
+func TestPipelineSettleDropped(t *testing.T) {
+	X := mat.NewDense(3, 3, []float64{
+		1, 5, 2,
+		2, 5, 2,
+		3, 5, 9,
+	})
+	p, err := ParsePipeline("scale")
+	if err != nil {
+		t.Fatalf("ParsePipeline() error = %v", err)
+	}
+	p.Steps[0].(*Scaling).Constant = ConstantDrop
+	if _, err := p.FitApply(X); err != nil {
+		t.Fatalf("FitApply() error = %v", err)
+	}
+
+	// Column 3 is constant in the first two objects, column 2 in all
+	p.SettleDropped()
+	got, err := p.FitApply(mat.DenseCopyOf(X.Slice(0, 2, 0, 3)))
+	if err != nil {
+		t.Fatalf("FitApply() of settled pipeline error = %v", err)
+	}
+	if dropped := p.Dropped(3); len(dropped) != 1 || dropped[0] != 1 {
+		t.Errorf("Dropped() = %v, want [1]", dropped)
+	}
+	if _, c := got.Dims(); c != 2 || got.At(0, 1) != 2 {
+		t.Errorf("FitApply() of settled pipeline = %v, want column 3 unscaled", mat.Formatted(got))
+	}
+}
@@ -22,12 +22,27 @@ import (
 )
 
 // colMean calculates the mean of each column in a matrix.
+// Missing (NaN) values are ignored, so each mean is calculated over the
+// available values only.
 func colMean(X *mat.Dense) []float64 {
 	r, c := X.Dims()
 	colMeans := make([]float64, c)
 	for j := 0; j < c; j++ {
-		col := X.ColView(j)
-		colMeans[j] = mat.Sum(col) / float64(r)
+		var sum float64
+		var n int
+		for i := 0; i < r; i++ {
+			value := X.At(i, j)
+			if math.IsNaN(value) {
+				continue
+			}
+			sum += value
+			n++
+		}
+		if n == 0 {
+			colMeans[j] = math.NaN()
+			continue
+		}
+		colMeans[j] = sum / float64(n)
 	}
 	return colMeans
 }
@@ -46,34 +61,57 @@ func MeanCenter(X *mat.Dense) (*mat.Dense, []float64) {
 	return centeredX, colMeans
 }
 
-// colStdDev calculates the standard deviation of each column in a matrix.
+// colStdDev calculates the population standard deviation of each column in
+// a matrix. Missing (NaN) values are ignored.
 func colStdDev(X *mat.Dense) []float64 {
+	return colStdDevDDOF(X, 0)
+}
+
+// colStdDevDDOF calculates the standard deviation of each column in a matrix,
+// dividing the sum of squares by n - ddof, where n is the number of available
+// values. Use ddof 0 for the population and 1 for the sample standard
+// deviation. Missing (NaN) values are ignored.
+func colStdDevDDOF(X *mat.Dense, ddof int) []float64 {
 	r, c := X.Dims()
 	colMeans := colMean(X)
 	stdDevs := make([]float64, c)
 
 	for j := 0; j < c; j++ {
 		var sumSq float64   // Initialize sum of squares to zero
+		var n int           // Number of available values
 		col := X.ColView(j) // Get the column
 		mean := colMeans[j] // Get the mean for the column
 
 		for i := 0; i < r; i++ { // Loop over rows
-			diff := col.AtVec(i) - mean
+			value := col.AtVec(i)
+			if math.IsNaN(value) {
+				continue
+			}
+			diff := value - mean
 			sumSq += diff * diff
+			n++
+		}
+		if n-ddof <= 0 {
+			stdDevs[j] = math.NaN()
+			continue
 		}
-		stdDevs[j] = math.Sqrt(sumSq / float64(r)) // This is the one to use
-		//stdDevs[j] = math.Sqrt(sumSq / float64(r-1))
-		//stdDevs[j] = math.Sqrt(sumSq / float64(r/(r-1)))
+		stdDevs[j] = math.Sqrt(sumSq / float64(n-ddof))
 	}
 	return stdDevs
 }
 
 // scaleByStdDev scales each column of the matrix by its standard deviation.
+// Columns with zero or near-zero variance are left unscaled, and their
+// returned standard deviation is 1.
 func ScaleByStdDev(X *mat.Dense) (*mat.Dense, []float64) {
 	r, c := X.Dims()                   // Get the dimensions of the matrix
 	colStd := colStdDev(X)             // Calculate standard deviations for each column
 	scaledX := mat.NewDense(r, c, nil) // Create a new matrix to store the scaled values
 
+	for _, j := range ConstantColumns(X, DefaultConstantTolerance) {
+		colStd[j] = 1 // Avoid division by zero
+	}
+
 	for j := 0; j < c; j++ {
 		std := colStd[j]
 		for i := 0; i < r; i++ {
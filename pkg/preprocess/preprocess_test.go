@@ -112,3 +112,40 @@ func almostEqual(a, b *mat.Dense, tol float64) bool {
 	}
 	return true
 }
+
+// TestMeanCenterMissing checks that means are calculated over the available
+// values only, and that missing values stay missing.
+func TestMeanCenterMissing(t *testing.T) {
+	X := mat.NewDense(3, 2, []float64{
+		1, 10,
+		math.NaN(), 20,
+		3, math.NaN(),
+	})
+
+	centered, means := MeanCenter(X)
+	if means[0] != 2 || means[1] != 15 {
+		t.Errorf("Means were incorrect, got: %v, want: [2 15].", means)
+	}
+	if !math.IsNaN(centered.At(1, 0)) || !math.IsNaN(centered.At(2, 1)) {
+		t.Errorf("Missing values should remain NaN, got: %v.", mat.Formatted(centered))
+	}
+
+	_, std := ScaleByStdDev(centered)
+	if std[0] != 1 || std[1] != 5 {
+		t.Errorf("Standard deviations were incorrect, got: %v, want: [1 5].", std)
+	}
+}
+
+// TestScaleByStdDevConstant checks that constant columns are left unscaled.
+func TestScaleByStdDevConstant(t *testing.T) {
+	X := mat.NewDense(3, 2, []float64{1, 0, 2, 0, 3, 0})
+	scaled, std := ScaleByStdDev(X)
+	if std[1] != 1 {
+		t.Errorf("ScaleByStdDev() std of constant column = %v, want 1", std[1])
+	}
+	for i := 0; i < 3; i++ {
+		if v := scaled.At(i, 1); v != 0 {
+			t.Errorf("ScaleByStdDev() constant column value = %v, want 0", v)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains Savitzky–Golay smoothing and derivatives
+// of the rows (spectra) of a matrix.
+package preprocess
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Savitzky–Golay edge handling modes.
+const (
+	EdgeInterp  = "interp"  // Fit a polynomial to the first/last window and evaluate it at the edge points
+	EdgeNearest = "nearest" // Pad by repeating the first/last value
+	EdgeMirror  = "mirror"  // Pad by reflecting the row about the first/last value
+)
+
+// SavitzkyGolay holds the settings of a Savitzky–Golay filter.
+type SavitzkyGolay struct {
+	Window int    `json:"window"` // Window width, an odd number of points
+	Order  int    `json:"order"`  // Polynomial order
+	Deriv  int    `json:"deriv"`  // Derivative order: 0 (smoothing), 1 or 2
+	Edge   string `json:"edge"`   // Edge handling mode, EdgeInterp if empty
+}
+
+// SavitzkyGolayFilter applies a Savitzky–Golay filter to each row of X. The
+// derivatives are calculated per variable index, i.e. assuming unit spacing
+// between the variables.
+func SavitzkyGolayFilter(X *mat.Dense, window, order, deriv int, edge string) (*mat.Dense, error) {
+	sg := &SavitzkyGolay{Window: window, Order: order, Deriv: deriv, Edge: edge}
+	return sg.Apply(X)
+}
+
+// validate checks the filter settings against the number of variables.
+func (sg *SavitzkyGolay) validate(cols int) error {
+	if sg.Window < 3 || sg.Window%2 == 0 {
+		return fmt.Errorf("Savitzky-Golay window must be an odd number of at least 3, got %d", sg.Window)
+	}
+	if sg.Order < 0 || sg.Order >= sg.Window {
+		return fmt.Errorf("Savitzky-Golay polynomial order must be between 0 and %d, got %d", sg.Window-1, sg.Order)
+	}
+	if sg.Deriv < 0 || sg.Deriv > 2 || sg.Deriv > sg.Order {
+		return fmt.Errorf("Savitzky-Golay derivative must be 0, 1 or 2 and not above the polynomial order, got %d", sg.Deriv)
+	}
+	if sg.Window > cols {
+		return fmt.Errorf("Savitzky-Golay window (%d) is wider than the number of variables (%d)", sg.Window, cols)
+	}
+	switch sg.Edge {
+	case "", EdgeInterp, EdgeNearest, EdgeMirror:
+		return nil
+	}
+	return fmt.Errorf("unknown Savitzky-Golay edge mode %q", sg.Edge)
+}
+
+// Apply applies the Savitzky–Golay filter to each row of X.
+func (sg *SavitzkyGolay) Apply(X *mat.Dense) (*mat.Dense, error) {
+	r, c := X.Dims()
+	if err := sg.validate(c); err != nil {
+		return nil, err
+	}
+	half := sg.Window / 2
+
+	// Coefficients for each position in the window, position half is the center
+	coefs := make([][]float64, sg.Window)
+	for z := range coefs {
+		var err error
+		if coefs[z], err = savgolCoefficients(half, sg.Order, sg.Deriv, z-half); err != nil {
+			return nil, err
+		}
+	}
+	center := coefs[half]
+
+	filteredX := mat.NewDense(r, c, nil)
+	padded := make([]float64, c+2*half)
+	for i := 0; i < r; i++ {
+		row := X.RawRowView(i)
+		copy(padded[half:], row)
+		for k := 1; k <= half; k++ {
+			switch sg.Edge {
+			case EdgeNearest:
+				padded[half-k] = row[0]
+				padded[half+c-1+k] = row[c-1]
+			case EdgeMirror:
+				padded[half-k] = row[min(k, c-1)]
+				padded[half+c-1+k] = row[max(c-1-k, 0)]
+			}
+		}
+
+		for j := 0; j < c; j++ {
+			var v float64
+			switch {
+			case (sg.Edge == "" || sg.Edge == EdgeInterp) && j < half:
+				// Evaluate the polynomial of the first window at position j
+				for k, h := range coefs[j] {
+					v += h * row[k]
+				}
+			case (sg.Edge == "" || sg.Edge == EdgeInterp) && j >= c-half:
+				// Evaluate the polynomial of the last window at position j
+				w := coefs[j-(c-sg.Window)]
+				for k, h := range w {
+					v += h * row[c-sg.Window+k]
+				}
+			default:
+				for k, h := range center {
+					v += h * padded[j+k]
+				}
+			}
+			filteredX.Set(i, j, v)
+		}
+	}
+	return filteredX, nil
+}
+
+// savgolCoefficients calculates the filter coefficients that give the
+// deriv-th derivative, at position z0 (relative to the window center), of
+// the least squares polynomial of the given order fitted to a window of
+// 2*half+1 points.
+func savgolCoefficients(half, order, deriv, z0 int) ([]float64, error) {
+	window := 2*half + 1
+	A := mat.NewDense(window, order+1, nil)
+	for i := 0; i < window; i++ {
+		z := float64(i - half)
+		v := 1.0
+		for k := 0; k <= order; k++ {
+			A.Set(i, k, v)
+			v *= z
+		}
+	}
+
+	// Polynomial coefficients are (A'A)^-1 A' x
+	var AtA, inv, pinv mat.Dense
+	AtA.Mul(A.T(), A)
+	if err := inv.Inverse(&AtA); err != nil {
+		return nil, fmt.Errorf("error calculating Savitzky-Golay coefficients: %v", err)
+	}
+	pinv.Mul(&inv, A.T())
+
+	// Derivative of z^k at z0 is k!/(k-deriv)! z0^(k-deriv)
+	coefs := make([]float64, window)
+	for k := deriv; k <= order; k++ {
+		factor := 1.0
+		for m := k - deriv + 1; m <= k; m++ {
+			factor *= float64(m)
+		}
+		for m := 0; m < k-deriv; m++ {
+			factor *= float64(z0)
+		}
+		for i := 0; i < window; i++ {
+			coefs[i] += factor * pinv.At(k, i)
+		}
+	}
+	return coefs, nil
+}
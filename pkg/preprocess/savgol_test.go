@@ -0,0 +1,78 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the Savitzky–Golay filter.
+package preprocess
+
+import (
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// TestSavitzkyGolayPolynomial checks that a quadratic row and its
+// derivatives are reproduced exactly with a second order filter.
+func TestSavitzkyGolayPolynomial(t *testing.T) {
+	c := 9
+	X := mat.NewDense(1, c, nil)
+	want := [3]*mat.Dense{mat.NewDense(1, c, nil), mat.NewDense(1, c, nil), mat.NewDense(1, c, nil)}
+	for j := 0; j < c; j++ {
+		x := float64(j)
+		X.Set(0, j, 3+x+0.5*x*x)
+		want[0].Set(0, j, 3+x+0.5*x*x)
+		want[1].Set(0, j, 1+x)
+		want[2].Set(0, j, 1)
+	}
+
+	for deriv := 0; deriv <= 2; deriv++ {
+		got, err := SavitzkyGolayFilter(X, 5, 2, deriv, EdgeInterp)
+		if err != nil {
+			t.Fatalf("SavitzkyGolayFilter() error = %v", err)
+		}
+		if !almostEqual(got, want[deriv], 1e-9) {
+			t.Errorf("Derivative %d was incorrect, got: %v, want: %v.", deriv, got, want[deriv])
+		}
+	}
+}
+
+// TestSavitzkyGolayEdges checks the padding edge modes.
+func TestSavitzkyGolayEdges(t *testing.T) {
+	X := mat.NewDense(1, 5, []float64{1, 2, 3, 4, 5})
+
+	// A moving average (order 0) of width 3 shows the padding directly
+	testCases := []struct {
+		edge string
+		want []float64
+	}{
+		{EdgeNearest, []float64{4.0 / 3, 2, 3, 4, 14.0 / 3}},
+		{EdgeMirror, []float64{5.0 / 3, 2, 3, 4, 13.0 / 3}},
+		{EdgeInterp, []float64{2, 2, 3, 4, 4}},
+	}
+	for _, tc := range testCases {
+		got, err := SavitzkyGolayFilter(X, 3, 0, 0, tc.edge)
+		if err != nil {
+			t.Fatalf("SavitzkyGolayFilter() error = %v", err)
+		}
+		if !almostEqual(got, mat.NewDense(1, 5, tc.want), 1e-9) {
+			t.Errorf("Edge mode %s was incorrect, got: %v, want: %v.", tc.edge, got, tc.want)
+		}
+	}
+
+	if _, err := SavitzkyGolayFilter(X, 4, 2, 0, EdgeInterp); err == nil {
+		t.Errorf("SavitzkyGolayFilter() expected an error for an even window")
+	}
+	if _, err := SavitzkyGolayFilter(X, 3, 1, 2, EdgeInterp); err == nil {
+		t.Errorf("SavitzkyGolayFilter() expected an error for a derivative above the order")
+	}
+}
@@ -0,0 +1,397 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains column scaling methods: unit variance,
+// Pareto, range, VAST and level scaling.
+package preprocess
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Scaling methods.
+const (
+	ScalingUV     = "uv"     // Unit variance, divide by the standard deviation
+	ScalingPareto = "pareto" // Divide by the square root of the standard deviation
+	ScalingRange  = "range"  // Divide by the range (max - min)
+	ScalingVast   = "vast"   // Divide by the variance over the mean (variable stability)
+	ScalingLevel  = "level"  // Divide by the mean
+	ScalingNone   = "none"   // No scaling, only weights are applied
+)
+
+// ScalingStep returns the pipeline step of a scaling method, e.g. given with
+// --scaling on the command line, or "" for none.
+func ScalingStep(method string) (string, error) {
+	switch method {
+	case "", ScalingNone:
+		return "", nil
+	case ScalingUV:
+		return StepScale, nil
+	case ScalingPareto, ScalingRange, ScalingVast, ScalingLevel:
+		return method, nil
+	}
+	return "", fmt.Errorf("unknown scaling method %q", method)
+}
+
+// StepWeight is the name of a scaling step that only applies variable weights.
+const StepWeight = "weight"
+
+// Policies for columns with zero or near-zero variance, e.g. dead sensor
+// channels, which cannot be scaled.
+const (
+	ConstantError = "error" // Fail with an error naming the columns
+	ConstantDrop  = "drop"  // Remove the columns from the data
+	ConstantKeep  = "keep"  // Leave the columns unscaled
+)
+
+// DefaultConstantTolerance is the default relative tolerance for detecting
+// near-constant columns.
+const DefaultConstantTolerance = 1e-8
+
+// Scaling divides each column by a scaling factor fitted to the calibration
+// data.
+//
+// Columns with zero or near-zero variance are handled according to the
+// Constant policy. With ConstantDrop the columns are removed by Apply, so the
+// output has fewer columns than the input, and Dropped lists the removed
+// columns. With ConstantKeep the scaling factor of the columns is 1.
+//
+// The factors of VAST and level scaling depend on the column means, so these
+// methods must be fitted to data that is not yet centered. Since scaling and
+// centering commute, scaling before centering gives the usual results, e.g.
+// level scaling followed by centering gives (x - mean)/mean.
+//
+// Weights are given by the user, one for each input column, and are not
+// changed by Fit. After scaling each column is multiplied by its weight, so
+// e.g. unit variance scaling with weight w gives the column variance w².
+// Weighting is applied after scaling, as scaling would otherwise undo it.
+type Scaling struct {
+	Method  string    `json:"method"`            // One of the Scaling constants, ScalingUV if empty
+	Factors []float64 `json:"factors"`           // The divisor of each column
+	DDOF    int       `json:"ddof,omitempty"`    // Delta degrees of freedom of the standard deviation, 0 or 1
+	Weights []float64 `json:"weights,omitempty"` // Multiplier of each column, none if empty
+
+	Constant  string  `json:"constant,omitempty"`  // One of the Constant policies, ConstantError if empty
+	Tolerance float64 `json:"tolerance,omitempty"` // Tolerance for near-constant columns, DefaultConstantTolerance if zero
+	Dropped   []int   `json:"dropped,omitempty"`   // Indices of the columns removed by Apply
+
+	settled bool // Keep Dropped when refitted, see Pipeline.SettleDropped
+}
+
+// UnmarshalJSON decodes a scaling step. The scaling factors are also read
+// from the key std, used by models saved before other scaling methods than
+// unit variance were added.
+func (s *Scaling) UnmarshalJSON(data []byte) error {
+	type scaling Scaling // Without this method
+	v := struct {
+		*scaling
+		Std []float64 `json:"std"`
+	}{scaling: (*scaling)(s)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if s.Factors == nil {
+		s.Factors = v.Std
+	}
+	return nil
+}
+
+// ConstantColumns returns the indices of the columns of X with zero or
+// near-zero variance. A column is near-constant when its standard deviation
+// is at most tol times its largest absolute value, so the test does not
+// depend on the units of the column. Missing (NaN) values are ignored, and
+// columns without available values are reported as constant.
+func ConstantColumns(X *mat.Dense, tol float64) []int {
+	r, c := X.Dims()
+	stds := colStdDev(X)
+	var constant []int
+	for j := 0; j < c; j++ {
+		var maxAbs float64
+		for i := 0; i < r; i++ {
+			if v := math.Abs(X.At(i, j)); v > maxAbs { // NaN compares false
+				maxAbs = v
+			}
+		}
+		if math.IsNaN(stds[j]) || stds[j] <= tol*maxAbs {
+			constant = append(constant, j)
+		}
+	}
+	return constant
+}
+
+// ScalingFactors calculates the scaling factor of each column of X for the
+// given method, using the population standard deviation. Missing (NaN)
+// values are ignored.
+func ScalingFactors(X *mat.Dense, method string) ([]float64, error) {
+	return ScalingFactorsDDOF(X, method, 0)
+}
+
+// ScalingFactorsDDOF calculates the scaling factors like ScalingFactors, with
+// the standard deviation calculated as sqrt(sum of squares / (n - ddof)).
+// Use ddof 0 for the population and 1 for the sample standard deviation.
+func ScalingFactorsDDOF(X *mat.Dense, method string, ddof int) ([]float64, error) {
+	if ddof < 0 {
+		return nil, fmt.Errorf("invalid degrees of freedom correction %d", ddof)
+	}
+	switch method {
+	case "", ScalingUV:
+		return colStdDevDDOF(X, ddof), nil
+	case ScalingNone:
+		_, c := X.Dims()
+		factors := make([]float64, c)
+		for j := range factors {
+			factors[j] = 1
+		}
+		return factors, nil
+	case ScalingPareto:
+		factors := colStdDevDDOF(X, ddof)
+		for j, std := range factors {
+			factors[j] = math.Sqrt(std)
+		}
+		return factors, nil
+	case ScalingRange:
+		return colRange(X), nil
+	case ScalingVast:
+		means := colMean(X)
+		factors := colStdDevDDOF(X, ddof)
+		for j, std := range factors {
+			if means[j] == 0 {
+				return nil, fmt.Errorf("VAST scaling of column %d with zero mean, scale before centering", j+1)
+			}
+			factors[j] = std * std / means[j]
+		}
+		return factors, nil
+	case ScalingLevel:
+		factors := colMean(X)
+		for j, mean := range factors {
+			if mean == 0 {
+				return nil, fmt.Errorf("level scaling of column %d with zero mean, scale before centering", j+1)
+			}
+		}
+		return factors, nil
+	}
+	return nil, fmt.Errorf("unknown scaling method %q", method)
+}
+
+// colRange calculates the range (max - min) of each column in a matrix.
+// Missing (NaN) values are ignored.
+func colRange(X *mat.Dense) []float64 {
+	r, c := X.Dims()
+	ranges := make([]float64, c)
+	for j := 0; j < c; j++ {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for i := 0; i < r; i++ {
+			v := X.At(i, j)
+			if math.IsNaN(v) {
+				continue
+			}
+			lo = math.Min(lo, v)
+			hi = math.Max(hi, v)
+		}
+		if hi < lo {
+			ranges[j] = math.NaN() // No available values
+			continue
+		}
+		ranges[j] = hi - lo
+	}
+	return ranges
+}
+
+// Name returns the step name, "scale" for unit variance scaling, "weight"
+// for weights only and the method name otherwise.
+func (s *Scaling) Name() string {
+	switch s.Method {
+	case "", ScalingUV:
+		return StepScale
+	case ScalingNone:
+		return StepWeight
+	}
+	return s.Method
+}
+
+// Clone returns a copy of the step. The clone does not keep the setting of
+// Pipeline.SettleDropped.
+func (s *Scaling) Clone() Step {
+	clone := *s
+	clone.Factors = slices.Clone(s.Factors)
+	clone.Weights = slices.Clone(s.Weights)
+	clone.Dropped = slices.Clone(s.Dropped)
+	clone.settled = false
+	return &clone
+}
+
+// Fit calculates the scaling factors of X, and applies the Constant policy
+// to columns with zero or near-zero variance.
+func (s *Scaling) Fit(X *mat.Dense) error {
+	tol := s.Tolerance
+	if tol <= 0 {
+		tol = DefaultConstantTolerance
+	}
+	_, cols := X.Dims()
+	if s.Weights != nil && len(s.Weights) != cols {
+		return fmt.Errorf("data has %d variables, but %d weights are given", cols, len(s.Weights))
+	}
+	for j, w := range s.Weights {
+		if !(w > 0) || math.IsInf(w, 0) { // Also NaN
+			return fmt.Errorf("invalid weight %v of column %d, weights must be positive", w, j+1)
+		}
+	}
+
+	var constant []int
+	if s.Method != ScalingNone { // Constant columns are only a problem when scaling
+		constant = ConstantColumns(X, tol)
+	}
+	settled := s.Dropped
+	s.Dropped = nil
+
+	switch s.Constant {
+	case "", ConstantError:
+		if len(constant) > 0 {
+			return &ConstantColumnsError{Columns: constant}
+		}
+	case ConstantDrop:
+		if !s.settled {
+			s.Dropped = constant
+			break
+		}
+		// Drop the settled columns, and leave other constant columns unscaled
+		s.Dropped = settled
+		for _, j := range settled {
+			if !slices.Contains(constant, j) {
+				constant = append(constant, j)
+			}
+		}
+		slices.Sort(constant)
+	case ConstantKeep:
+	default:
+		return fmt.Errorf("unknown policy for constant columns %q", s.Constant)
+	}
+
+	// Constant columns are excluded, as VAST and level scaling fail on
+	// columns with zero mean
+	Xfit := X
+	if len(constant) > 0 {
+		Xfit = removeColumns(X, constant)
+	}
+	fitted, err := ScalingFactorsDDOF(Xfit, s.Method, s.DDOF)
+	if err != nil {
+		return err
+	}
+
+	s.Factors = make([]float64, cols)
+	k := 0
+	for j := range s.Factors {
+		if slices.Contains(constant, j) {
+			s.Factors[j] = 1 // Constant columns are left unscaled
+			continue
+		}
+		s.Factors[j] = fitted[k]
+		k++
+	}
+	return nil
+}
+
+// ConstantColumnsError is returned by Scaling.Fit when X has columns with zero
+// or near-zero variance and the policy is ConstantError.
+type ConstantColumnsError struct {
+	Columns []int // Indices of the constant columns
+}
+
+// Error lists the constant columns, counting from 1.
+func (e *ConstantColumnsError) Error() string {
+	cols := make([]string, len(e.Columns))
+	for i, j := range e.Columns {
+		cols[i] = strconv.Itoa(j + 1)
+	}
+	return fmt.Sprintf("column(s) %s have zero or near-zero variance and cannot be scaled", strings.Join(cols, ", "))
+}
+
+// Apply divides each column of X by the fitted scaling factor, multiplies it
+// by the weight, and removes the dropped columns.
+func (s *Scaling) Apply(X *mat.Dense) (*mat.Dense, error) {
+	r, cols := X.Dims()
+	if cols != len(s.Factors) {
+		return nil, fmt.Errorf("data has %d variables, scaling was fitted to %d", cols, len(s.Factors))
+	}
+	scaledX := mat.NewDense(r, cols, nil)
+	for i := 0; i < r; i++ {
+		for j := 0; j < cols; j++ {
+			scaledX.Set(i, j, X.At(i, j)*s.weight(j)/s.Factors[j])
+		}
+	}
+	if len(s.Dropped) > 0 {
+		return removeColumns(scaledX, s.Dropped), nil
+	}
+	return scaledX, nil
+}
+
+// Invert multiplies each column of scaled data by the fitted scaling factor
+// and divides it by the weight, restoring the original scale. Dropped columns are restored as missing (NaN)
+// values.
+func (s *Scaling) Invert(X *mat.Dense) (*mat.Dense, error) {
+	r, cols := X.Dims()
+	if cols != len(s.Factors)-len(s.Dropped) {
+		return nil, fmt.Errorf("data has %d variables, scaling was fitted to %d", cols, len(s.Factors)-len(s.Dropped))
+	}
+	origX := mat.NewDense(r, len(s.Factors), nil)
+	k := 0
+	for j := range s.Factors {
+		if slices.Contains(s.Dropped, j) {
+			for i := 0; i < r; i++ {
+				origX.Set(i, j, math.NaN())
+			}
+			continue
+		}
+		for i := 0; i < r; i++ {
+			origX.Set(i, j, X.At(i, k)*s.Factors[j]/s.weight(j))
+		}
+		k++
+	}
+	return origX, nil
+}
+
+// weight returns the weight of column j, 1 if no weights are given.
+func (s *Scaling) weight(j int) float64 {
+	if len(s.Weights) == 0 {
+		return 1
+	}
+	return s.Weights[j]
+}
+
+// DroppedColumns returns the indices of the columns removed by Apply.
+func (s *Scaling) DroppedColumns() []int { return s.Dropped }
+
+// removeColumns returns a copy of X without the given columns.
+func removeColumns(X *mat.Dense, drop []int) *mat.Dense {
+	r, c := X.Dims()
+	kept := mat.NewDense(r, c-len(drop), nil)
+	k := 0
+	for j := 0; j < c; j++ {
+		if slices.Contains(drop, j) {
+			continue
+		}
+		for i := 0; i < r; i++ {
+			kept.Set(i, k, X.At(i, j))
+		}
+		k++
+	}
+	return kept
+}
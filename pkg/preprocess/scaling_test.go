@@ -0,0 +1,214 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the scaling methods.
+package preprocess
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// TestScalingFactors checks the factors of each scaling method for a single column.
+func TestScalingFactors(t *testing.T) {
+	X := mat.NewDense(4, 1, []float64{2, 4, 4, 6}) // mean 4, std sqrt(2), range 4
+
+	testCases := []struct {
+		method string
+		want   float64
+	}{
+		{ScalingUV, math.Sqrt(2)},
+		{ScalingPareto, math.Pow(2, 0.25)},
+		{ScalingRange, 4},
+		{ScalingVast, 2.0 / 4},
+		{ScalingLevel, 4},
+	}
+	for _, tc := range testCases {
+		got, err := ScalingFactors(X, tc.method)
+		if err != nil {
+			t.Errorf("ScalingFactors(%s) error = %v", tc.method, err)
+			continue
+		}
+		if math.Abs(got[0]-tc.want) > 1e-12 {
+			t.Errorf("ScalingFactors(%s) = %v, want %v", tc.method, got[0], tc.want)
+		}
+	}
+
+	centered, _ := MeanCenter(X)
+	if _, err := ScalingFactors(centered, ScalingLevel); err == nil {
+		t.Errorf("ScalingFactors() expected an error for level scaling of centered data")
+	}
+	if _, err := ScalingFactors(X, "unknown"); err == nil {
+		t.Errorf("ScalingFactors() expected an error for an unknown method")
+	}
+
+	for method, want := range map[string]string{ScalingNone: "", ScalingUV: StepScale, ScalingVast: ScalingVast} {
+		if got, err := ScalingStep(method); err != nil || got != want {
+			t.Errorf("ScalingStep(%s) = %q, %v, want %q", method, got, err, want)
+		}
+	}
+	if _, err := ScalingStep("unknown"); err == nil {
+		t.Errorf("ScalingStep() expected an error for an unknown method")
+	}
+}
+
+// TestScalingInvert checks that fitted scaling can be reapplied and inverted.
+func TestScalingInvert(t *testing.T) {
+	X := getTestData("raw")
+	for _, method := range []string{ScalingUV, ScalingPareto, ScalingRange, ScalingVast, ScalingLevel} {
+		s := &Scaling{Method: method}
+		if err := s.Fit(X); err != nil {
+			t.Fatalf("Fit(%s) error = %v", method, err)
+		}
+		scaled, err := s.Apply(X)
+		if err != nil {
+			t.Fatalf("Apply(%s) error = %v", method, err)
+		}
+		restored, err := s.Invert(scaled)
+		if err != nil {
+			t.Fatalf("Invert(%s) error = %v", method, err)
+		}
+		if !almostEqual(restored, X, 1e-9) {
+			t.Errorf("Invert(%s) did not restore the data, got: %v, want: %v.", method, restored, X)
+		}
+	}
+}
+
+// TestConstantColumns checks the detection of zero and near-zero variance columns.
+func TestConstantColumns(t *testing.T) {
+	X := mat.NewDense(4, 4, []float64{
+		1, 5, 1000, 0,
+		2, 5, 1000 + 1e-9, 0,
+		3, 5, 1000, 0,
+		math.NaN(), 5, 1000, 0,
+	})
+	got := ConstantColumns(X, DefaultConstantTolerance)
+	want := []int{1, 2, 3}
+	if len(got) != len(want) {
+		t.Fatalf("ConstantColumns() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ConstantColumns() = %v, want %v", got, want)
+		}
+	}
+}
+
+// TestScalingConstantPolicies checks the error, drop and keep policies for constant columns.
+func TestScalingConstantPolicies(t *testing.T) {
+	X := mat.NewDense(3, 3, []float64{
+		1, 5, 2,
+		2, 5, 4,
+		3, 5, 9,
+	})
+
+	var constantErr *ConstantColumnsError
+	err := (&Scaling{Method: ScalingUV}).Fit(X)
+	if !errors.As(err, &constantErr) || len(constantErr.Columns) != 1 || constantErr.Columns[0] != 1 {
+		t.Errorf("Fit() error = %v, want a ConstantColumnsError for column 2", err)
+	}
+
+	drop := &Scaling{Method: ScalingLevel, Constant: ConstantDrop}
+	if err := drop.Fit(X); err != nil {
+		t.Fatalf("Fit() with drop error = %v", err)
+	}
+	scaled, err := drop.Apply(X)
+	if err != nil {
+		t.Fatalf("Apply() with drop error = %v", err)
+	}
+	if _, c := scaled.Dims(); c != 2 || scaled.At(0, 1) != 2.0/5 {
+		t.Errorf("Apply() with drop = %v, want columns 1 and 3 divided by their means", mat.Formatted(scaled))
+	}
+	restored, err := drop.Invert(scaled)
+	if err != nil {
+		t.Fatalf("Invert() with drop error = %v", err)
+	}
+	if !math.IsNaN(restored.At(0, 1)) || math.Abs(restored.At(2, 2)-9) > 1e-12 {
+		t.Errorf("Invert() with drop = %v, want NaN in the dropped column", mat.Formatted(restored))
+	}
+
+	keep := &Scaling{Method: ScalingUV, Constant: ConstantKeep}
+	if err := keep.Fit(X); err != nil {
+		t.Fatalf("Fit() with keep error = %v", err)
+	}
+	scaled, err = keep.Apply(X)
+	if err != nil {
+		t.Fatalf("Apply() with keep error = %v", err)
+	}
+	if _, c := scaled.Dims(); c != 3 || scaled.At(0, 1) != 5 {
+		t.Errorf("Apply() with keep = %v, want the constant column unscaled", mat.Formatted(scaled))
+	}
+}
+
+// TestScalingDDOFAndWeights checks the sample standard deviation and that
+// weights are applied after scaling and kept by Fit.
+func TestScalingDDOFAndWeights(t *testing.T) {
+	X := mat.NewDense(4, 2, []float64{
+		2, 1,
+		4, 2,
+		4, 3,
+		6, 4,
+	})
+	s := &Scaling{Method: ScalingUV, DDOF: 1, Weights: []float64{2, 0.5}}
+	if err := s.Fit(X); err != nil {
+		t.Fatalf("Fit() error = %v", err)
+	}
+	if want := math.Sqrt(8.0 / 3); math.Abs(s.Factors[0]-want) > 1e-12 {
+		t.Errorf("Fit() factor = %v, want the sample standard deviation %v", s.Factors[0], want)
+	}
+	scaled, err := s.Apply(X)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if want := 2 * 2 / math.Sqrt(8.0/3); math.Abs(scaled.At(0, 0)-want) > 1e-12 {
+		t.Errorf("Apply() = %v, want %v", scaled.At(0, 0), want)
+	}
+	restored, err := s.Invert(scaled)
+	if err != nil {
+		t.Fatalf("Invert() error = %v", err)
+	}
+	if !almostEqual(restored, X, 1e-12) {
+		t.Errorf("Invert() did not restore the data, got: %v", mat.Formatted(restored))
+	}
+
+	weight := &Scaling{Method: ScalingNone, Weights: []float64{1, 2, 3}}
+	if err := weight.Fit(X); err == nil {
+		t.Errorf("Fit() expected an error for the wrong number of weights")
+	}
+	for _, w := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if err := (&Scaling{Method: ScalingNone, Weights: []float64{1, w}}).Fit(X); err == nil {
+			t.Errorf("Fit() expected an error for the weight %v", w)
+		}
+	}
+	if weight.Name() != StepWeight {
+		t.Errorf("Name() = %q, want %q", weight.Name(), StepWeight)
+	}
+}
+
+// TestScalingUnmarshalLegacy checks that the scaling factors of models saved
+// with the key std are read.
+func TestScalingUnmarshalLegacy(t *testing.T) {
+	var p Pipeline
+	if err := json.Unmarshal([]byte(`[{"name":"scale","params":{"std":[2,4]}},{"name":"center","params":{"mean":[1,1]}}]`), &p); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	s := p.Steps[0].(*Scaling)
+	if s.Method != ScalingUV || len(s.Factors) != 2 || s.Factors[0] != 2 || s.Factors[1] != 4 {
+		t.Errorf("json.Unmarshal() scaling = %+v, want uv with factors [2 4]", s)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains row-wise scatter corrections for spectra:
+// Standard Normal Variate (SNV), Multiplicative Scatter Correction (MSC) and
+// Extended Multiplicative Scatter Correction (EMSC).
+package preprocess
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Scatter correction methods.
+const (
+	ScatterSNV  = "snv"
+	ScatterMSC  = "msc"
+	ScatterEMSC = "emsc"
+)
+
+// ScatterCorrection holds the fitted parameters of a scatter correction, so
+// that the same correction can be applied to new spectra.
+type ScatterCorrection struct {
+	Method    string    `json:"method"`              // One of the Scatter constants
+	Reference []float64 `json:"reference,omitempty"` // Reference spectrum (MSC and EMSC)
+	Order     int       `json:"order,omitempty"`     // Polynomial order (EMSC)
+}
+
+// FitScatterCorrection fits a scatter correction to the spectra (rows) of X.
+// MSC and EMSC use the mean spectrum as reference, and EMSC includes
+// polynomial baseline terms up to the given order.
+func FitScatterCorrection(X *mat.Dense, method string, order int) (*ScatterCorrection, error) {
+	switch method {
+	case ScatterSNV:
+		return &ScatterCorrection{Method: method}, nil
+	case ScatterMSC:
+		return &ScatterCorrection{Method: method, Reference: colMean(X)}, nil
+	case ScatterEMSC:
+		if order < 0 {
+			return nil, fmt.Errorf("EMSC polynomial order cannot be negative, got %d", order)
+		}
+		return &ScatterCorrection{Method: method, Reference: colMean(X), Order: order}, nil
+	}
+	return nil, fmt.Errorf("unknown scatter correction %q", method)
+}
+
+// Apply applies the scatter correction to the spectra (rows) of X.
+func (s *ScatterCorrection) Apply(X *mat.Dense) (*mat.Dense, error) {
+	switch s.Method {
+	case ScatterSNV:
+		return SNV(X)
+	case ScatterMSC:
+		return MSCWithReference(X, s.Reference)
+	case ScatterEMSC:
+		return EMSCWithReference(X, s.Reference, s.Order)
+	}
+	return nil, fmt.Errorf("unknown scatter correction %q", s.Method)
+}
+
+// SNV applies the Standard Normal Variate transform, which centers each row
+// of X on its own mean and scales it by its own standard deviation (with n-1
+// in the denominator). Missing (NaN) values are ignored and kept. An error is
+// returned for a row with fewer than two values, or with all values equal.
+func SNV(X *mat.Dense) (*mat.Dense, error) {
+	r, c := X.Dims()
+	snvX := mat.NewDense(r, c, nil)
+	for i := 0; i < r; i++ {
+		var sum, sumSq float64
+		var n int
+		for j := 0; j < c; j++ {
+			if v := X.At(i, j); !math.IsNaN(v) {
+				sum += v
+				n++
+			}
+		}
+		if n < 2 {
+			return nil, fmt.Errorf("row %d has %d values, SNV needs at least 2", i+1, n)
+		}
+		mean := sum / float64(n)
+		for j := 0; j < c; j++ {
+			if v := X.At(i, j); !math.IsNaN(v) {
+				sumSq += (v - mean) * (v - mean)
+			}
+		}
+		std := math.Sqrt(sumSq / float64(n-1))
+		if std == 0 {
+			return nil, fmt.Errorf("row %d is flat, SNV cannot scale it", i+1)
+		}
+		for j := 0; j < c; j++ {
+			snvX.Set(i, j, (X.At(i, j)-mean)/std)
+		}
+	}
+	return snvX, nil
+}
+
+// MSC applies Multiplicative Scatter Correction using the mean spectrum of X
+// as reference. It returns the corrected matrix and the reference spectrum,
+// or an error if a row cannot be fitted to the reference.
+func MSC(X *mat.Dense) (*mat.Dense, []float64, error) {
+	reference := colMean(X)
+	correctedX, err := MSCWithReference(X, reference)
+	return correctedX, reference, err
+}
+
+// MSCWithReference applies Multiplicative Scatter Correction using a given
+// reference spectrum. Each row x is fitted as x = a + b*reference by least
+// squares, and corrected as (x - a)/b.
+func MSCWithReference(X *mat.Dense, reference []float64) (*mat.Dense, error) {
+	return EMSCWithReference(X, reference, -1)
+}
+
+// EMSC applies Extended Multiplicative Scatter Correction with polynomial
+// terms up to the given order, using the mean spectrum of X as reference.
+// It returns the corrected matrix and the reference spectrum.
+func EMSC(X *mat.Dense, order int) (*mat.Dense, []float64, error) {
+	reference := colMean(X)
+	correctedX, err := EMSCWithReference(X, reference, order)
+	return correctedX, reference, err
+}
+
+// EMSCWithReference applies Extended Multiplicative Scatter Correction using
+// a given reference spectrum. Each row x is fitted by least squares as
+//
+//	x = a + b*reference + c1*v + c2*v² + ... + ck*v^k
+//
+// where v runs linearly from -1 to 1 over the variables and k is the
+// polynomial order. The row is corrected as (x - a - c1*v - ... - ck*v^k)/b.
+// With an order below 1 this is ordinary MSC. Missing (NaN) values are left
+// out of the fit and kept in the result.
+func EMSCWithReference(X *mat.Dense, reference []float64, order int) (*mat.Dense, error) {
+	r, c := X.Dims()
+	if len(reference) != c {
+		return nil, fmt.Errorf("reference spectrum has %d values, data has %d variables", len(reference), c)
+	}
+	order = max(order, 0)
+
+	// Design matrix columns: constant, reference, polynomial terms
+	terms := 2 + order
+	design := mat.NewDense(c, terms, nil)
+	for j := 0; j < c; j++ {
+		v := 0.0
+		if c > 1 {
+			v = 2*float64(j)/float64(c-1) - 1
+		}
+		design.Set(j, 0, 1)
+		design.Set(j, 1, reference[j])
+		for k := 1; k <= order; k++ {
+			design.Set(j, 1+k, math.Pow(v, float64(k)))
+		}
+	}
+
+	correctedX := mat.NewDense(r, c, nil)
+	for i := 0; i < r; i++ {
+		var available []int
+		for j := 0; j < c; j++ {
+			if !math.IsNaN(X.At(i, j)) {
+				available = append(available, j)
+			}
+		}
+		if len(available) < terms {
+			return nil, fmt.Errorf("row %d has %d values, too few to fit %d scatter terms", i+1, len(available), terms)
+		}
+
+		A := mat.NewDense(len(available), terms, nil)
+		x := mat.NewVecDense(len(available), nil)
+		for k, j := range available {
+			A.SetRow(k, design.RawRowView(j))
+			x.SetVec(k, X.At(i, j))
+		}
+		var coef mat.VecDense
+		if err := coef.SolveVec(A, x); err != nil {
+			return nil, fmt.Errorf("error fitting scatter terms for row %d: %v", i+1, err)
+		}
+		b := coef.AtVec(1)
+		if b == 0 {
+			return nil, fmt.Errorf("row %d has no multiplicative relation to the reference", i+1)
+		}
+
+		for j := 0; j < c; j++ {
+			baseline := coef.AtVec(0)
+			for k := 1; k <= order; k++ {
+				baseline += coef.AtVec(1+k) * design.At(j, 1+k)
+			}
+			correctedX.Set(i, j, (X.At(i, j)-baseline)/b)
+		}
+	}
+	return correctedX, nil
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the scatter corrections.
+package preprocess
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// testSpectrum is a reference spectrum used in the scatter correction tests.
+var testSpectrum = []float64{0.1, 0.3, 0.8, 1.5, 1.1, 0.6, 0.9, 0.4, 0.2, 0.1}
+
+// TestSNV checks that each row gets zero mean and unit standard deviation.
+func TestSNV(t *testing.T) {
+	X := getTestData("raw")
+	snvX, err := SNV(X)
+	if err != nil {
+		t.Fatalf("SNV returned an error: %v", err)
+	}
+
+	r, c := snvX.Dims()
+	for i := 0; i < r; i++ {
+		row := mat.Row(nil, i, snvX)
+		var sum, sumSq float64
+		for _, v := range row {
+			sum += v
+			sumSq += v * v
+		}
+		if math.Abs(sum) > 1e-9 || math.Abs(sumSq/float64(c-1)-1) > 1e-9 {
+			t.Errorf("SNV row %d should have mean 0 and variance 1, got: %v", i, row)
+		}
+	}
+}
+
+// TestSNVErrors checks that flat rows and rows with a single value are
+// rejected.
+func TestSNVErrors(t *testing.T) {
+	for name, X := range map[string]*mat.Dense{
+		"flat":         mat.NewDense(2, 3, []float64{1, 2, 4, 5, 5, 5}),
+		"single value": mat.NewDense(2, 3, []float64{1, 2, 4, math.NaN(), 5, math.NaN()}),
+		"one variable": mat.NewDense(2, 1, []float64{1, 2}),
+	} {
+		if _, err := SNV(X); err == nil {
+			t.Errorf("SNV() with a %s row expected an error", name)
+		}
+	}
+}
+
+// TestMSC checks that offset and multiplicative effects relative to the
+// reference are removed.
+func TestMSC(t *testing.T) {
+	c := len(testSpectrum)
+	X := mat.NewDense(2, c, nil)
+	for j, v := range testSpectrum {
+		X.Set(0, j, 0.2+1.5*v)
+		X.Set(1, j, -0.1+0.7*v)
+	}
+
+	corrected, err := MSCWithReference(X, testSpectrum)
+	if err != nil {
+		t.Fatalf("MSCWithReference() error = %v", err)
+	}
+	expected := mat.NewDense(2, c, append(append([]float64{}, testSpectrum...), testSpectrum...))
+	if !almostEqual(corrected, expected, 1e-9) {
+		t.Errorf("MSC result was incorrect, got: %v, want: %v.", corrected, expected)
+	}
+
+	if _, err := MSCWithReference(X, testSpectrum[:5]); err == nil {
+		t.Errorf("MSCWithReference() expected an error for a reference of the wrong length")
+	}
+
+	// A row with a single value cannot be fitted to the mean spectrum
+	for j := 1; j < c; j++ {
+		X.Set(1, j, math.NaN())
+	}
+	if corrected, _, err := MSC(X); err == nil {
+		t.Errorf("MSC() = %v, want an error for a row with too few values", corrected)
+	}
+}
+
+// TestEMSC checks that a quadratic baseline is removed together with offset
+// and multiplicative effects, and that the fitted correction can be reapplied.
+func TestEMSC(t *testing.T) {
+	c := len(testSpectrum)
+	X := mat.NewDense(1, c, nil)
+	for j, v := range testSpectrum {
+		x := 2*float64(j)/float64(c-1) - 1
+		X.Set(0, j, 0.3+1.2*v+0.05*x-0.02*x*x)
+	}
+
+	scatter := &ScatterCorrection{Method: ScatterEMSC, Reference: testSpectrum, Order: 2}
+	corrected, err := scatter.Apply(X)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	expected := mat.NewDense(1, c, testSpectrum)
+	if !almostEqual(corrected, expected, 1e-9) {
+		t.Errorf("EMSC result was incorrect, got: %v, want: %v.", corrected, expected)
+	}
+
+	if _, err := FitScatterCorrection(X, "unknown", 0); err == nil {
+		t.Errorf("FitScatterCorrection() expected an error for an unknown method")
+	}
+}
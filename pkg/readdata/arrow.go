@@ -0,0 +1,562 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains a reader for Arrow IPC files and streams
+// (.arrow, .arrows, .feather).
+package readdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// arrowMagic starts and ends Arrow IPC files, but not streams.
+var arrowMagic = []byte("ARROW1")
+
+// Arrow message header and column types, from the Arrow flatbuffer schemas.
+const (
+	arrowSchema          = 1
+	arrowDictionaryBatch = 2
+	arrowRecordBatch     = 3
+
+	arrowTypeNull          = 1
+	arrowTypeInt           = 2
+	arrowTypeFloatingPoint = 3
+	arrowTypeUtf8          = 5
+	arrowTypeBool          = 6
+	arrowTypeLargeUtf8     = 20
+)
+
+// arrowField is a column of an Arrow schema.
+type arrowField struct {
+	name      string
+	typ       byte
+	bitWidth  int  // Int
+	signed    bool // Int
+	precision int  // FloatingPoint: 1 single, 2 double
+}
+
+// ProcessArrow reads data from an Arrow IPC file or stream. Numeric and
+// boolean columns become variables, with NaN for nulls. The columns named in
+// metadata are kept as Metadata, a string column first holds the object
+// names, and other string columns are also kept as Metadata. The data is also returned as a matrix, which shares memory with
+// the rows of the data. Dictionary encoded and compressed data are not
+// supported.
+func ProcessArrow(filename string, metadata []string) (ProcessedData, *mat.Dense, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return ProcessedData{}, nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return ProcessedData{}, nil, err
+	}
+	return ProcessArrowFrom(file, info.Size(), metadata)
+}
+
+// ProcessArrowFrom reads data from an Arrow IPC file or stream of the given
+// size in r, like ProcessArrow. The messages are read one at a time, and
+// the values of each record batch are written straight into the matrix.
+func ProcessArrowFrom(r io.ReaderAt, size int64, metadata []string) (ProcessedData, *mat.Dense, error) {
+	head := make([]byte, 8)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return ProcessedData{}, nil, err
+	}
+	head = head[:n]
+
+	pos, end := int64(0), size
+	if bytes.HasPrefix(head, arrowMagic) {
+		// File format: magic, padding, stream, footer, footer size, magic
+		pos = 8
+		tail := make([]byte, 10)
+		if size >= 18 {
+			if _, err := r.ReadAt(tail, size-10); err != nil {
+				return ProcessedData{}, nil, err
+			}
+			if bytes.HasSuffix(tail, arrowMagic) {
+				footer := int64(int32(binary.LittleEndian.Uint32(tail)))
+				if footer >= 0 && footer <= size-18 {
+					end = size - 10 - footer
+				}
+			}
+		}
+	}
+
+	// read reads the next n bytes into buf, which is reused between messages
+	var prefix, metadataBuf, bodyBuf []byte
+	read := func(buf *[]byte, n int64) ([]byte, error) {
+		if n < 0 || n > end-pos {
+			return nil, fmt.Errorf("Arrow message at byte %d is truncated", pos)
+		}
+		if int64(cap(*buf)) < n {
+			*buf = make([]byte, n)
+		}
+		b := (*buf)[:n]
+		if _, err := r.ReadAt(b, pos); err != nil {
+			return nil, err
+		}
+		pos += n
+		return b, nil
+	}
+
+	var fields []arrowField
+	var builder *columnBuilder
+	for pos+4 <= end {
+		b, err := read(&prefix, 4)
+		if err != nil {
+			return ProcessedData{}, nil, err
+		}
+		length := int64(int32(binary.LittleEndian.Uint32(b)))
+		if length == -1 { // Continuation marker
+			if pos+4 > end {
+				break
+			}
+			if b, err = read(&prefix, 4); err != nil {
+				return ProcessedData{}, nil, err
+			}
+			length = int64(int32(binary.LittleEndian.Uint32(b)))
+		}
+		if length == 0 {
+			break // End of stream
+		}
+		message, err := read(&metadataBuf, length)
+		if err != nil {
+			return ProcessedData{}, nil, err
+		}
+		headerType, header, bodyLength, err := parseArrowMessage(message)
+		if err != nil {
+			return ProcessedData{}, nil, err
+		}
+		body, err := read(&bodyBuf, bodyLength)
+		if err != nil {
+			return ProcessedData{}, nil, err
+		}
+
+		switch headerType {
+		case arrowSchema:
+			if fields, err = parseArrowSchema(header); err != nil {
+				return ProcessedData{}, nil, err
+			}
+			columns := make([]dataColumn, len(fields))
+			for i, f := range fields {
+				columns[i] = dataColumn{name: f.name, text: f.typ == arrowTypeUtf8 || f.typ == arrowTypeLargeUtf8}
+			}
+			builder = newColumnBuilder(columns)
+		case arrowDictionaryBatch:
+			return ProcessedData{}, nil, fmt.Errorf("dictionary encoded Arrow data is not supported")
+		case arrowRecordBatch:
+			if fields == nil {
+				return ProcessedData{}, nil, fmt.Errorf("Arrow record batch before the schema")
+			}
+			if err := readArrowRecordBatch(header, body, fields, builder); err != nil {
+				return ProcessedData{}, nil, err
+			}
+		}
+	}
+	if fields == nil {
+		return ProcessedData{}, nil, fmt.Errorf("no Arrow schema found")
+	}
+	return builder.data(metadata)
+}
+
+// parseArrowMessage returns the header type, header table and body length
+// of a Message flatbuffer.
+func parseArrowMessage(metadata []byte) (headerType byte, header fbTable, bodyLength int64, err error) {
+	defer recoverFlatbuffer(&err)
+	msg := fbRoot(metadata)
+	headerType = msg.uint8(1, 0)
+	header, ok := msg.table(2)
+	if !ok {
+		return 0, fbTable{}, 0, fmt.Errorf("Arrow message has no header")
+	}
+	return headerType, header, msg.int64(3, 0), nil
+}
+
+// parseArrowSchema returns the fields of a Schema table.
+func parseArrowSchema(schema fbTable) (fields []arrowField, err error) {
+	defer recoverFlatbuffer(&err)
+	hasData := false
+	if schema.int16(0, 0) != 0 {
+		return nil, fmt.Errorf("big endian Arrow data is not supported")
+	}
+	n := schema.vectorLen(1)
+	for k := 0; k < n; k++ {
+		field := schema.vectorTable(1, k)
+		f := arrowField{name: string(field.bytes(0)), typ: field.uint8(2, 0)}
+		if _, ok := field.table(4); ok {
+			return nil, fmt.Errorf("dictionary encoded column %s is not supported", f.name)
+		}
+		typ, _ := field.table(3)
+		switch f.typ {
+		case arrowTypeInt:
+			f.bitWidth = int(typ.int32(0, 0))
+			f.signed = typ.bool(1, false)
+			switch f.bitWidth {
+			case 8, 16, 32, 64:
+			default:
+				return nil, fmt.Errorf("column %s has integers of %d bits, which are not supported", f.name, f.bitWidth)
+			}
+		case arrowTypeFloatingPoint:
+			f.precision = int(typ.int16(0, 0))
+			if f.precision == 0 {
+				return nil, fmt.Errorf("column %s has half precision floats, which are not supported", f.name)
+			}
+		case arrowTypeNull, arrowTypeBool, arrowTypeUtf8, arrowTypeLargeUtf8:
+		default:
+			return nil, fmt.Errorf("column %s has Arrow type %d, which is not supported", f.name, f.typ)
+		}
+		fields = append(fields, f)
+		if f.typ != arrowTypeNull {
+			hasData = true
+		}
+	}
+	if !hasData {
+		return nil, fmt.Errorf("Arrow schema has no columns with data")
+	}
+	return fields, nil
+}
+
+// width returns the number of bytes of a value of a numeric field, and 0
+// for booleans, which are bits.
+func (f arrowField) width() int {
+	switch {
+	case f.typ == arrowTypeInt:
+		return f.bitWidth / 8
+	case f.typ == arrowTypeFloatingPoint && f.precision == 1:
+		return 4
+	case f.typ == arrowTypeFloatingPoint:
+		return 8
+	}
+	return 0
+}
+
+// arrowBuffers are the buffers of a column of a record batch.
+type arrowBuffers struct {
+	validity []byte // Empty if there are no nulls
+	values   []byte // Values, or offsets of strings
+	chars    []byte // Characters of strings
+}
+
+// readArrowRecordBatch adds the rows of a RecordBatch to the builder. The
+// buffers of every column are checked against the body before the rows are
+// allocated.
+func readArrowRecordBatch(batch fbTable, body []byte, fields []arrowField, builder *columnBuilder) (err error) {
+	defer recoverFlatbuffer(&err)
+	if _, ok := batch.table(3); ok {
+		return fmt.Errorf("compressed Arrow data is not supported")
+	}
+	length := batch.int64(0, 0)
+	nodes := batch.structs(1, 16)
+	buffers := batch.structs(2, 16)
+	if len(nodes) != len(fields) {
+		return fmt.Errorf("Arrow record batch has %d columns, the schema has %d", len(nodes), len(fields))
+	}
+	if length < 0 || length > int64(8*len(body)) { // Every batch has a column with data
+		return fmt.Errorf("Arrow record batch has an invalid length %d", length)
+	}
+	n := int(length)
+
+	le := binary.LittleEndian
+	next := 0
+	buffer := func() ([]byte, error) {
+		if next >= len(buffers) {
+			return nil, fmt.Errorf("Arrow record batch has too few buffers")
+		}
+		offset, size := le.Uint64(buffers[next]), le.Uint64(buffers[next][8:])
+		next++
+		if offset > uint64(len(body)) || size > uint64(len(body))-offset {
+			return nil, fmt.Errorf("Arrow buffer is outside the message body")
+		}
+		return body[offset : offset+size], nil
+	}
+
+	columns := make([]arrowBuffers, len(fields))
+	for k, f := range fields {
+		count, nullCount := le.Uint64(nodes[k]), le.Uint64(nodes[k][8:])
+		if count != uint64(length) {
+			return fmt.Errorf("column %s has %d values, the record batch has %d", f.name, count, length)
+		}
+		if f.typ == arrowTypeNull {
+			continue
+		}
+		c := &columns[k]
+		if c.validity, err = buffer(); err != nil {
+			return err
+		}
+		if nullCount == 0 {
+			c.validity = nil
+		} else if len(c.validity) == 0 {
+			return fmt.Errorf("column %s has %d nulls but a missing validity bitmap", f.name, nullCount)
+		} else if n > 8*len(c.validity) {
+			return fmt.Errorf("column %s has a short validity bitmap", f.name)
+		}
+		if c.values, err = buffer(); err != nil {
+			return err
+		}
+
+		switch {
+		case f.typ == arrowTypeUtf8 || f.typ == arrowTypeLargeUtf8:
+			if c.chars, err = buffer(); err != nil {
+				return err
+			}
+			offsetSize := 4
+			if f.typ == arrowTypeLargeUtf8 {
+				offsetSize = 8
+			}
+			if n >= len(c.values)/offsetSize { // n+1 offsets
+				return fmt.Errorf("column %s has too few offsets", f.name)
+			}
+		case f.typ == arrowTypeBool:
+			if n > 8*len(c.values) {
+				return fmt.Errorf("column %s has too few values", f.name)
+			}
+		default:
+			if n > len(c.values)/f.width() {
+				return fmt.Errorf("column %s has too few values", f.name)
+			}
+		}
+	}
+
+	builder.addBatch(n)
+	for k, f := range fields {
+		c := columns[k]
+		valid := func(i int) bool {
+			return len(c.validity) == 0 || c.validity[i/8]&(1<<(i%8)) != 0
+		}
+		switch {
+		case f.typ == arrowTypeNull:
+			for i := 0; i < n; i++ {
+				builder.set(i, k, math.NaN())
+			}
+		case f.typ == arrowTypeUtf8 || f.typ == arrowTypeLargeUtf8:
+			offset := func(i int) int {
+				if f.typ == arrowTypeLargeUtf8 {
+					return int(le.Uint64(c.values[8*i:]))
+				}
+				return int(int32(le.Uint32(c.values[4*i:])))
+			}
+			for i := 0; i < n; i++ {
+				s := ""
+				if valid(i) {
+					from, to := offset(i), offset(i+1)
+					if from < 0 || from > to || to > len(c.chars) {
+						return fmt.Errorf("column %s has an invalid string offset", f.name)
+					}
+					s = string(c.chars[from:to])
+				}
+				builder.addText(k, s)
+			}
+		default:
+			width := f.width()
+			for i := 0; i < n; i++ {
+				v := math.NaN()
+				switch {
+				case !valid(i):
+				case f.typ == arrowTypeBool:
+					v = float64((c.values[i/8] >> (i % 8)) & 1)
+				case f.typ == arrowTypeFloatingPoint && width == 4:
+					v = float64(math.Float32frombits(le.Uint32(c.values[4*i:])))
+				case f.typ == arrowTypeFloatingPoint:
+					v = math.Float64frombits(le.Uint64(c.values[8*i:]))
+				default:
+					v = arrowInt(c.values[i*width:], width, f.signed)
+				}
+				builder.set(i, k, v)
+			}
+		}
+	}
+	return nil
+}
+
+// arrowInt converts a little endian integer of 1, 2, 4 or 8 bytes.
+func arrowInt(b []byte, width int, signed bool) float64 {
+	le := binary.LittleEndian
+	switch {
+	case width == 1 && signed:
+		return float64(int8(b[0]))
+	case width == 1:
+		return float64(b[0])
+	case width == 2 && signed:
+		return float64(int16(le.Uint16(b)))
+	case width == 2:
+		return float64(le.Uint16(b))
+	case width == 4 && signed:
+		return float64(int32(le.Uint32(b)))
+	case width == 4:
+		return float64(le.Uint32(b))
+	case signed:
+		return float64(int64(le.Uint64(b)))
+	}
+	return float64(le.Uint64(b))
+}
+
+// fbTable is a table in a flatbuffer. Accessors panic with a flatbufferError
+// on data outside the buffer; recoverFlatbuffer turns it into an error.
+type fbTable struct {
+	buf []byte
+	pos int
+}
+
+// flatbufferError is the panic value of out of bounds flatbuffer access.
+type flatbufferError struct{}
+
+// recoverFlatbuffer recovers from a flatbufferError and sets err.
+func recoverFlatbuffer(err *error) {
+	if r := recover(); r != nil {
+		if _, ok := r.(flatbufferError); !ok {
+			panic(r)
+		}
+		*err = fmt.Errorf("corrupt Arrow metadata")
+	}
+}
+
+// fbRoot returns the root table of a flatbuffer.
+func fbRoot(buf []byte) fbTable {
+	t := fbTable{buf: buf}
+	return fbTable{buf: buf, pos: t.uoffset(0)}
+}
+
+// check panics if n bytes at pos are outside the buffer.
+func (t fbTable) check(pos, n int) {
+	if pos < 0 || pos+n > len(t.buf) {
+		panic(flatbufferError{})
+	}
+}
+
+// uoffset returns the position referred to by the offset at pos.
+func (t fbTable) uoffset(pos int) int {
+	t.check(pos, 4)
+	return pos + int(binary.LittleEndian.Uint32(t.buf[pos:]))
+}
+
+// field returns the position of field i, or 0 if it is absent.
+func (t fbTable) field(i int) int {
+	t.check(t.pos, 4)
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	t.check(vtable, 4)
+	if size := int(binary.LittleEndian.Uint16(t.buf[vtable:])); 4+2*i+2 > size {
+		return 0
+	}
+	t.check(vtable+4+2*i, 2)
+	offset := int(binary.LittleEndian.Uint16(t.buf[vtable+4+2*i:]))
+	if offset == 0 {
+		return 0
+	}
+	return t.pos + offset
+}
+
+// uint8 returns the byte field i, or def if it is absent.
+func (t fbTable) uint8(i int, def byte) byte {
+	if p := t.field(i); p != 0 {
+		t.check(p, 1)
+		return t.buf[p]
+	}
+	return def
+}
+
+// bool returns the boolean field i, or def if it is absent.
+func (t fbTable) bool(i int, def bool) bool {
+	if p := t.field(i); p != 0 {
+		t.check(p, 1)
+		return t.buf[p] != 0
+	}
+	return def
+}
+
+// int16 returns the 16-bit integer field i, or def if it is absent.
+func (t fbTable) int16(i int, def int16) int16 {
+	if p := t.field(i); p != 0 {
+		t.check(p, 2)
+		return int16(binary.LittleEndian.Uint16(t.buf[p:]))
+	}
+	return def
+}
+
+// int32 returns the 32-bit integer field i, or def if it is absent.
+func (t fbTable) int32(i int, def int32) int32 {
+	if p := t.field(i); p != 0 {
+		t.check(p, 4)
+		return int32(binary.LittleEndian.Uint32(t.buf[p:]))
+	}
+	return def
+}
+
+// int64 returns the 64-bit integer field i, or def if it is absent.
+func (t fbTable) int64(i int, def int64) int64 {
+	if p := t.field(i); p != 0 {
+		t.check(p, 8)
+		return int64(binary.LittleEndian.Uint64(t.buf[p:]))
+	}
+	return def
+}
+
+// table returns the table in field i, and false if it is absent.
+func (t fbTable) table(i int) (fbTable, bool) {
+	if p := t.field(i); p != 0 {
+		return fbTable{buf: t.buf, pos: t.uoffset(p)}, true
+	}
+	return fbTable{}, false
+}
+
+// vector returns the position of the first element and the length of the
+// vector in field i, or 0, 0 if it is absent.
+func (t fbTable) vector(i int) (int, int) {
+	p := t.field(i)
+	if p == 0 {
+		return 0, 0
+	}
+	v := t.uoffset(p)
+	t.check(v, 4)
+	return v + 4, int(binary.LittleEndian.Uint32(t.buf[v:]))
+}
+
+// vectorLen returns the length of the vector in field i.
+func (t fbTable) vectorLen(i int) int {
+	_, n := t.vector(i)
+	return n
+}
+
+// vectorTable returns table k of the vector of tables in field i.
+func (t fbTable) vectorTable(i, k int) fbTable {
+	start, _ := t.vector(i)
+	return fbTable{buf: t.buf, pos: t.uoffset(start + 4*k)}
+}
+
+// bytes returns the string or byte vector in field i.
+func (t fbTable) bytes(i int) []byte {
+	start, n := t.vector(i)
+	t.check(start, n)
+	return t.buf[start : start+n]
+}
+
+// structs returns the elements of the vector of structs of the given size in
+// field i.
+func (t fbTable) structs(i, size int) [][]byte {
+	start, n := t.vector(i)
+	t.check(start, n*size)
+	elements := make([][]byte, n)
+	for k := range elements {
+		elements[k] = t.buf[start+k*size : start+(k+1)*size]
+	}
+	return elements
+}
@@ -0,0 +1,294 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the Arrow IPC reader.
+package readdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"slices"
+	"testing"
+)
+
+// fbObject writes a flatbuffer object to the end of buf and returns its
+// position. Objects are written after the objects referring to them, as
+// offsets are unsigned.
+type fbObject func(buf *[]byte) int
+
+// fbTableOf returns a table. Fields are nil (absent), []byte (an inline
+// scalar) or fbObject (an offset to a table, vector or string).
+func fbTableOf(fields ...any) fbObject {
+	return func(buf *[]byte) int {
+		offsets := make([]int, len(fields))
+		size := 4
+		for i, f := range fields {
+			switch f := f.(type) {
+			case []byte:
+				offsets[i], size = size, size+len(f)
+			case fbObject:
+				offsets[i], size = size, size+4
+			}
+		}
+		vtable := binary.LittleEndian.AppendUint16(nil, uint16(4+2*len(fields)))
+		vtable = binary.LittleEndian.AppendUint16(vtable, uint16(size))
+		for _, o := range offsets {
+			vtable = binary.LittleEndian.AppendUint16(vtable, uint16(o))
+		}
+		*buf = append(*buf, vtable...)
+		table := len(*buf)
+		*buf = binary.LittleEndian.AppendUint32(*buf, uint32(len(vtable)))
+		for _, f := range fields {
+			switch f := f.(type) {
+			case []byte:
+				*buf = append(*buf, f...)
+			case fbObject:
+				*buf = append(*buf, 0, 0, 0, 0)
+			}
+		}
+		for i, f := range fields {
+			if child, ok := f.(fbObject); ok {
+				p, c := table+offsets[i], child(buf) // child may reallocate buf
+				binary.LittleEndian.PutUint32((*buf)[p:], uint32(c-p))
+			}
+		}
+		return table
+	}
+}
+
+// fbVectorOf returns a vector of offsets to objects.
+func fbVectorOf(elements ...fbObject) fbObject {
+	return func(buf *[]byte) int {
+		v := len(*buf)
+		*buf = binary.LittleEndian.AppendUint32(*buf, uint32(len(elements)))
+		*buf = append(*buf, make([]byte, 4*len(elements))...)
+		for k, e := range elements {
+			p, c := v+4+4*k, e(buf)
+			binary.LittleEndian.PutUint32((*buf)[p:], uint32(c-p))
+		}
+		return v
+	}
+}
+
+// fbBytes returns a string, or a vector of structs given as their bytes.
+func fbBytes(count int, data []byte) fbObject {
+	return func(buf *[]byte) int {
+		v := len(*buf)
+		*buf = binary.LittleEndian.AppendUint32(*buf, uint32(count))
+		*buf = append(*buf, data...)
+		return v
+	}
+}
+
+// fbString returns a string.
+func fbString(s string) fbObject {
+	return fbBytes(len(s), append([]byte(s), 0))
+}
+
+// fbFinish returns a flatbuffer with the root object.
+func fbFinish(root fbObject) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(root(&buf)))
+	return buf
+}
+
+// le16, le32 and le64 encode little endian scalars.
+func le16(v int16) []byte { return binary.LittleEndian.AppendUint16(nil, uint16(v)) }
+func le32(v int32) []byte { return binary.LittleEndian.AppendUint32(nil, uint32(v)) }
+func le64(v int64) []byte { return binary.LittleEndian.AppendUint64(nil, uint64(v)) }
+
+// arrowMessage returns an encapsulated message with the header and body.
+func arrowMessage(headerType byte, header fbObject, body []byte) []byte {
+	metadata := fbFinish(fbTableOf(le16(4), []byte{headerType}, header, le64(int64(len(body)))))
+	for len(metadata)%8 != 0 {
+		metadata = append(metadata, 0)
+	}
+	msg := append(le32(-1), le32(int32(len(metadata)))...)
+	msg = append(msg, metadata...)
+	return append(msg, body...)
+}
+
+// arrowTestSchema returns the schema message of the Arrow test data.
+func arrowTestSchema() []byte {
+	field := func(name string, typ byte, typeTable fbObject) fbObject {
+		return fbTableOf(fbString(name), []byte{1}, []byte{typ}, typeTable)
+	}
+	return arrowMessage(arrowSchema, fbTableOf(le16(0), fbVectorOf(
+		field("name", arrowTypeUtf8, fbTableOf()),
+		field("x", arrowTypeFloatingPoint, fbTableOf(le16(2))),
+		field("y", arrowTypeInt, fbTableOf(le32(32), []byte{1})),
+		field("ok", arrowTypeBool, fbTableOf()),
+		field("batch", arrowTypeUtf8, fbTableOf()),
+	)), nil)
+}
+
+// arrowTestBatch returns a record batch message with the rows from first to
+// last, exclusive, of the Arrow test data.
+func arrowTestBatch(first, last int) []byte {
+	names := []string{"s1", "s2", "s3"}
+	x := []float64{1.5, 2.5, 3.5}
+	y := []int32{10, 0, 30}
+	ok := []bool{true, false, true}
+	batches := []string{"b1", "", "b2"}
+
+	var body, nodes, buffers []byte
+	addBuffer := func(b []byte) {
+		buffers = append(append(buffers, le64(int64(len(body)))...), le64(int64(len(b)))...)
+		body = append(body, b...)
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+	}
+	addNode := func(nulls int) {
+		nodes = append(append(nodes, le64(int64(last-first))...), le64(int64(nulls))...)
+	}
+	bitmap := func(bit func(i int) bool) []byte {
+		b := make([]byte, 1)
+		for i := first; i < last; i++ {
+			if bit(i) {
+				b[0] |= 1 << (i - first)
+			}
+		}
+		return b
+	}
+	addStrings := func(strs []string, nullable bool) {
+		nulls := 0
+		offsets, chars := le32(0), []byte{}
+		for i := first; i < last; i++ {
+			if strs[i] == "" {
+				nulls++
+			}
+			chars = append(chars, strs[i]...)
+			offsets = append(offsets, le32(int32(len(chars)))...)
+		}
+		addNode(nulls)
+		if nullable && nulls > 0 {
+			addBuffer(bitmap(func(i int) bool { return strs[i] != "" }))
+		} else {
+			addBuffer(nil)
+		}
+		addBuffer(offsets)
+		addBuffer(chars)
+	}
+
+	addStrings(names, false)
+	addNode(0)
+	addBuffer(nil)
+	var values []byte
+	for _, v := range x[first:last] {
+		values = append(values, le64(int64(math.Float64bits(v)))...)
+	}
+	addBuffer(values)
+	nulls := 0
+	values = nil
+	for i, v := range y[first:last] {
+		if v == 0 {
+			nulls++
+		}
+		values = append(values, le32(y[first+i])...)
+	}
+	addNode(nulls)
+	addBuffer(bitmap(func(i int) bool { return y[i] != 0 }))
+	addBuffer(values)
+	addNode(0)
+	addBuffer(nil)
+	addBuffer(bitmap(func(i int) bool { return ok[i] }))
+	addStrings(batches, true)
+
+	return arrowMessage(arrowRecordBatch, fbTableOf(le64(int64(last-first)),
+		fbBytes(len(nodes)/16, nodes), fbBytes(len(buffers)/16, buffers)), body)
+}
+
+// TestProcessArrow checks the stream and file formats, with the data split
+// over two record batches.
+func TestProcessArrow(t *testing.T) {
+	stream := append(arrowTestSchema(), arrowTestBatch(0, 2)...)
+	stream = append(stream, arrowTestBatch(2, 3)...)
+	stream = append(stream, le32(-1)...)
+	stream = append(stream, le32(0)...)
+
+	got, X, err := ProcessArrowFrom(bytes.NewReader(stream), int64(len(stream)), nil)
+	if err != nil {
+		t.Fatalf("ProcessArrowFrom() stream error = %v", err)
+	}
+	checkColumnarData(t, got)
+	if X.At(2, 1) != 30 {
+		t.Errorf("ProcessArrowFrom() matrix = %v, want the data", X.RawMatrix().Data)
+	}
+
+	footer := []byte("footer not read")
+	file := append([]byte("ARROW1\x00\x00"), stream...)
+	file = append(file, footer...)
+	file = append(file, le32(int32(len(footer)))...)
+	file = append(file, arrowMagic...)
+	got, _, err = ProcessArrowFrom(bytes.NewReader(file), int64(len(file)), nil)
+	if err != nil {
+		t.Fatalf("ProcessArrowFrom() file error = %v", err)
+	}
+	checkColumnarData(t, got)
+
+	truncated := stream[:len(stream)-40]
+	if _, _, err := ProcessArrowFrom(bytes.NewReader(truncated), int64(len(truncated)), nil); err == nil {
+		t.Errorf("ProcessArrowFrom() expected an error for a truncated stream")
+	}
+	batch := arrowTestBatch(0, 3)
+	if _, _, err := ProcessArrowFrom(bytes.NewReader(batch), int64(len(batch)), nil); err == nil {
+		t.Errorf("ProcessArrowFrom() expected an error for a record batch without a schema")
+	}
+}
+
+// TestProcessArrowCorrupt checks that corrupt schemas and record batches
+// give errors rather than panics or huge allocations.
+func TestProcessArrowCorrupt(t *testing.T) {
+	schema := func(typ byte, typeTable fbObject) []byte {
+		field := fbTableOf(fbString("v"), []byte{1}, []byte{typ}, typeTable)
+		return arrowMessage(arrowSchema, fbTableOf(le16(0), fbVectorOf(field)), nil)
+	}
+	// batch returns a record batch of one column with the given length and
+	// number of nulls, without a validity bitmap and with a body of 8 bytes
+	// holding the values
+	batch := func(length, nulls int64) []byte {
+		nodes := append(le64(length), le64(nulls)...)
+		buffers := append(append(le64(0), le64(0)...), append(le64(0), le64(8)...)...)
+		return arrowMessage(arrowRecordBatch, fbTableOf(le64(length),
+			fbBytes(1, nodes), fbBytes(2, buffers)), make([]byte, 8))
+	}
+	double := schema(arrowTypeFloatingPoint, fbTableOf(le16(2)))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"24-bit integers", append(schema(arrowTypeInt, fbTableOf(le32(24), []byte{1})), batch(1, 0)...)},
+		{"only nulls", schema(arrowTypeNull, fbTableOf())},
+		{"too few values", slices.Concat(double, batch(2, 0))},
+		{"overflowing length", slices.Concat(double, batch(1<<61, 0))},
+		{"negative length", slices.Concat(double, batch(-1, 0))},
+		{"nulls without a validity bitmap", slices.Concat(double, batch(1, 1))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ProcessArrowFrom(bytes.NewReader(tt.data), int64(len(tt.data)), nil); err == nil {
+				t.Errorf("ProcessArrowFrom() expected an error")
+			}
+		})
+	}
+
+	valid := slices.Concat(double, batch(1, 0))
+	got, _, err := ProcessArrowFrom(bytes.NewReader(valid), int64(len(valid)), nil)
+	if err != nil || len(got.Data) != 1 {
+		t.Errorf("ProcessArrowFrom() = %v, %v, want one row", got.Data, err)
+	}
+}
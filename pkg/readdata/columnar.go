@@ -0,0 +1,192 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains the conversion of column oriented data, as
+// read from Parquet and Arrow files, to ProcessedData and a matrix.
+package readdata
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// dataColumn is a column read from a column oriented file. Numeric columns
+// hold floats, with NaN for nulls, and text columns hold strings, with ""
+// for nulls.
+type dataColumn struct {
+	name    string
+	text    bool
+	floats  []float64
+	strings []string
+}
+
+// len returns the number of values in the column.
+func (c dataColumn) len() int {
+	if c.text {
+		return len(c.strings)
+	}
+	return len(c.floats)
+}
+
+// columnBuilder builds data from the columns of a column oriented file,
+// read in batches of rows such as row groups or record batches. The numeric
+// columns of each batch are written straight into a chunk of matrix rows,
+// and the chunks are joined when all batches are read, like StreamCSV does.
+// A text first column holds the object names, otherwise the objects are
+// numbered from 1. Other text columns and the columns named as metadata are
+// kept as Metadata, and the other numeric columns are the variables.
+type columnBuilder struct {
+	columns   []dataColumn // Text columns collect their strings
+	variable  []int        // Column of the matrix of each numeric column
+	cols      int          // Number of numeric columns
+	rows      int          // Number of rows of the earlier batches
+	chunks    [][]float64  // Matrix rows of the earlier batches
+	batch     []float64    // Matrix rows of the current batch
+	batchRows int
+}
+
+// newColumnBuilder returns a builder for columns with the names and types
+// of the given columns.
+func newColumnBuilder(columns []dataColumn) *columnBuilder {
+	b := &columnBuilder{columns: columns, variable: make([]int, len(columns))}
+	for j, c := range columns {
+		if !c.text {
+			b.variable[j] = b.cols
+			b.cols++
+		}
+	}
+	return b
+}
+
+// addBatch starts a batch of n rows, with the numeric values zero.
+func (b *columnBuilder) addBatch(n int) {
+	if b.batchRows > 0 {
+		b.chunks = append(b.chunks, b.batch)
+		b.rows += b.batchRows
+	}
+	b.batch = make([]float64, n*b.cols)
+	b.batchRows = n
+}
+
+// set sets the value of numeric column j in row i of the batch.
+func (b *columnBuilder) set(i, j int, v float64) {
+	b.batch[i*b.cols+b.variable[j]] = v
+}
+
+// addText appends a value of text column j.
+func (b *columnBuilder) addText(j int, s string) {
+	b.columns[j].strings = append(b.columns[j].strings, s)
+}
+
+// addColumn copies the values of column j of the batch from c.
+func (b *columnBuilder) addColumn(j int, c dataColumn) error {
+	if c.len() != b.batchRows {
+		return fmt.Errorf("column %s has %d values, expected %d", b.columns[j].name, c.len(), b.batchRows)
+	}
+	if b.columns[j].text {
+		b.columns[j].strings = append(b.columns[j].strings, c.strings...)
+		return nil
+	}
+	for i, v := range c.floats {
+		b.set(i, j, v)
+	}
+	return nil
+}
+
+// data returns the data, and the data as a matrix that shares memory with
+// its rows. The named metadata columns, text or numeric, are kept as
+// Metadata in the order of the names, followed by the other text columns.
+// A first text column that is not named holds the object names.
+func (b *columnBuilder) data(metadata []string) (ProcessedData, *mat.Dense, error) {
+	if len(b.columns) == 0 {
+		return ProcessedData{}, nil, fmt.Errorf("data has no columns")
+	}
+	rows := b.rows + b.batchRows
+	values := b.batch
+	if b.chunks != nil {
+		values = joinChunks(b.chunks, b.batch, rows*b.cols)
+	}
+
+	isMetadata := make([]bool, len(b.columns))
+	var metadataCols []int
+	for _, name := range metadata {
+		col := slices.IndexFunc(b.columns, func(c dataColumn) bool { return c.name == name })
+		if col < 0 {
+			return ProcessedData{}, nil, fmt.Errorf("metadata column %q not found", name)
+		}
+		isMetadata[col] = true
+		metadataCols = append(metadataCols, col)
+	}
+	hasNames := b.columns[0].text && !isMetadata[0]
+	for j, c := range b.columns {
+		if c.text && !isMetadata[j] && (j > 0 || !hasNames) {
+			metadataCols = append(metadataCols, j)
+		}
+	}
+
+	var result ProcessedData
+	if hasNames {
+		result.ObjectNames = b.columns[0].strings
+	} else {
+		result.ObjectNames = make([]string, rows)
+		for i := range result.ObjectNames {
+			result.ObjectNames[i] = strconv.Itoa(i + 1)
+		}
+	}
+	for _, j := range metadataCols {
+		c := b.columns[j]
+		if !c.text {
+			c.strings = make([]string, rows)
+			for i := range c.strings {
+				if v := values[i*b.cols+b.variable[j]]; !math.IsNaN(v) {
+					c.strings[i] = strconv.FormatFloat(v, 'g', -1, 64)
+				}
+			}
+		}
+		result.Metadata = append(result.Metadata, newMetadata(c.name, c.strings))
+	}
+
+	// Numeric metadata columns are removed from the matrix in place, as the
+	// values only move towards the start
+	var kept []int
+	for j, c := range b.columns {
+		if !c.text && !isMetadata[j] {
+			result.VariableNames = append(result.VariableNames, c.name)
+			kept = append(kept, b.variable[j])
+		}
+	}
+	cols := len(kept)
+	if cols < b.cols {
+		for i := 0; i < rows; i++ {
+			for k, j := range kept {
+				values[i*cols+k] = values[i*b.cols+j]
+			}
+		}
+		values = values[:rows*cols]
+	}
+	if rows == 0 || cols == 0 {
+		return ProcessedData{}, nil, fmt.Errorf("data must contain at least one row and one numeric column")
+	}
+
+	X := mat.NewDense(rows, cols, values)
+	result.Data = make([][]float64, rows)
+	for i := range result.Data {
+		result.Data[i] = values[i*cols : (i+1)*cols : (i+1)*cols]
+	}
+	return result, X, nil
+}
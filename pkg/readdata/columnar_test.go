@@ -0,0 +1,122 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for column oriented data.
+package readdata
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// checkColumnarData checks the data of the Parquet and Arrow test files: the
+// object names s1 to s3, the variables x, y (with a null) and ok, and the
+// metadata column batch.
+func checkColumnarData(t *testing.T, got ProcessedData) {
+	t.Helper()
+	if !reflect.DeepEqual(got.ObjectNames, []string{"s1", "s2", "s3"}) {
+		t.Errorf("object names = %v, want [s1 s2 s3]", got.ObjectNames)
+	}
+	if !reflect.DeepEqual(got.VariableNames, []string{"x", "y", "ok"}) {
+		t.Errorf("variable names = %v, want [x y ok]", got.VariableNames)
+	}
+	want := [][]float64{{1.5, 10, 1}, {2.5, math.NaN(), 0}, {3.5, 30, 1}}
+	if len(got.Data) != len(want) {
+		t.Fatalf("data has %d rows, want %d", len(got.Data), len(want))
+	}
+	for i := range want {
+		for j := range want[i] {
+			if g, w := got.Data[i][j], want[i][j]; g != w && !(math.IsNaN(g) && math.IsNaN(w)) {
+				t.Errorf("data[%d][%d] = %v, want %v", i, j, g, w)
+			}
+		}
+	}
+	wantMetadata := []Metadata{{Name: "batch", Type: MetadataText, Values: []string{"b1", "", "b2"}}}
+	if !reflect.DeepEqual(got.Metadata, wantMetadata) {
+		t.Errorf("metadata = %+v, want %+v", got.Metadata, wantMetadata)
+	}
+}
+
+// TestColumnBuilder checks numbered objects, batches of rows and columns of
+// the wrong length.
+func TestColumnBuilder(t *testing.T) {
+	b := newColumnBuilder([]dataColumn{{name: "a"}, {name: "class", text: true}, {name: "b"}})
+	b.addBatch(2)
+	for j, c := range []dataColumn{{floats: []float64{1, 2}}, {text: true, strings: []string{"x", "y"}}, {floats: []float64{3, 4}}} {
+		if err := b.addColumn(j, c); err != nil {
+			t.Fatalf("addColumn() error = %v", err)
+		}
+	}
+	b.addBatch(1)
+	b.set(0, 0, 5)
+	b.addText(1, "z")
+	b.set(0, 2, 6)
+	got, X, err := b.data(nil)
+	if err != nil {
+		t.Fatalf("data() error = %v", err)
+	}
+	want := ProcessedData{
+		VariableNames: []string{"a", "b"},
+		ObjectNames:   []string{"1", "2", "3"},
+		Data:          [][]float64{{1, 3}, {2, 4}, {5, 6}},
+		Metadata:      []Metadata{{Name: "class", Type: MetadataText, Values: []string{"x", "y", "z"}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("data() = %+v, want %+v", got, want)
+	}
+	X.Set(2, 1, 42)
+	if got.Data[2][1] != 42 {
+		t.Errorf("data rows do not share memory with the matrix")
+	}
+
+	if err := b.addColumn(0, dataColumn{floats: []float64{1, 2}}); err == nil {
+		t.Errorf("addColumn() expected an error for a column of the wrong length")
+	}
+	// Named metadata columns, numeric or text, in the order of the names
+	b = newColumnBuilder([]dataColumn{{name: "name", text: true}, {name: "a"}, {name: "batch"}, {name: "b"}, {name: "class", text: true}})
+	b.addBatch(2)
+	for j, c := range []dataColumn{{text: true, strings: []string{"o1", "o2"}}, {floats: []float64{1, 2}}, {floats: []float64{7, math.NaN()}}, {floats: []float64{3, 4}}, {text: true, strings: []string{"x", "y"}}} {
+		if err := b.addColumn(j, c); err != nil {
+			t.Fatalf("addColumn() error = %v", err)
+		}
+	}
+	got, X, err = b.data([]string{"class", "batch"})
+	if err != nil {
+		t.Fatalf("data() error = %v", err)
+	}
+	want = ProcessedData{
+		VariableNames: []string{"a", "b"},
+		ObjectNames:   []string{"o1", "o2"},
+		Data:          [][]float64{{1, 3}, {2, 4}},
+		Metadata: []Metadata{
+			{Name: "class", Type: MetadataText, Values: []string{"x", "y"}},
+			{Name: "batch", Type: MetadataNumber, Values: []string{"7", ""}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(X.RawMatrix().Data, []float64{1, 3, 2, 4}) {
+		t.Errorf("data() with metadata = %+v, %v, want %+v", got, X.RawMatrix().Data, want)
+	}
+	b = newColumnBuilder([]dataColumn{{name: "a"}})
+	if _, _, err := b.data([]string{"missing"}); err == nil {
+		t.Errorf("data() expected an error for a metadata column that is not found")
+	}
+
+	b = newColumnBuilder([]dataColumn{{name: "a", text: true}})
+	b.addBatch(1)
+	b.addText(0, "x")
+	if _, _, err := b.data(nil); err == nil {
+		t.Errorf("data() expected an error without numeric columns")
+	}
+}
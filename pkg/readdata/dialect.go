@@ -0,0 +1,428 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains the CSV dialect options: delimiter, decimal
+// separator, comment lines and character encoding.
+package readdata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Character encodings of CSV files.
+const (
+	EncodingAuto        = "auto"         // Detect from the byte order mark, else UTF-8 with other bytes as Windows-1252
+	EncodingUTF8        = "utf-8"        // UTF-8, with or without byte order mark
+	EncodingUTF16       = "utf-16"       // UTF-16, little endian unless a byte order mark says otherwise
+	EncodingLatin1      = "latin1"       // ISO 8859-1
+	EncodingWindows1252 = "windows-1252" // Windows Western European, a superset of ISO 8859-1
+)
+
+// CSVOptions describes the dialect of a CSV file, and which columns hold
+// metadata rather than numeric data. The zero value detects the delimiter,
+// decimal separator and encoding automatically.
+type CSVOptions struct {
+	Delimiter     rune     // Field delimiter, detected from the first line if 0
+	Decimal       rune     // Decimal separator, detected if 0 (see DecimalSeparator)
+	Comment       rune     // Lines starting with this character are skipped, none if 0
+	Encoding      string   // One of the Encoding constants, EncodingAuto if empty
+	Metadata      []string // Names of non-numeric columns, e.g. class labels, kept as Metadata
+	CollectErrors bool     // Report every bad cell as ParseErrors, rather than stop at the first
+}
+
+// DefaultCSVOptions returns the options of a plain comma separated file with
+// decimal points, as used by ReadCSV and ProcessCSV.
+func DefaultCSVOptions() CSVOptions {
+	return CSVOptions{Delimiter: ',', Decimal: '.', Encoding: EncodingUTF8}
+}
+
+// delimiterCandidates are the delimiters considered by DetectDelimiter.
+var delimiterCandidates = []rune{',', ';', '\t', '|'}
+
+// DetectDelimiter returns the delimiter that occurs most often outside quotes
+// in the first non-comment line of data, or a comma if none occur.
+func DetectDelimiter(data []byte, comment rune) rune {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || (comment != 0 && strings.HasPrefix(line, string(comment))) {
+			continue
+		}
+		counts := make(map[rune]int)
+		inQuotes := false
+		for _, c := range line {
+			if c == '"' {
+				inQuotes = !inQuotes
+			} else if !inQuotes {
+				counts[c]++
+			}
+		}
+		best := ','
+		for _, d := range delimiterCandidates {
+			if counts[d] > counts[best] {
+				best = d
+			}
+		}
+		return best
+	}
+	return ','
+}
+
+// DetectDecimal returns the decimal separator of the numbers in a sample of
+// CSV data with the given delimiter: a comma if the fields are not comma
+// separated and hold numbers with decimal commas but none with decimal
+// points, e.g. the semicolon separated files exported in much of Europe, and
+// a point otherwise. The header and the object names are not considered.
+func DetectDecimal(data []byte, delimiter, comment rune) rune {
+	if delimiter == ',' {
+		return '.'
+	}
+	reader := CSVOptions{Delimiter: delimiter, Comment: comment}.csvReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	if _, err := reader.Read(); err != nil { // Header
+		return '.'
+	}
+	var commas, points int
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			break
+		}
+		for _, field := range record[1:] {
+			switch decimalOf(field) {
+			case ',':
+				commas++
+			case '.':
+				points++
+			}
+		}
+	}
+	if commas > 0 && points == 0 {
+		return ','
+	}
+	return '.'
+}
+
+// decimalOf returns the decimal separator of a field that is a number with
+// a decimal comma or point, and 0 for other fields.
+func decimalOf(field string) rune {
+	field = strings.TrimSpace(field)
+	switch {
+	case strings.Count(field, ",") == 1 && !strings.Contains(field, "."):
+		if _, err := strconv.ParseFloat(strings.Replace(field, ",", ".", 1), 64); err == nil {
+			return ','
+		}
+	case strings.Contains(field, "."):
+		if _, err := strconv.ParseFloat(field, 64); err == nil {
+			return '.'
+		}
+	}
+	return 0
+}
+
+// decimalSample returns the start of the text used to detect the decimal
+// separator, cut after the last complete line when the text continues.
+func decimalSample(text []byte, more bool) []byte {
+	if len(text) > streamBufferSize {
+		text, more = text[:streamBufferSize], true
+	}
+	if more {
+		if i := bytes.LastIndexByte(text, '\n'); i >= 0 {
+			text = text[:i+1]
+		}
+	}
+	return text
+}
+
+// DecimalSeparator returns the decimal separator to use, a point when it is
+// neither given nor detected. Decimal points are accepted either way.
+func (o CSVOptions) DecimalSeparator() rune {
+	if o.Decimal != 0 {
+		return o.Decimal
+	}
+	return '.'
+}
+
+// newReader returns a CSV reader for the dialect that decodes r while
+// reading (see newDecoder). The options are returned with the detected
+// delimiter and decimal separator filled in.
+func (o CSVOptions) newReader(r io.Reader) (*csv.Reader, CSVOptions, error) {
+	text, err := newDecoder(r, o.Encoding)
+	if err != nil {
+		return nil, o, err
+	}
+	if o.Delimiter == 0 {
+		o.Delimiter = DetectDelimiter(peekFirstLine(text, o.Comment), o.Comment)
+	}
+	if o.Decimal == 0 {
+		sample, err := text.Peek(text.Size())
+		o.Decimal = DetectDecimal(decimalSample(sample, err == nil), o.Delimiter, o.Comment)
+	}
+	if o.Decimal == o.Delimiter {
+		return nil, o, fmt.Errorf("the decimal separator and the delimiter are both %q", o.Decimal)
+	}
+	return o.csvReader(text), o, nil
+}
+
+// csvReader returns a CSV reader for the dialect, reading UTF-8 text from r.
+// The delimiter must be known.
+func (o CSVOptions) csvReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = o.Delimiter
+	reader.Comment = o.Comment
+	reader.LazyQuotes = true       // Accept stray quotes in headers from lab software
+	reader.TrimLeadingSpace = true // Accept spaces before quoted fields
+	return reader
+}
+
+// peekFirstLine returns the start of the buffered text, enough to hold the
+// first line that is neither blank nor a comment if it fits in the buffer.
+func peekFirstLine(br *bufio.Reader, comment rune) []byte {
+	for n := 4096; ; n *= 2 {
+		sample, err := br.Peek(min(n, br.Size()))
+		if err != nil || n >= br.Size() {
+			return sample
+		}
+		lines := bytes.Split(sample, []byte("\n"))
+		for _, line := range lines[:len(lines)-1] { // Complete lines only
+			line = bytes.TrimSpace(line)
+			if len(line) > 0 && (comment == 0 || !bytes.HasPrefix(line, []byte(string(comment)))) {
+				return sample
+			}
+		}
+	}
+}
+
+// newDecoder returns a reader of r converted from the given encoding to
+// UTF-8, without a byte order mark. With EncodingAuto, a UTF-8 or UTF-16
+// byte order mark gives the encoding, and otherwise valid UTF-8 is read as
+// is and every byte that is not part of a valid UTF-8 sequence is decoded
+// as Windows-1252, wherever it occurs in the data.
+func newDecoder(r io.Reader, encoding string) (*bufio.Reader, error) {
+	br := bufio.NewReaderSize(r, streamBufferSize)
+	head, _ := br.Peek(3)
+	hasUTF8BOM := bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF})
+	bigEndian := bytes.HasPrefix(head, []byte{0xFE, 0xFF})
+	littleEndian := bytes.HasPrefix(head, []byte{0xFF, 0xFE})
+
+	var text io.Reader
+	switch strings.ToLower(encoding) {
+	case "", EncodingAuto:
+		switch {
+		case hasUTF8BOM:
+			br.Discard(3)
+			text = &utf8Reader{br: br, invalid: windows1252Rune}
+		case bigEndian || littleEndian:
+			text = utf16Reader(br, bigEndian, littleEndian)
+		default:
+			text = &utf8Reader{br: br, invalid: windows1252Rune}
+		}
+	case EncodingUTF8, "utf8":
+		if hasUTF8BOM {
+			br.Discard(3)
+		}
+		text = &utf8Reader{br: br, invalid: func(byte) (rune, error) {
+			return 0, fmt.Errorf("data is not valid UTF-8, try another encoding")
+		}}
+	case EncodingUTF16, "utf16":
+		text = utf16Reader(br, bigEndian, littleEndian)
+	case EncodingLatin1, "iso-8859-1":
+		text = &decodingReader{next: func() (rune, error) {
+			b, err := br.ReadByte()
+			return rune(b), err
+		}}
+	case EncodingWindows1252, "cp1252":
+		text = &decodingReader{next: func() (rune, error) {
+			b, err := br.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			return windows1252Rune(b)
+		}}
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+	return bufio.NewReaderSize(text, streamBufferSize), nil
+}
+
+// decodingReader converts text to UTF-8 while reading, one rune at a time.
+type decodingReader struct {
+	next func() (rune, error)
+	buf  []byte
+	err  error
+}
+
+// Read reads UTF-8 text.
+func (d *decodingReader) Read(p []byte) (int, error) {
+	if len(d.buf) == 0 {
+		d.buf = d.buf[:0:cap(d.buf)]
+	}
+	for len(d.buf) < len(p) && d.err == nil {
+		r, err := d.next()
+		if err != nil {
+			d.err = err
+			break
+		}
+		d.buf = utf8.AppendRune(d.buf, r)
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	if n == 0 && d.err != nil {
+		return 0, d.err
+	}
+	return n, nil
+}
+
+// utf8Reader reads UTF-8 text, and calls invalid for every byte that is not
+// part of a valid UTF-8 sequence, to decode it or return an error. Valid
+// text is copied as is, a buffer at a time.
+type utf8Reader struct {
+	br      *bufio.Reader
+	invalid func(b byte) (rune, error)
+	buf     []byte // Decoded rune not yet read
+}
+
+// Read reads UTF-8 text.
+func (u *utf8Reader) Read(p []byte) (int, error) {
+	if len(u.buf) == 0 {
+		if _, err := u.br.Peek(1); err != nil {
+			return 0, err
+		}
+		data, _ := u.br.Peek(min(len(p), u.br.Buffered()))
+		if n := validUTF8Prefix(data); n > 0 {
+			copy(p, data[:n])
+			u.br.Discard(n)
+			return n, nil
+		}
+
+		// An invalid byte, or a rune cut off by the end of the buffer
+		r, size, _ := u.br.ReadRune()
+		if r == utf8.RuneError && size == 1 {
+			u.br.UnreadRune()
+			b, _ := u.br.ReadByte()
+			var err error
+			if r, err = u.invalid(b); err != nil {
+				return 0, err
+			}
+		}
+		u.buf = utf8.AppendRune(u.buf[:0], r)
+	}
+	n := copy(p, u.buf)
+	u.buf = u.buf[n:]
+	return n, nil
+}
+
+// validUTF8Prefix returns the length of the longest start of data that is
+// complete, valid UTF-8.
+func validUTF8Prefix(data []byte) int {
+	i := 0
+	for i < len(data) {
+		if data[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// windows1252 maps the bytes 0x80 to 0x9F, where Windows-1252 differs from
+// ISO 8859-1. Unused bytes map to the replacement character.
+var windows1252 = [32]rune{
+	'€', '�', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '�', 'Ž', '�',
+	'�', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '�', 'ž', 'Ÿ',
+}
+
+// windows1252Rune decodes a Windows-1252 byte.
+func windows1252Rune(b byte) (rune, error) {
+	if b >= 0x80 && b < 0xA0 {
+		return windows1252[b-0x80], nil
+	}
+	return rune(b), nil
+}
+
+// utf16Reader converts UTF-16 text to UTF-8 while reading. A byte order
+// mark is removed, and text without one is little endian.
+func utf16Reader(br *bufio.Reader, bigEndian, littleEndian bool) io.Reader {
+	if bigEndian || littleEndian {
+		br.Discard(2)
+	}
+	unit := func() (rune, error) {
+		var b [2]byte
+		if _, err := io.ReadFull(br, b[:]); err != nil {
+			return 0, io.EOF // An odd last byte is ignored
+		}
+		if bigEndian {
+			return rune(b[0])<<8 | rune(b[1]), nil
+		}
+		return rune(b[1])<<8 | rune(b[0]), nil
+	}
+	return &decodingReader{next: func() (rune, error) {
+		r, err := unit()
+		if err != nil || !utf16.IsSurrogate(r) {
+			return r, err
+		}
+		low, err := unit()
+		if err != nil {
+			return utf8.RuneError, nil
+		}
+		return utf16.DecodeRune(r, low), nil
+	}}
+}
+
+// ParseCSVOptions creates the options of a dialect given as strings, e.g.
+// on the command line. The delimiter, decimal separator and comment
+// character are parsed by ParseRune.
+func ParseCSVOptions(delimiter, decimal, comment, encoding string) (CSVOptions, error) {
+	opts := CSVOptions{Encoding: encoding}
+	var err error
+	if opts.Delimiter, err = ParseRune(delimiter); err != nil {
+		return CSVOptions{}, fmt.Errorf("invalid delimiter: %v", err)
+	}
+	if opts.Decimal, err = ParseRune(decimal); err != nil {
+		return CSVOptions{}, fmt.Errorf("invalid decimal separator: %v", err)
+	}
+	if opts.Comment, err = ParseRune(comment); err != nil {
+		return CSVOptions{}, fmt.Errorf("invalid comment character: %v", err)
+	}
+	return opts, nil
+}
+
+// ParseRune parses a delimiter or separator given on the command line. It
+// accepts a single character, "tab", or "auto" and "" for detection (0).
+func ParseRune(s string) (rune, error) {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		return 0, nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("%q is not a single character", s)
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return r, nil
+}
@@ -0,0 +1,184 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the CSV dialect options.
+package readdata
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// writeTestFile writes data to a file in a temporary directory.
+func writeTestFile(t *testing.T, data []byte) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(filename, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// TestProcessCSVEuropean checks a semicolon separated file with decimal
+// commas, a byte order mark, comment lines and quoted headers.
+func TestProcessCSVEuropean(t *testing.T) {
+	data := "\xEF\xBB\xBF# Exported from lab software\n" +
+		`"";"Temp (°C)"; 'pH'` + "\n" +
+		"# Calibration run\n" +
+		"Prøve 1;21,5;7,1\n" +
+		"Prøve 2;22;NA\n"
+	filename := writeTestFile(t, []byte(data))
+
+	got, err := ProcessCSVWithOptions(filename, CSVOptions{Comment: '#'})
+	if err != nil {
+		t.Fatalf("ProcessCSVWithOptions() error = %v", err)
+	}
+	if want := []string{"Temp (°C)", "pH"}; !reflect.DeepEqual(got.VariableNames, want) {
+		t.Errorf("VariableNames = %q, want %q", got.VariableNames, want)
+	}
+	if want := []string{"Prøve 1", "Prøve 2"}; !reflect.DeepEqual(got.ObjectNames, want) {
+		t.Errorf("ObjectNames = %q, want %q", got.ObjectNames, want)
+	}
+	if got.Data[0][0] != 21.5 || got.Data[0][1] != 7.1 || got.Data[1][0] != 22 {
+		t.Errorf("Data = %v", got.Data)
+	}
+
+	if _, err := ProcessCSV(filename); err == nil {
+		t.Errorf("ProcessCSV() expected an error with the default dialect")
+	}
+}
+
+// TestDecode checks the supported character encodings.
+func TestDecode(t *testing.T) {
+	want := "a;Ø\n"
+	latin1 := []byte{'a', ';', 0xD8, '\n'}
+
+	testCases := []struct {
+		encoding string
+		data     []byte
+	}{
+		{EncodingAuto, []byte("\xEF\xBB\xBF" + want)},
+		{EncodingAuto, []byte(want)},
+		{EncodingAuto, latin1},
+		{EncodingLatin1, latin1},
+		{EncodingWindows1252, latin1},
+		{EncodingUTF8, []byte(want)},
+	}
+	units := utf16.Encode([]rune(want))
+	le := []byte{0xFF, 0xFE}
+	for _, u := range units {
+		le = append(le, byte(u), byte(u>>8))
+	}
+	testCases = append(testCases, struct {
+		encoding string
+		data     []byte
+	}{EncodingAuto, le})
+
+	for _, tc := range testCases {
+		got, err := decodeAll(tc.data, tc.encoding)
+		if err != nil {
+			t.Errorf("newDecoder(%s) error = %v", tc.encoding, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("newDecoder(%s) = %q, want %q", tc.encoding, got, want)
+		}
+	}
+
+	if _, err := decodeAll(latin1, EncodingUTF8); err == nil {
+		t.Errorf("newDecoder() expected an error for invalid UTF-8")
+	}
+	if _, err := decodeAll(latin1, "ebcdic"); err == nil {
+		t.Errorf("newDecoder() expected an error for an unknown encoding")
+	}
+
+	// Windows-1252 bytes after a long stretch of UTF-8, beyond the read
+	// buffer, are decoded without changing the UTF-8 before them
+	padding := strings.Repeat("ø", streamBufferSize)
+	mixed := append([]byte(padding+"\n"), latin1...)
+	got, err := decodeAll(mixed, EncodingAuto)
+	if err != nil || string(got) != padding+"\n"+want {
+		t.Errorf("newDecoder() of mixed data is wrong, error = %v", err)
+	}
+}
+
+// decodeAll converts all data to UTF-8 with newDecoder.
+func decodeAll(data []byte, encoding string) ([]byte, error) {
+	text, err := newDecoder(bytes.NewReader(data), encoding)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(text)
+}
+
+// TestDetectDelimiter checks delimiter detection, ignoring quoted fields and comments.
+func TestDetectDelimiter(t *testing.T) {
+	testCases := []struct {
+		data string
+		want rune
+	}{
+		{"a,b,c\n1,2,3\n", ','},
+		{"# a,b,c\n\"x,y\";b;c\n", ';'},
+		{"a\tb\tc\n", '\t'},
+		{"a|b\n", '|'},
+		{"single\n", ','},
+	}
+	for _, tc := range testCases {
+		if got := DetectDelimiter([]byte(tc.data), '#'); got != tc.want {
+			t.Errorf("DetectDelimiter(%q) = %q, want %q", tc.data, got, tc.want)
+		}
+	}
+}
+
+// TestDetectDecimal checks that the decimal separator is taken from the
+// numbers, not the header or object names.
+func TestDetectDecimal(t *testing.T) {
+	testCases := []struct {
+		data      string
+		delimiter rune
+		want      rune
+	}{
+		{"x;1,5;2,5\no,1;21,5;7\n", ';', ','},
+		{"x;1,5;2,5\no,1;21.5;7\n", ';', '.'},
+		{"x;a;b\n1,2;3;4\n", ';', '.'},
+		{"x\ta\tb\no1\t1,5\t2,25e3", '\t', ','},
+		{"x,a\no1,\"1,5\"\n", ',', '.'},
+		{"x;a;b\n# 1,5\no1;2,5;1.5\n", ';', '.'},
+	}
+	for _, tc := range testCases {
+		if got := DetectDecimal([]byte(tc.data), tc.delimiter, '#'); got != tc.want {
+			t.Errorf("DetectDecimal(%q) = %q, want %q", tc.data, got, tc.want)
+		}
+	}
+}
+
+// TestParseCSVOptions checks the options given as strings.
+func TestParseCSVOptions(t *testing.T) {
+	got, err := ParseCSVOptions("tab", "auto", "#", EncodingLatin1)
+	if err != nil {
+		t.Fatalf("ParseCSVOptions() error = %v", err)
+	}
+	if want := (CSVOptions{Delimiter: '\t', Comment: '#', Encoding: EncodingLatin1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseCSVOptions() = %+v, want %+v", got, want)
+	}
+	if _, err := ParseCSVOptions(";", ",,", "", ""); err == nil {
+		t.Errorf("ParseCSVOptions() expected an error for a decimal separator of two characters")
+	}
+}
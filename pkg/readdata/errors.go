@@ -0,0 +1,131 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains the errors for cells and rows that cannot
+// be read, and the conversion of records to floats that reports them.
+package readdata
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ParseError describes a cell that is not a number, or a row with the wrong
+// number of fields. Line and Column are 1-based, and Column is 0 for errors
+// in a whole row.
+type ParseError struct {
+	Line     int    // Line of the CSV file, or row of the sheet
+	Column   int    // Column of the cell, 1 being the object names
+	Variable string // Name of the variable in the column
+	Value    string // The cell as read
+	Err      error  // The error, e.g. strconv.ErrSyntax or csv.ErrFieldCount
+}
+
+// Error returns the position and value of the cell with the error.
+func (e *ParseError) Error() string {
+	if e.Column == 0 {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %d (%s): invalid number %q: %v", e.Line, e.Column, e.Variable, e.Value, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors holds every parse error in the data, in the order of the
+// file. It is returned instead of the first ParseError when
+// CSVOptions.CollectErrors is set, together with the data that was read:
+// bad cells are NaN, and rows with the wrong number of fields are left out.
+type ParseErrors []*ParseError
+
+// Error lists the errors, one on each line.
+func (e ParseErrors) Error() string {
+	var b strings.Builder
+	if len(e) == 1 {
+		b.WriteString("1 parse error:")
+	} else {
+		fmt.Fprintf(&b, "%d parse errors:", len(e))
+	}
+	for _, err := range e {
+		b.WriteString("\n  ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns the errors, so errors.As finds the first ParseError.
+func (e ParseErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// recordParser converts the numeric cells of records to floats. With
+// collect set it keeps going after an error, and the errors are returned
+// by err at the end.
+type recordParser struct {
+	header     []string // Header row, with the variable names
+	isMetadata []bool   // Whether each column holds metadata
+	decimal    rune     // Decimal separator
+	collect    bool     // Collect all errors rather than stop at the first
+	errs       ParseErrors
+}
+
+// parse appends the numeric cells of a record to values. The line of each
+// field is given by line. Bad cells are NaN when errors are collected, and
+// a record with the wrong number of fields is skipped, with ok false.
+func (p *recordParser) parse(values []float64, record []string, line func(field int) int) (_ []float64, ok bool, err error) {
+	if len(record) != len(p.header) {
+		err := fmt.Errorf("%w: %d, the header has %d", csv.ErrFieldCount, len(record), len(p.header))
+		return values, false, p.fail(&ParseError{Line: line(0), Err: err})
+	}
+	for j := 1; j < len(record); j++ {
+		if p.isMetadata[j] {
+			continue
+		}
+		v, err := parseCell(record[j], p.decimal)
+		if err != nil {
+			e := &ParseError{Line: line(j), Column: j + 1, Variable: cleanName(p.header[j]), Value: record[j], Err: err}
+			if err := p.fail(e); err != nil {
+				return values, false, err
+			}
+			v = math.NaN()
+		}
+		values = append(values, v)
+	}
+	return values, true, nil
+}
+
+// fail records an error when errors are collected, and otherwise returns it.
+func (p *recordParser) fail(e *ParseError) error {
+	if p.collect {
+		p.errs = append(p.errs, e)
+		return nil
+	}
+	return e
+}
+
+// err returns the collected errors, or nil if there are none.
+func (p *recordParser) err() error {
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return p.errs
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the parse errors.
+package readdata
+
+import (
+	"encoding/csv"
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// badCSV has a comment line, a bad cell on line 4, a row with too few
+// fields on line 5 and a quoted field over two lines before a bad cell on
+// line 7.
+const badCSV = `# comment
+,x,y,class
+o1,1,2,a
+o2,abc,3,b
+o3,4
+"o
+4",5,1e999,c
+`
+
+// TestParseErrors checks the position of the first error, and that all
+// errors are collected together with the data, for the loaders of CSV data.
+func TestParseErrors(t *testing.T) {
+	want := ParseErrors{
+		{Line: 4, Column: 2, Variable: "x", Value: "abc", Err: strconv.ErrSyntax},
+		{Line: 5, Err: csv.ErrFieldCount},
+		{Line: 7, Column: 3, Variable: "y", Value: "1e999", Err: strconv.ErrRange},
+	}
+	load := map[string]func(opts CSVOptions) (ProcessedData, error){
+		"ProcessCSVFrom": func(opts CSVOptions) (ProcessedData, error) {
+			return ProcessCSVFrom(strings.NewReader(badCSV), opts)
+		},
+		"StreamCSV": func(opts CSVOptions) (ProcessedData, error) {
+			d, _, err := StreamCSV(strings.NewReader(badCSV), opts)
+			return d, err
+		},
+	}
+	for name, load := range load {
+		opts := CSVOptions{Comment: '#', Metadata: []string{"class"}}
+		_, err := load(opts)
+		var first *ParseError
+		if !errors.As(err, &first) {
+			t.Fatalf("%s() error = %v, want a *ParseError", name, err)
+		}
+		if *first != *want[0] {
+			t.Errorf("%s() error = %+v, want %+v", name, *first, *want[0])
+		}
+
+		opts.CollectErrors = true
+		d, err := load(opts)
+		var all ParseErrors
+		if !errors.As(err, &all) {
+			t.Fatalf("%s() collected error = %v, want ParseErrors", name, err)
+		}
+		if len(all) != len(want) {
+			t.Fatalf("%s() collected %d errors, want %d: %v", name, len(all), len(want), err)
+		}
+		for i := range want {
+			got := *all[i]
+			if !errors.Is(got.Err, want[i].Err) {
+				t.Errorf("%s() error %d = %v, want %v", name, i, got.Err, want[i].Err)
+			}
+			got.Err = want[i].Err
+			if !reflect.DeepEqual(got, *want[i]) {
+				t.Errorf("%s() error %d = %+v, want %+v", name, i, got, *want[i])
+			}
+		}
+		if !errors.Is(err, csv.ErrFieldCount) {
+			t.Errorf("%s() collected errors do not wrap csv.ErrFieldCount", name)
+		}
+		// The row with too few fields is left out, and bad cells are NaN
+		if len(d.Data) != 3 || !math.IsNaN(d.Data[1][0]) || d.Data[1][1] != 3 || !math.IsNaN(d.Data[2][1]) {
+			t.Errorf("%s() collected data = %v, want 3 rows with NaN for the bad cells", name, d.Data)
+		}
+	}
+}
+
+// TestParseErrorMessage checks the messages of cell and row errors.
+func TestParseErrorMessage(t *testing.T) {
+	errs := ParseErrors{
+		{Line: 4, Column: 2, Variable: "x", Value: "abc", Err: strconv.ErrSyntax},
+		{Line: 5, Err: csv.ErrFieldCount},
+	}
+	want := "2 parse errors:\n" +
+		"  line 4, column 2 (x): invalid number \"abc\": invalid syntax\n" +
+		"  line 5: wrong number of fields"
+	if got := errs.Error(); got != want {
+		t.Errorf("ParseErrors.Error() = %q, want %q", got, want)
+	}
+}
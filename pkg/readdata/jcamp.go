@@ -0,0 +1,335 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains a reader for JCAMP-DX spectra (.jdx, .dx),
+// with data tables in the plain (AFFN) and compressed (ASDF) forms.
+package readdata
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// jcampBlock holds the labels and data of a JCAMP-DX block being read.
+type jcampBlock struct {
+	labels  map[string]string
+	table   string    // XYDATA or XYPOINTS, empty before the data table
+	x       []float64 // x values of XYPOINTS, in file units
+	y       []float64 // y values, in file units
+	lastDIF bool      // The previous XYDATA line ended in DIF form
+}
+
+// ReadJCAMP reads the spectra in a JCAMP-DX file. Each block with an XYDATA
+// table of the form (X++(Y..Y)) or an XYPOINTS table of the form (XY..XY)
+// gives a spectrum; link blocks without data are skipped. The x and y
+// values are scaled by XFACTOR and YFACTOR, and the x values of XYDATA are
+// spaced evenly from FIRSTX to LASTX.
+func ReadJCAMP(r io.Reader) ([]Spectrum, error) {
+	var spectra []Spectrum
+	block := newJCAMPBlock()
+	finish := func() error {
+		if block.table != "" {
+			s, err := block.spectrum()
+			if err != nil {
+				return err
+			}
+			spectra = append(spectra, s)
+		}
+		block = newJCAMPBlock()
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if k := strings.Index(line, "$$"); k >= 0 {
+			line = line[:k] // Comment
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "##") {
+			if block.table == "" {
+				continue // Continuation of a label value
+			}
+			if err := block.addLine(line); err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			continue
+		}
+
+		label, value, _ := strings.Cut(line[2:], "=")
+		label = normalizeLabel(label)
+		value = strings.TrimSpace(value)
+		switch label {
+		case "TITLE":
+			if err := finish(); err != nil {
+				return nil, err
+			}
+		case "END":
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			continue
+		case "XYDATA":
+			if !strings.Contains(value, "++") {
+				return nil, fmt.Errorf("line %d: unsupported XYDATA form %s", lineNumber, value)
+			}
+		case "XYPOINTS":
+			if !strings.Contains(strings.ReplaceAll(value, " ", ""), "XY..XY") {
+				return nil, fmt.Errorf("line %d: unsupported XYPOINTS form %s", lineNumber, value)
+			}
+		case "PEAKTABLE", "NTUPLES":
+			return nil, fmt.Errorf("line %d: %s data is not supported", lineNumber, label)
+		}
+		if block.table != "" && label != "XYDATA" && label != "XYPOINTS" {
+			block.table = "" // The data table ends at the next label
+		}
+		if label == "XYDATA" || label == "XYPOINTS" {
+			if len(block.y) > 0 {
+				return nil, fmt.Errorf("line %d: more than one data table in a block", lineNumber)
+			}
+			block.table = label
+		}
+		block.labels[label] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	if len(spectra) == 0 {
+		return nil, fmt.Errorf("no XYDATA or XYPOINTS data found")
+	}
+	return spectra, nil
+}
+
+// newJCAMPBlock returns an empty block.
+func newJCAMPBlock() *jcampBlock {
+	return &jcampBlock{labels: make(map[string]string)}
+}
+
+// normalizeLabel returns a label in upper case without spaces, dashes,
+// slashes and underscores, as labels are compared in JCAMP-DX.
+func normalizeLabel(label string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '/', '_':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(label))
+}
+
+// addLine adds a line of the data table to the block.
+func (b *jcampBlock) addLine(line string) error {
+	values, lastDIF, err := decodeASDF(line)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	if b.table == "XYPOINTS" {
+		if len(values)%2 != 0 {
+			return fmt.Errorf("odd number of values in XYPOINTS line")
+		}
+		for i := 0; i < len(values); i += 2 {
+			b.x = append(b.x, values[i])
+			b.y = append(b.y, values[i+1])
+		}
+		return nil
+	}
+
+	// The first value is the x value. When the previous line ended in DIF
+	// form, the first y value repeats its last y value as a check.
+	y := values[1:]
+	if b.lastDIF && len(y) > 0 && len(b.y) > 0 {
+		last := b.y[len(b.y)-1]
+		if math.Abs(y[0]-last) > 1e-9*math.Max(1, math.Abs(last)) {
+			return fmt.Errorf("y check value %v does not match the previous value %v", y[0], last)
+		}
+		y = y[1:]
+	}
+	b.y = append(b.y, y...)
+	b.lastDIF = lastDIF
+	return nil
+}
+
+// number returns the numeric value of a label, or def if it is missing.
+func (b *jcampBlock) number(label string, def float64) (float64, error) {
+	s, ok := b.labels[label]
+	if !ok || s == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", label, s)
+	}
+	return v, nil
+}
+
+// spectrum returns the spectrum of the block.
+func (b *jcampBlock) spectrum() (Spectrum, error) {
+	title := b.labels["TITLE"]
+	if len(b.y) == 0 {
+		return Spectrum{}, fmt.Errorf("spectrum %q has no data", title)
+	}
+	xFactor, err := b.number("XFACTOR", 1)
+	if err != nil {
+		return Spectrum{}, err
+	}
+	yFactor, err := b.number("YFACTOR", 1)
+	if err != nil {
+		return Spectrum{}, err
+	}
+	nPoints, err := b.number("NPOINTS", float64(len(b.y)))
+	if err != nil {
+		return Spectrum{}, err
+	}
+	if int(nPoints) != len(b.y) {
+		return Spectrum{}, fmt.Errorf("spectrum %q has %d points, NPOINTS is %v", title, len(b.y), nPoints)
+	}
+
+	s := Spectrum{Title: title, X: make([]float64, len(b.y)), Y: make([]float64, len(b.y))}
+	for i, y := range b.y {
+		s.Y[i] = y * yFactor
+	}
+	if b.table == "XYPOINTS" || len(b.x) > 0 {
+		for i, x := range b.x {
+			s.X[i] = x * xFactor
+		}
+		return s, nil
+	}
+
+	if b.labels["FIRSTX"] == "" || b.labels["LASTX"] == "" {
+		return Spectrum{}, fmt.Errorf("spectrum %q has no FIRSTX or LASTX", title)
+	}
+	firstX, err := b.number("FIRSTX", 0)
+	if err != nil {
+		return Spectrum{}, err
+	}
+	lastX, err := b.number("LASTX", 0)
+	if err != nil {
+		return Spectrum{}, err
+	}
+	s.X[0] = firstX
+	for i := 1; i < len(s.X); i++ {
+		s.X[i] = firstX + float64(i)*(lastX-firstX)/float64(len(s.X)-1)
+	}
+	return s, nil
+}
+
+// asdfToken is a number in a JCAMP-DX data line.
+type asdfToken struct {
+	kind byte   // 'A' for AFFN, 'S' for SQZ, 'D' for DIF and 'U' for DUP
+	text string // The number, with the SQZ, DIF or DUP character replaced by digits
+}
+
+// decodeASDF decodes a data line in AFFN or ASDF form (SQZ, DIF and DUP
+// compression) to values. It also reports whether the line ends with a
+// value in DIF form. A "?" is a missing value, returned as NaN.
+func decodeASDF(line string) ([]float64, bool, error) {
+	var tokens []asdfToken
+	var current *asdfToken
+	start := func(kind byte, text string) {
+		tokens = append(tokens, asdfToken{kind, text})
+		current = &tokens[len(tokens)-1]
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c >= '0' && c <= '9' || c == '.':
+			if current == nil {
+				start('A', "")
+			}
+			current.text += string(c)
+		case (c == 'E' || c == 'e') && current != nil && current.kind == 'A' &&
+			i+1 < len(line) && (line[i+1] == '+' || line[i+1] == '-'):
+			current.text += string(c) + string(line[i+1]) // AFFN exponent
+			i++
+		case c == '+' || c == '-':
+			start('A', string(c))
+		case c == '?':
+			start('A', "NaN")
+			current = nil
+		case c == '@':
+			start('S', "0")
+		case c >= 'A' && c <= 'I':
+			start('S', string('1'+c-'A'))
+		case c >= 'a' && c <= 'i':
+			start('S', "-"+string('1'+c-'a'))
+		case c == '%':
+			start('D', "0")
+		case c >= 'J' && c <= 'R':
+			start('D', string('1'+c-'J'))
+		case c >= 'j' && c <= 'r':
+			start('D', "-"+string('1'+c-'j'))
+		case c >= 'S' && c <= 'Z':
+			start('U', string('1'+c-'S'))
+		case c == 's':
+			start('U', "9")
+		case c == ' ' || c == '\t' || c == ',' || c == ';':
+			current = nil
+		default:
+			return nil, false, fmt.Errorf("invalid character %q in data line", c)
+		}
+	}
+
+	var values []float64
+	var last asdfToken
+	for _, t := range tokens {
+		if t.kind == 'U' {
+			count, err := strconv.Atoi(t.text)
+			if err != nil || count < 1 || len(values) == 0 {
+				return nil, false, fmt.Errorf("invalid DUP count %q", t.text)
+			}
+			for k := 1; k < count; k++ {
+				v := values[len(values)-1]
+				if last.kind == 'D' {
+					d, _ := strconv.ParseFloat(last.text, 64)
+					v += d
+				}
+				values = append(values, v)
+			}
+			continue
+		}
+
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid number %q in data line", t.text)
+		}
+		if t.kind == 'D' {
+			if len(values) == 0 {
+				return nil, false, fmt.Errorf("DIF value without a preceding value")
+			}
+			v += values[len(values)-1]
+		}
+		values = append(values, v)
+		last = t
+	}
+	return values, last.kind == 'D', nil
+}
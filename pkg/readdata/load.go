@@ -0,0 +1,107 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains the loading of a data file in any of the
+// supported formats, chosen by the file extension.
+package readdata
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitjungle/goLV/pkg/utils"
+	"gonum.org/v1/gonum/mat"
+)
+
+// LoadOptions configures Load.
+type LoadOptions struct {
+	CSV     CSVOptions     // CSV dialect, the metadata columns of all formats, and error collection of CSV and .xlsx files
+	Sheet   string         // Sheet of .xlsx files, the first sheet if empty
+	Range   string         // Cell range of .xlsx files, all used cells if empty
+	Spectra SpectraOptions // Object naming of spectra
+}
+
+// Load reads data from a CSV file, an Excel workbook if the file has the
+// extension .xlsx, a Parquet file (.parquet), an Arrow IPC file or stream
+// (.arrow, .arrows, .feather or .ipc), or
+// JCAMP-DX and SPC spectra if the file has one of their extensions or is a
+// directory of spectra. The filename - reads CSV from standard input. The
+// data is also returned as a matrix, which shares memory with the rows of
+// the data when possible. With CollectErrors, ParseErrors are returned
+// together with the data, as by ProcessCSVFrom.
+func Load(filename string, opts LoadOptions) (ProcessedData, *mat.Dense, error) {
+	var records ProcessedData
+	var err error
+	switch {
+	case filename == "-":
+		return StreamCSV(os.Stdin, opts.CSV)
+	case strings.EqualFold(filepath.Ext(filename), ".xlsx"):
+		records, err = ProcessXLSX(filename, XLSXOptions{Sheet: opts.Sheet, Range: opts.Range, Metadata: opts.CSV.Metadata, CollectErrors: opts.CSV.CollectErrors})
+	case strings.EqualFold(filepath.Ext(filename), ".parquet"):
+		return ProcessParquet(filename, opts.CSV.Metadata)
+	case isArrowFile(filename):
+		return ProcessArrow(filename, opts.CSV.Metadata)
+	case IsSpectralFile(filename) || isDir(filename):
+		if name := opts.Spectra.NameBy; name != "" && name != SpectrumNameFile && name != SpectrumNameTitle {
+			return ProcessedData{}, nil, fmt.Errorf("invalid spectrum names %q, use file or title", name)
+		}
+		records, err = ProcessSpectra([]string{filename}, opts.Spectra)
+	default:
+		return StreamCSVFile(filename, opts.CSV)
+	}
+	var parseErrs ParseErrors
+	if err != nil && !errors.As(err, &parseErrs) {
+		return ProcessedData{}, nil, err
+	}
+	X, denseErr := utils.SliceToDense(records.Data)
+	if denseErr != nil {
+		if err == nil {
+			err = denseErr
+		}
+		return ProcessedData{}, nil, err
+	}
+	return records, X, err
+}
+
+// Dense returns the data as a matrix. X is reused if the rows of the data
+// are still those of X, i.e. no objects or variables were removed since X
+// was loaded.
+func (d ProcessedData) Dense(X *mat.Dense) (*mat.Dense, error) {
+	if X != nil && len(d.Data) > 0 {
+		r, c := X.Dims()
+		if r == len(d.Data) && c == len(d.Data[0]) && &d.Data[0][0] == &X.RawMatrix().Data[0] {
+			return X, nil
+		}
+	}
+	return utils.SliceToDense(d.Data)
+}
+
+// isArrowFile reports whether the file has the extension of an Arrow IPC
+// file or stream.
+func isArrowFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".arrow", ".arrows", ".feather", ".ipc":
+		return true
+	}
+	return false
+}
+
+// isDir reports whether the path is a directory.
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for loading data files by their
+// extension.
+package readdata
+
+import (
+	"reflect"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// TestLoad checks that CSV files and Excel workbooks with the same values
+// load alike, and that the matrix holds the values.
+func TestLoad(t *testing.T) {
+	csvData, csvX, err := Load("../../data/test_data.csv", LoadOptions{})
+	if err != nil {
+		t.Fatalf("Load() CSV error = %v", err)
+	}
+	xlsxData, xlsxX, err := Load("../../data/mean_center_variance_scale.xlsx", LoadOptions{Sheet: "Ark1", Range: "A2:F9"})
+	if err != nil {
+		t.Fatalf("Load() XLSX error = %v", err)
+	}
+	if !reflect.DeepEqual(csvData.Data, xlsxData.Data) {
+		t.Errorf("Load() XLSX data = %v, want %v", xlsxData.Data, csvData.Data)
+	}
+	if !mat.Equal(csvX, xlsxX) {
+		t.Errorf("Load() XLSX matrix = %v, want %v", mat.Formatted(xlsxX), mat.Formatted(csvX))
+	}
+	if _, _, err := Load("../../data", LoadOptions{Spectra: SpectraOptions{NameBy: "bad"}}); err == nil {
+		t.Errorf("Load() accepted invalid spectrum names")
+	}
+}
+
+// TestDense checks that the matrix is reused while the rows of the data
+// share its memory, and copied after a selection.
+func TestDense(t *testing.T) {
+	d, X, err := Load("../../data/test_data.csv", LoadOptions{})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got, err := d.Dense(X); err != nil || got != X {
+		t.Errorf("Dense() = %p, %v, want the loaded matrix %p", got, err, X)
+	}
+	selected, _, err := d.SelectObjects(Selection{Exclude: []string{"#1"}})
+	if err != nil {
+		t.Fatalf("SelectObjects() error = %v", err)
+	}
+	got, err := selected.Dense(X)
+	if err != nil {
+		t.Fatalf("Dense() error = %v", err)
+	}
+	if r, _ := got.Dims(); r != len(d.Data)-1 || got == X {
+		t.Errorf("Dense() after selection has %d rows, want a new matrix with %d", r, len(d.Data)-1)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains metadata columns, such as class labels,
+// batch IDs and dates, kept alongside the numeric data.
+package readdata
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata types, detected from the values of a metadata column.
+const (
+	MetadataNumber = "number" // All values are numbers, e.g. batch numbers
+	MetadataDate   = "date"   // All values are dates or times in one of DateLayouts
+	MetadataText   = "text"   // Any other values, e.g. class labels
+)
+
+// DateLayouts are the date and time formats recognized in metadata columns.
+var DateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"02.01.2006 15:04:05",
+	"02.01.2006",
+}
+
+// Metadata is a non-numeric column of the data, with one value for each
+// object. Missing values are empty strings.
+type Metadata struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"` // One of the Metadata type constants
+	Values []string `json:"values"`
+}
+
+// newMetadata creates a metadata column and detects its type. Missing values
+// (see isMissing) become empty strings and are ignored by the detection.
+func newMetadata(name string, values []string) Metadata {
+	m := Metadata{Name: name, Type: MetadataNumber, Values: make([]string, len(values))}
+	isDate := true
+	for i, v := range values {
+		v = strings.TrimSpace(v)
+		if isMissing(v) {
+			continue
+		}
+		m.Values[i] = v
+		if _, err := strconv.ParseFloat(v, 64); err != nil && m.Type == MetadataNumber {
+			m.Type = MetadataText
+		}
+		if _, err := parseDate(v); err != nil {
+			isDate = false
+		}
+	}
+	if m.Type == MetadataText && isDate {
+		m.Type = MetadataDate
+	}
+	return m
+}
+
+// parseDate parses a date or time in one of DateLayouts.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range DateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a recognized date", s)
+}
+
+// Floats returns the values of a number column, with NaN for missing values.
+func (m Metadata) Floats() ([]float64, error) {
+	if m.Type != MetadataNumber {
+		return nil, fmt.Errorf("metadata %q has type %s, not %s", m.Name, m.Type, MetadataNumber)
+	}
+	floats := make([]float64, len(m.Values))
+	for i, v := range m.Values {
+		if v == "" {
+			floats[i] = math.NaN()
+			continue
+		}
+		floats[i], _ = strconv.ParseFloat(v, 64)
+	}
+	return floats, nil
+}
+
+// Times returns the values of a date column, with the zero time for missing
+// values.
+func (m Metadata) Times() ([]time.Time, error) {
+	if m.Type != MetadataDate {
+		return nil, fmt.Errorf("metadata %q has type %s, not %s", m.Name, m.Type, MetadataDate)
+	}
+	times := make([]time.Time, len(m.Values))
+	for i, v := range m.Values {
+		if v != "" {
+			times[i], _ = parseDate(v)
+		}
+	}
+	return times, nil
+}
+
+// MetadataColumn returns the named metadata column, or nil if there is none.
+func (d ProcessedData) MetadataColumn(name string) *Metadata {
+	for i := range d.Metadata {
+		if d.Metadata[i].Name == name {
+			return &d.Metadata[i]
+		}
+	}
+	return nil
+}
+
+// selectMetadataRows returns the metadata of the given rows.
+func selectMetadataRows(metadata []Metadata, rows []int) []Metadata {
+	if metadata == nil {
+		return nil
+	}
+	selected := make([]Metadata, len(metadata))
+	for k, m := range metadata {
+		selected[k] = Metadata{Name: m.Name, Type: m.Type, Values: make([]string, len(rows))}
+		for r, i := range rows {
+			selected[k].Values[r] = m.Values[i]
+		}
+	}
+	return selected
+}
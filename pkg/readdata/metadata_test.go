@@ -0,0 +1,75 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the metadata columns.
+package readdata
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestProcessCSVMetadata checks that metadata columns are kept apart from
+// the numeric data, with detected types, and follow the object selection.
+func TestProcessCSVMetadata(t *testing.T) {
+	data := ",Class,x,Batch,Date,y\n" +
+		"o1,A,1,10,2024-03-01,2\n" +
+		"o2,B,3,,2024-03-02,4\n" +
+		"o3,A,5,12,NA,6\n"
+	opts := DefaultCSVOptions()
+	opts.Metadata = []string{"Date", "Class", "Batch"}
+	got, err := ProcessCSVFrom(strings.NewReader(data), opts)
+	if err != nil {
+		t.Fatalf("ProcessCSVFrom() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.VariableNames, []string{"x", "y"}) || !reflect.DeepEqual(got.Data[2], []float64{5, 6}) {
+		t.Errorf("ProcessCSVFrom() numeric data = %v, %v", got.VariableNames, got.Data)
+	}
+
+	want := []Metadata{
+		{Name: "Date", Type: MetadataDate, Values: []string{"2024-03-01", "2024-03-02", ""}},
+		{Name: "Class", Type: MetadataText, Values: []string{"A", "B", "A"}},
+		{Name: "Batch", Type: MetadataNumber, Values: []string{"10", "", "12"}},
+	}
+	if !reflect.DeepEqual(got.Metadata, want) {
+		t.Errorf("ProcessCSVFrom() metadata = %+v, want %+v", got.Metadata, want)
+	}
+
+	batch, err := got.MetadataColumn("Batch").Floats()
+	if err != nil || batch[0] != 10 || !math.IsNaN(batch[1]) {
+		t.Errorf("Floats() = %v, %v", batch, err)
+	}
+	times, err := got.MetadataColumn("Date").Times()
+	if err != nil || times[1].Day() != 2 || !times[2].IsZero() {
+		t.Errorf("Times() = %v, %v", times, err)
+	}
+	if _, err := got.MetadataColumn("Class").Floats(); err == nil {
+		t.Errorf("Floats() expected an error for a text column")
+	}
+
+	selected, _, err := got.SelectObjects(Selection{Exclude: []string{"o2"}})
+	if err != nil {
+		t.Fatalf("SelectObjects() error = %v", err)
+	}
+	if c := selected.MetadataColumn("Class"); !reflect.DeepEqual(c.Values, []string{"A", "A"}) {
+		t.Errorf("SelectObjects() metadata = %v", c.Values)
+	}
+
+	opts.Metadata = []string{"Missing"}
+	if _, err := ProcessCSVFrom(strings.NewReader(data), opts); err == nil {
+		t.Errorf("ProcessCSVFrom() expected an error for an unknown metadata column")
+	}
+}
@@ -0,0 +1,531 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains a reader for Parquet files. It reads flat
+// tables of numeric and string columns.
+package readdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"gonum.org/v1/gonum/mat"
+)
+
+// parquetMagic starts and ends Parquet files.
+var parquetMagic = []byte("PAR1")
+
+// Parquet physical types.
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// Parquet repetition types, encodings, compression codecs, page types and
+// converted types used by the reader.
+const (
+	parquetOptional = 1
+	parquetRepeated = 2
+
+	parquetPlain          = 0
+	parquetPlainDict      = 2
+	parquetRLE            = 3
+	parquetRLEDictionary  = 8
+	parquetUncompressed   = 0
+	parquetSnappy         = 1
+	parquetGzip           = 2
+	parquetZstd           = 6
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+	parquetDataPageV2     = 3
+	parquetDecimal        = 5
+)
+
+// parquetColumn is a leaf column of a Parquet schema.
+type parquetColumn struct {
+	name     string
+	typ      int64
+	optional bool
+	scale    int // Decimal scale of integer columns
+}
+
+// ProcessParquet reads data from a Parquet file. Numeric and boolean columns
+// become variables, with NaN for nulls. The columns named in metadataNames
+// are kept as Metadata, a string column first holds the object names, and
+// other string columns are also kept as Metadata. The data is
+// also returned as a matrix, which shares memory with the rows of the data.
+//
+// The reader supports flat schemas with the PLAIN and dictionary encodings,
+// data pages of version 1 and 2, and uncompressed, Snappy, gzip or zstd
+// compressed column chunks.
+func ProcessParquet(filename string, metadataNames []string) (ProcessedData, *mat.Dense, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return ProcessedData{}, nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return ProcessedData{}, nil, err
+	}
+	return ProcessParquetFrom(file, info.Size(), metadataNames)
+}
+
+// ProcessParquetFrom reads data from a Parquet file of the given size in r,
+// like ProcessParquet. Only the footer and the column chunks are read, one
+// column chunk at a time, and the values of each row group are written
+// straight into the matrix.
+func ProcessParquetFrom(r io.ReaderAt, size int64, metadataNames []string) (ProcessedData, *mat.Dense, error) {
+	tail := make([]byte, 8)
+	if size < 12 {
+		return ProcessedData{}, nil, fmt.Errorf("file is too short for Parquet")
+	}
+	if _, err := r.ReadAt(tail, size-8); err != nil {
+		return ProcessedData{}, nil, err
+	}
+	if !bytes.Equal(tail[4:], parquetMagic) {
+		return ProcessedData{}, nil, fmt.Errorf("not a Parquet file")
+	}
+	footerSize := int64(binary.LittleEndian.Uint32(tail))
+	if footerSize > size-12 {
+		return ProcessedData{}, nil, fmt.Errorf("invalid Parquet footer size %d", footerSize)
+	}
+	footer := make([]byte, footerSize)
+	if _, err := r.ReadAt(footer, size-8-footerSize); err != nil {
+		return ProcessedData{}, nil, err
+	}
+	metadata, err := (&thriftReader{data: footer}).readStruct()
+	if err != nil {
+		return ProcessedData{}, nil, err
+	}
+
+	schema, err := parquetSchema(metadata)
+	if err != nil {
+		return ProcessedData{}, nil, err
+	}
+	columns := make([]dataColumn, len(schema))
+	for j, s := range schema {
+		columns[j] = dataColumn{name: s.name, text: s.typ == parquetByteArray}
+	}
+	builder := newColumnBuilder(columns)
+
+	for _, rg := range metadata.list(4) {
+		group, _ := rg.(thriftFields)
+		chunks := group.list(1)
+		if len(chunks) != len(schema) {
+			return ProcessedData{}, nil, fmt.Errorf("row group has %d columns, the schema has %d", len(chunks), len(schema))
+		}
+		for j, c := range chunks {
+			chunk, _ := c.(thriftFields)
+			column := dataColumn{name: schema[j].name, text: columns[j].text}
+			if err := readParquetChunk(r, size, chunk, schema[j], &column); err != nil {
+				return ProcessedData{}, nil, fmt.Errorf("error reading column %s: %v", schema[j].name, err)
+			}
+			// The first column gives the number of rows of the row group
+			if j == 0 {
+				builder.addBatch(column.len())
+			}
+			if err := builder.addColumn(j, column); err != nil {
+				return ProcessedData{}, nil, err
+			}
+		}
+	}
+	return builder.data(metadataNames)
+}
+
+// parquetSchema returns the leaf columns of a flat schema.
+func parquetSchema(metadata thriftFields) ([]parquetColumn, error) {
+	elements := metadata.list(2)
+	if len(elements) < 2 {
+		return nil, fmt.Errorf("Parquet file has no columns")
+	}
+	var columns []parquetColumn
+	for _, e := range elements[1:] {
+		element, _ := e.(thriftFields)
+		c := parquetColumn{name: element.string(4)}
+		if n, _ := element.int(5); n > 0 {
+			return nil, fmt.Errorf("nested column %s is not supported", c.name)
+		}
+		repetition, _ := element.int(3)
+		if repetition == parquetRepeated {
+			return nil, fmt.Errorf("repeated column %s is not supported", c.name)
+		}
+		c.optional = repetition == parquetOptional
+		c.typ, _ = element.int(1)
+		switch c.typ {
+		case parquetBoolean, parquetInt32, parquetInt64, parquetFloat, parquetDouble, parquetByteArray:
+		default:
+			return nil, fmt.Errorf("column %s has Parquet type %d, which is not supported", c.name, c.typ)
+		}
+		if converted, _ := element.int(6); converted == parquetDecimal {
+			scale, _ := element.int(7)
+			c.scale = int(scale)
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+// readParquetChunk reads the pages of a column chunk and appends the values
+// to the column.
+func readParquetChunk(r io.ReaderAt, size int64, chunk thriftFields, schema parquetColumn, column *dataColumn) error {
+	meta := chunk.child(3)
+	if meta == nil {
+		return fmt.Errorf("column chunk has no metadata")
+	}
+	codec, _ := meta.int(4)
+	numValues, _ := meta.int(5)
+	compressedSize, _ := meta.int(7)
+	start, _ := meta.int(9)
+	if dict, ok := meta.int(11); ok && dict > 0 && dict < start {
+		start = dict
+	}
+	if start < 0 || compressedSize < 0 || start+compressedSize > size {
+		return fmt.Errorf("column chunk is outside the file")
+	}
+	data := make([]byte, compressedSize)
+	if _, err := r.ReadAt(data, start); err != nil {
+		return err
+	}
+
+	var dictionary *dataColumn
+	read := int64(0)
+	pos := 0
+	for read < numValues && pos < len(data) {
+		tr := &thriftReader{data: data, pos: pos}
+		header, err := tr.readStruct()
+		if err != nil {
+			return err
+		}
+		pageSize, _ := header.int(3)
+		uncompressedSize, _ := header.int(2)
+		pos = tr.pos
+		if pageSize < 0 || pos+int(pageSize) > len(data) {
+			return fmt.Errorf("page is outside the column chunk")
+		}
+		page := data[pos : pos+int(pageSize)]
+		pos += int(pageSize)
+
+		pageType, _ := header.int(1)
+		switch pageType {
+		case parquetDictionaryPage:
+			page, err = decompress(page, codec, uncompressedSize)
+			if err != nil {
+				return err
+			}
+			n, _ := header.child(7).int(1)
+			if n < 0 {
+				return fmt.Errorf("dictionary page has %d values", n)
+			}
+			dictionary = &dataColumn{text: column.text}
+			if err := decodePlain(page, int(n), schema, dictionary); err != nil {
+				return err
+			}
+		case parquetDataPage:
+			page, err = decompress(page, codec, uncompressedSize)
+			if err != nil {
+				return err
+			}
+			h := header.child(5)
+			n, _ := h.int(1)
+			encoding, _ := h.int(2)
+			if n < 0 || n > numValues-read {
+				return fmt.Errorf("page has %d values, the column chunk has %d left", n, numValues-read)
+			}
+			var levels []int
+			if schema.optional {
+				if len(page) < 4 {
+					return fmt.Errorf("page is too short for definition levels")
+				}
+				length := int(binary.LittleEndian.Uint32(page))
+				if length < 0 || 4+length > len(page) {
+					return fmt.Errorf("definition levels are outside the page")
+				}
+				if levels, err = decodeRLEHybrid(page[4:4+length], 1, int(n)); err != nil {
+					return err
+				}
+				page = page[4+length:]
+			}
+			if err := decodeParquetPage(page, int(n), encoding, levels, schema, dictionary, column); err != nil {
+				return err
+			}
+			read += n
+		case parquetDataPageV2:
+			h := header.child(8)
+			n, _ := h.int(1)
+			encoding, _ := h.int(4)
+			if n < 0 || n > numValues-read {
+				return fmt.Errorf("page has %d values, the column chunk has %d left", n, numValues-read)
+			}
+			defLength, _ := h.int(5)
+			repLength, _ := h.int(6)
+			if defLength < 0 || repLength < 0 || defLength+repLength > int64(len(page)) {
+				return fmt.Errorf("levels are outside the page")
+			}
+			var levels []int
+			if schema.optional {
+				if levels, err = decodeRLEHybrid(page[repLength:repLength+defLength], 1, int(n)); err != nil {
+					return err
+				}
+			}
+			page = page[repLength+defLength:]
+			if h.bool(7, true) {
+				if page, err = decompress(page, codec, uncompressedSize-defLength-repLength); err != nil {
+					return err
+				}
+			}
+			if err := decodeParquetPage(page, int(n), encoding, levels, schema, dictionary, column); err != nil {
+				return err
+			}
+			read += n
+		}
+	}
+	if read != numValues {
+		return fmt.Errorf("column chunk has %d values, expected %d", read, numValues)
+	}
+	return nil
+}
+
+// decodeParquetPage decodes the values of a data page and appends them to
+// the column, with nulls where the definition level is 0.
+func decodeParquetPage(page []byte, n int, encoding int64, levels []int, schema parquetColumn, dictionary, column *dataColumn) error {
+	defined := n
+	if levels != nil {
+		defined = 0
+		for _, l := range levels {
+			if l != 0 {
+				defined++
+			}
+		}
+	}
+
+	values := &dataColumn{text: column.text}
+	switch encoding {
+	case parquetPlain:
+		if err := decodePlain(page, defined, schema, values); err != nil {
+			return err
+		}
+	case parquetRLE:
+		if schema.typ != parquetBoolean || len(page) < 4 {
+			return fmt.Errorf("RLE encoding is only supported for booleans")
+		}
+		bits, err := decodeRLEHybrid(page[4:], 1, defined)
+		if err != nil {
+			return err
+		}
+		for _, b := range bits {
+			values.floats = append(values.floats, float64(b))
+		}
+	case parquetPlainDict, parquetRLEDictionary:
+		if dictionary == nil {
+			return fmt.Errorf("dictionary encoded page without a dictionary")
+		}
+		if len(page) < 1 {
+			return fmt.Errorf("dictionary encoded page is empty")
+		}
+		indices, err := decodeRLEHybrid(page[1:], int(page[0]), defined)
+		if err != nil {
+			return err
+		}
+		for _, k := range indices {
+			if k >= dictionary.len() {
+				return fmt.Errorf("dictionary index %d is out of range", k)
+			}
+			if column.text {
+				values.strings = append(values.strings, dictionary.strings[k])
+			} else {
+				values.floats = append(values.floats, dictionary.floats[k])
+			}
+		}
+	default:
+		return fmt.Errorf("Parquet encoding %d is not supported", encoding)
+	}
+
+	k := 0
+	for i := 0; i < n; i++ {
+		if levels != nil && levels[i] == 0 {
+			if column.text {
+				column.strings = append(column.strings, "")
+			} else {
+				column.floats = append(column.floats, math.NaN())
+			}
+			continue
+		}
+		if column.text {
+			column.strings = append(column.strings, values.strings[k])
+		} else {
+			column.floats = append(column.floats, values.floats[k])
+		}
+		k++
+	}
+	return nil
+}
+
+// decodePlain decodes n values in the PLAIN encoding and appends them to the
+// column.
+func decodePlain(data []byte, n int, schema parquetColumn, column *dataColumn) error {
+	le := binary.LittleEndian
+	width := map[int64]int{parquetInt32: 4, parquetInt64: 8, parquetFloat: 4, parquetDouble: 8}[schema.typ]
+	switch {
+	case n < 0,
+		schema.typ == parquetBoolean && n > 8*len(data),
+		width > 0 && n > len(data)/width:
+		return fmt.Errorf("page has too few values")
+	}
+	scale := math.Pow10(-schema.scale)
+
+	pos := 0
+	for i := 0; i < n; i++ {
+		var v float64
+		switch schema.typ {
+		case parquetBoolean:
+			v = float64((data[i/8] >> (i % 8)) & 1)
+		case parquetInt32:
+			v = float64(int32(le.Uint32(data[4*i:]))) * scale
+		case parquetInt64:
+			v = float64(int64(le.Uint64(data[8*i:]))) * scale
+		case parquetFloat:
+			v = float64(math.Float32frombits(le.Uint32(data[4*i:])))
+		case parquetDouble:
+			v = math.Float64frombits(le.Uint64(data[8*i:]))
+		case parquetByteArray:
+			if pos+4 > len(data) {
+				return fmt.Errorf("page has too few values")
+			}
+			length := int(le.Uint32(data[pos:]))
+			pos += 4
+			if length < 0 || pos+length > len(data) {
+				return fmt.Errorf("string is outside the page")
+			}
+			column.strings = append(column.strings, string(data[pos:pos+length]))
+			pos += length
+			continue
+		}
+		column.floats = append(column.floats, v)
+	}
+	return nil
+}
+
+// decodeRLEHybrid decodes n values of the given bit width in the Parquet
+// RLE/bit-packing hybrid encoding.
+func decodeRLEHybrid(data []byte, bitWidth, n int) ([]int, error) {
+	if bitWidth < 0 || bitWidth > 32 {
+		return nil, fmt.Errorf("invalid bit width %d", bitWidth)
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("invalid number of values %d", n)
+	}
+	byteWidth := (bitWidth + 7) / 8
+	values := make([]int, 0, min(n, 8*len(data)))
+	r := &thriftReader{data: data}
+	for len(values) < n {
+		header := r.varint()
+		if r.err != nil {
+			return nil, fmt.Errorf("RLE data has too few values")
+		}
+		if header>>1 == 0 {
+			return nil, fmt.Errorf("RLE data has an empty run")
+		}
+		if header>>1 > uint64(n) {
+			return nil, fmt.Errorf("RLE run is longer than the data")
+		}
+		if header&1 == 0 { // Run of a repeated value
+			count := int(header >> 1)
+			if r.pos+byteWidth > len(data) {
+				return nil, fmt.Errorf("RLE run is outside the data")
+			}
+			v := 0
+			for b := 0; b < byteWidth; b++ {
+				v |= int(data[r.pos+b]) << (8 * b)
+			}
+			r.pos += byteWidth
+			for k := 0; k < count && len(values) < n; k++ {
+				values = append(values, v)
+			}
+			continue
+		}
+
+		// Groups of 8 bit-packed values, least significant bit first
+		count := int(header>>1) * 8
+		size := count * bitWidth / 8
+		if r.pos+size > len(data) {
+			return nil, fmt.Errorf("bit-packed run is outside the data")
+		}
+		packed := data[r.pos : r.pos+size]
+		r.pos += size
+		for k := 0; k < count && len(values) < n; k++ {
+			v := 0
+			for b := 0; b < bitWidth; b++ {
+				bit := k*bitWidth + b
+				v |= int((packed[bit/8]>>(bit%8))&1) << b
+			}
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// zstdDecoder decompresses zstd pages. DecodeAll is safe for concurrent use.
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(thriftMaxLength))
+
+// decompress decompresses a page with the codec of the column chunk. The
+// page must have the uncompressed size given by its header.
+func decompress(data []byte, codec, uncompressedSize int64) ([]byte, error) {
+	if codec == parquetUncompressed {
+		return data, nil
+	}
+	if uncompressedSize < 0 || uncompressedSize > thriftMaxLength {
+		return nil, fmt.Errorf("invalid uncompressed page size %d", uncompressedSize)
+	}
+	var out []byte
+	var err error
+	switch codec {
+	case parquetSnappy:
+		if n, err := snappy.DecodedLen(data); err != nil || int64(n) != uncompressedSize {
+			return nil, fmt.Errorf("corrupt Snappy data")
+		}
+		out, err = snappy.Decode(nil, data)
+	case parquetGzip:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		buf := bytes.NewBuffer(make([]byte, 0, uncompressedSize))
+		_, err = io.Copy(buf, io.LimitReader(zr, uncompressedSize+1))
+		out = buf.Bytes()
+	case parquetZstd:
+		out, err = zstdDecoder.DecodeAll(data, make([]byte, 0, uncompressedSize))
+	default:
+		return nil, fmt.Errorf("Parquet compression codec %d is not supported, use none, snappy, gzip or zstd", codec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) != uncompressedSize {
+		return nil, fmt.Errorf("page has %d bytes after decompression, expected %d", len(out), uncompressedSize)
+	}
+	return out, nil
+}
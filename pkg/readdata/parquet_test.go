@@ -0,0 +1,291 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the Parquet reader.
+package readdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// tf is a Thrift field for the test encoder. Values are int32, int64,
+// string, bool, []tf for structs and []any for lists.
+type tf struct {
+	id int16
+	v  any
+}
+
+// thriftEncode encodes a struct in the Thrift compact protocol.
+func thriftEncode(fields []tf) []byte {
+	var buf []byte
+	var last int16
+	for _, f := range fields {
+		typ := thriftTypeOf(f.v)
+		if b, ok := f.v.(bool); ok && !b {
+			typ = thriftFalse
+		}
+		if delta := f.id - last; delta > 0 && delta <= 15 {
+			buf = append(buf, byte(delta)<<4|typ)
+		} else {
+			buf = binary.AppendUvarint(append(buf, typ), uint64(int64(f.id)<<1^int64(f.id)>>63))
+		}
+		last = f.id
+		if _, ok := f.v.(bool); !ok {
+			buf = thriftAppend(buf, f.v)
+		}
+	}
+	return append(buf, thriftStop)
+}
+
+// thriftTypeOf returns the compact protocol type of a test value.
+func thriftTypeOf(v any) byte {
+	switch v.(type) {
+	case bool:
+		return thriftTrue
+	case int32:
+		return thriftI32
+	case int64:
+		return thriftI64
+	case string:
+		return thriftBinary
+	case []tf:
+		return thriftStruct
+	}
+	return thriftList
+}
+
+// thriftAppend appends a value in the Thrift compact protocol.
+func thriftAppend(buf []byte, v any) []byte {
+	zigzag := func(n int64) uint64 { return uint64(n<<1 ^ n>>63) }
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return append(buf, thriftTrue)
+		}
+		return append(buf, thriftFalse)
+	case int32:
+		return binary.AppendUvarint(buf, zigzag(int64(v)))
+	case int64:
+		return binary.AppendUvarint(buf, zigzag(v))
+	case string:
+		return append(binary.AppendUvarint(buf, uint64(len(v))), v...)
+	case []tf:
+		return append(buf, thriftEncode(v)...)
+	case []any:
+		buf = append(buf, byte(len(v))<<4|thriftTypeOf(v[0]))
+		for _, e := range v {
+			buf = thriftAppend(buf, e)
+		}
+	}
+	return buf
+}
+
+// snappyLiteral encodes data as a Snappy block of one literal.
+func snappyLiteral(data []byte) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(data)))
+	buf = append(buf, 61<<2, byte(len(data)-1), byte((len(data)-1)>>8))
+	return append(buf, data...)
+}
+
+// zstdEncoder compresses test pages with zstd.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// gzipped compresses data with gzip.
+func gzipped(data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// parquetPage returns a page header and the page. For data pages of version
+// 2 the header is a DataPageHeaderV2 and the levels are not compressed.
+func parquetPage(pageType int32, header []tf, data []byte, codec int32) []byte {
+	compressed := data
+	switch codec {
+	case parquetSnappy:
+		compressed = snappyLiteral(data)
+	case parquetGzip:
+		compressed = gzipped(data)
+	case parquetZstd:
+		compressed = zstdEncoder.EncodeAll(data, nil)
+	}
+	id := map[int32]int16{parquetDataPage: 5, parquetDictionaryPage: 7, parquetDataPageV2: 8}[pageType]
+	h := thriftEncode([]tf{{1, pageType}, {2, int32(len(data))}, {3, int32(len(compressed))}, {id, header}})
+	return append(h, compressed...)
+}
+
+// testParquetColumn is a column of a test file, with its encoded pages.
+type testParquetColumn struct {
+	name       string
+	typ        int32
+	optional   bool
+	codec      int32
+	numValues  int64
+	dictionary []byte
+	pages      []byte
+}
+
+// testParquetFile returns a Parquet file with the columns in one row group.
+func testParquetFile(numRows int64, columns ...testParquetColumn) []byte {
+	buf := append([]byte{}, parquetMagic...)
+	schema := []any{[]tf{{4, "schema"}, {5, int32(len(columns))}}}
+	var chunks []any
+	for _, c := range columns {
+		repetition := int32(0)
+		if c.optional {
+			repetition = parquetOptional
+		}
+		schema = append(schema, []tf{{1, c.typ}, {3, repetition}, {4, c.name}})
+
+		start := int64(len(buf))
+		buf = append(buf, c.dictionary...)
+		dataStart := int64(len(buf))
+		buf = append(buf, c.pages...)
+		size := int64(len(buf)) - start
+		meta := []tf{{1, c.typ}, {2, []any{int32(0)}}, {3, []any{c.name}}, {4, c.codec},
+			{5, c.numValues}, {6, size}, {7, size}, {9, dataStart}}
+		if c.dictionary != nil {
+			meta = append(meta, tf{11, start})
+		}
+		chunks = append(chunks, []tf{{2, start}, {3, meta}})
+	}
+	footer := thriftEncode([]tf{{1, int32(1)}, {2, schema}, {3, numRows},
+		{4, []any{[]tf{{1, chunks}, {2, int64(len(buf))}, {3, numRows}}}}})
+	buf = append(buf, footer...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(footer)))
+	return append(buf, parquetMagic...)
+}
+
+// testParquet returns the Parquet test file, with a dictionary encoded name
+// column, a Snappy compressed double column, a gzip compressed optional
+// int32 column, a version 2 page of booleans and a zstd compressed optional
+// string column.
+func testParquet() []byte {
+	v1 := func(n, encoding int32) []tf {
+		return []tf{{1, n}, {2, encoding}, {3, int32(parquetRLE)}, {4, int32(parquetRLE)}}
+	}
+	plainStrings := func(strs ...string) []byte {
+		var b []byte
+		for _, s := range strs {
+			b = append(binary.LittleEndian.AppendUint32(b, uint32(len(s))), s...)
+		}
+		return b
+	}
+
+	var doubles []byte
+	for _, v := range []float64{1.5, 2.5, 3.5} {
+		doubles = binary.LittleEndian.AppendUint64(doubles, math.Float64bits(v))
+	}
+	// Definition levels 1 0 1, as a 4-byte length and one bit-packed group
+	ints := []byte{2, 0, 0, 0, 3, 0b101}
+	ints = binary.LittleEndian.AppendUint32(ints, 10)
+	ints = binary.LittleEndian.AppendUint32(ints, 30)
+	batch := append([]byte{2, 0, 0, 0, 3, 0b101}, plainStrings("b1", "b2")...)
+
+	return testParquetFile(3,
+		testParquetColumn{name: "name", typ: parquetByteArray, numValues: 3,
+			dictionary: parquetPage(parquetDictionaryPage, []tf{{1, int32(3)}, {2, int32(parquetPlain)}}, plainStrings("s1", "s2", "s3"), 0),
+			// Bit width 2, one bit-packed group of the indices 0 1 2
+			pages: parquetPage(parquetDataPage, v1(3, parquetRLEDictionary), []byte{2, 3, 0b00100100, 0}, 0)},
+		testParquetColumn{name: "x", typ: parquetDouble, codec: parquetSnappy, numValues: 3,
+			pages: parquetPage(parquetDataPage, v1(3, parquetPlain), doubles, parquetSnappy)},
+		testParquetColumn{name: "y", typ: parquetInt32, optional: true, codec: parquetGzip, numValues: 3,
+			pages: parquetPage(parquetDataPage, v1(3, parquetPlain), ints, parquetGzip)},
+		testParquetColumn{name: "ok", typ: parquetBoolean, numValues: 3,
+			pages: parquetPage(parquetDataPageV2, []tf{{1, int32(3)}, {2, int32(0)}, {3, int32(3)}, {4, int32(parquetPlain)},
+				{5, int32(0)}, {6, int32(0)}, {7, false}}, []byte{0b101}, 0)},
+		testParquetColumn{name: "batch", typ: parquetByteArray, optional: true, codec: parquetZstd, numValues: 3,
+			pages: parquetPage(parquetDataPage, v1(3, parquetPlain), batch, parquetZstd)},
+	)
+}
+
+// TestProcessParquet checks the encodings, compression codecs and page
+// versions of the test file.
+func TestProcessParquet(t *testing.T) {
+	file := testParquet()
+	got, X, err := ProcessParquetFrom(bytes.NewReader(file), int64(len(file)), nil)
+	if err != nil {
+		t.Fatalf("ProcessParquetFrom() error = %v", err)
+	}
+	checkColumnarData(t, got)
+	if X.At(2, 1) != 30 {
+		t.Errorf("ProcessParquetFrom() matrix = %v, want the data", X.RawMatrix().Data)
+	}
+
+	if _, _, err := ProcessParquetFrom(bytes.NewReader(file[:len(file)-1]), int64(len(file)-1), nil); err == nil {
+		t.Errorf("ProcessParquetFrom() expected an error for a truncated file")
+	}
+
+	// Pages with a negative number of values, and more values than the
+	// column chunk has left
+	for _, n := range []int32{-1, 4} {
+		levels := []byte{2, 0, 0, 0, 3, 0b101}
+		file := testParquetFile(3, testParquetColumn{name: "y", typ: parquetInt32, optional: true, numValues: 3,
+			pages: parquetPage(parquetDataPage, []tf{{1, n}, {2, int32(parquetPlain)}, {3, int32(parquetRLE)}, {4, int32(parquetRLE)}}, levels, 0)})
+		if _, _, err := ProcessParquetFrom(bytes.NewReader(file), int64(len(file)), nil); err == nil {
+			t.Errorf("ProcessParquetFrom() expected an error for a page of %d values", n)
+		}
+	}
+}
+
+// TestDecodeRLEHybrid checks RLE runs and bit-packed groups.
+func TestDecodeRLEHybrid(t *testing.T) {
+	// A run of four 5s, then a bit-packed group of 1 2 3 4 5 6 7 0
+	data := []byte{4 << 1, 5, 1<<1 | 1, 0b11010001, 0b01011000, 0b00011111}
+	got, err := decodeRLEHybrid(data, 3, 11)
+	if err != nil {
+		t.Fatalf("decodeRLEHybrid() error = %v", err)
+	}
+	if want := []int{5, 5, 5, 5, 1, 2, 3, 4, 5, 6, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeRLEHybrid() = %v, want %v", got, want)
+	}
+	if _, err := decodeRLEHybrid(data, 3, 13); err == nil {
+		t.Errorf("decodeRLEHybrid() expected an error for too few values")
+	}
+}
+
+// TestDecompress checks the compression codecs and the uncompressed size.
+func TestDecompress(t *testing.T) {
+	data := []byte("abcdabcdabcd")
+	for _, codec := range []int32{parquetUncompressed, parquetSnappy, parquetGzip, parquetZstd} {
+		page := parquetPage(parquetDataPage, nil, data, codec)
+		header, err := (&thriftReader{data: page}).readStruct()
+		if err != nil {
+			t.Fatalf("readStruct() error = %v", err)
+		}
+		size, _ := header.int(3)
+		compressed := page[len(page)-int(size):]
+		got, err := decompress(compressed, int64(codec), int64(len(data)))
+		if err != nil || string(got) != string(data) {
+			t.Errorf("decompress(codec %d) = %q, %v, want %q", codec, got, err, data)
+		}
+		if codec != parquetUncompressed {
+			if _, err := decompress(compressed, int64(codec), int64(len(data)+1)); err == nil {
+				t.Errorf("decompress(codec %d) expected an error for the wrong size", codec)
+			}
+		}
+	}
+	if _, err := decompress(data, 7, int64(len(data))); err == nil {
+		t.Errorf("decompress() expected an error for an unsupported codec")
+	}
+}
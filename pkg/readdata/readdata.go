@@ -14,12 +14,13 @@
 //
 // Description: This package processing provides functions to process CSV data
 // for PCA/PLS. It includes functionalities to read CSV files, and convert data
-// to float64.
+// to float64. Missing values are represented as NaN.
 package readdata
 
 import (
-	"encoding/csv"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"strconv"
 	"strings"
@@ -31,67 +32,371 @@ type ProcessedData struct {
 	VariableNames []string    // Variable names from the first row
 	ObjectNames   []string    // Object names from the first column
 	Data          [][]float64 // Data converted to float64
+	Metadata      []Metadata  // Non-numeric columns, e.g. class labels, if any
 }
 
 // ReadCSV reads a CSV file and returns a 2D slice of strings representing the
 // data. The first row is assumed to be headers, and the first column is assumed
 // to contain object names.
 func ReadCSV(filename string) ([][]string, error) {
+	records, _, err := ReadCSVWithOptions(filename, DefaultCSVOptions())
+	return records, err
+}
+
+// ReadCSVWithOptions reads a CSV file in the given dialect. The options are
+// returned with the detected delimiter filled in.
+func ReadCSVWithOptions(filename string, opts CSVOptions) ([][]string, CSVOptions, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		return nil, err
+		return nil, opts, err
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	return reader.ReadAll()
+	return ReadCSVFrom(file, opts)
+}
+
+// ReadCSVFrom reads CSV data in the given dialect from r, e.g. standard input
+// or data held in memory. The options are returned with the detected
+// delimiter filled in.
+func ReadCSVFrom(r io.Reader, opts CSVOptions) ([][]string, CSVOptions, error) {
+	reader, opts, err := opts.newReader(r)
+	if err != nil {
+		return nil, opts, err
+	}
+	records, err := reader.ReadAll()
+	return records, opts, err
 }
 
 // ProcessCSV reads data from a CSV file and returns variable names, object
 // names, and the data as floats. The first row is assumed to contain variable
 // names, and the first column in each row is assumed to contain object names.
 func ProcessCSV(filename string) (ProcessedData, error) {
-	records, err := ReadCSV(filename)
+	return ProcessCSVWithOptions(filename, DefaultCSVOptions())
+}
+
+// ProcessCSVWithOptions reads data like ProcessCSV from a CSV file in the
+// given dialect.
+func ProcessCSVWithOptions(filename string, opts CSVOptions) (ProcessedData, error) {
+	file, err := os.Open(filename)
 	if err != nil {
 		return ProcessedData{}, err
 	}
+	defer file.Close()
+
+	return ProcessCSVFrom(file, opts)
+}
+
+// ProcessCSVFrom reads data like ProcessCSV from r in the given dialect.
+// A cell that is not a number, or a row with the wrong number of fields,
+// gives a *ParseError, or ParseErrors and the data with opts.CollectErrors.
+func ProcessCSVFrom(r io.Reader, opts CSVOptions) (ProcessedData, error) {
+	reader, opts, err := opts.newReader(r)
+	if err != nil {
+		return ProcessedData{}, err
+	}
+	reader.FieldsPerRecord = -1 // Checked by processRecords, with the line
+
+	var records [][]string
+	var lines []int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return ProcessedData{}, err
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+
+	// Quoted fields may span lines, so count the line breaks before a field
+	fieldLine := func(i, field int) int {
+		line := lines[i]
+		for _, f := range records[i][:field] {
+			line += strings.Count(f, "\n")
+		}
+		return line
+	}
+	return processRecords(records, fieldLine, opts)
+}
 
+// processRecords converts records, with variable names in the first row and
+// object names in the first column, to ProcessedData. The named metadata
+// columns are kept as Metadata. Parse errors give the line of a field in
+// a record from line.
+func processRecords(records [][]string, line func(i, field int) int, opts CSVOptions) (ProcessedData, error) {
 	// Check for sufficient data
 	if len(records) < 2 || len(records[0]) < 2 {
-		return ProcessedData{}, fmt.Errorf("CSV file must contain at least one row and one column of data")
+		return ProcessedData{}, fmt.Errorf("data must contain at least one row and one column of data")
 	}
 
-	variableNames := records[0][1:] // Skip the first cell
+	// Find the metadata columns
+	isMetadata, metadataCols, err := metadataColumns(records[0], opts.Metadata)
+	if err != nil {
+		return ProcessedData{}, err
+	}
+
+	var variableNames []string
+	for j, name := range records[0][1:] { // Skip the first cell
+		if !isMetadata[j+1] {
+			variableNames = append(variableNames, cleanName(name))
+		}
+	}
 	var objectNames []string
-	var floatData [][]float64 // Corrected type to [][]float64
+	var floatData [][]float64
+	metadataValues := make([][]string, len(metadataCols))
+	parser := recordParser{header: records[0], isMetadata: isMetadata, decimal: opts.DecimalSeparator(), collect: opts.CollectErrors}
 
-	for _, record := range records[1:] { // Skip the first row (header)
-		objectNames = append(objectNames, record[0])
-		floatRow, err := convertToFloats(record[1:]) // Skip the first column (object name)
+	for i, record := range records[1:] { // Skip the first row (header)
+		floatRow, ok, err := parser.parse(nil, record, func(field int) int { return line(i+1, field) })
 		if err != nil {
 			return ProcessedData{}, err
+		} else if !ok {
+			continue
 		}
-		floatData = append(floatData, floatRow) // Append floatRow correctly
+		objectNames = append(objectNames, cleanName(record[0]))
+		for k, col := range metadataCols {
+			metadataValues[k] = append(metadataValues[k], record[col])
+		}
+		floatData = append(floatData, floatRow)
+	}
+	if len(floatData) == 0 && parser.err() != nil {
+		return ProcessedData{}, parser.err()
+	}
+
+	var metadata []Metadata
+	for k, col := range metadataCols {
+		metadata = append(metadata, newMetadata(cleanName(records[0][col]), metadataValues[k]))
 	}
 
 	return ProcessedData{
 		VariableNames: variableNames,
 		ObjectNames:   objectNames,
 		Data:          floatData,
-	}, nil
+		Metadata:      metadata,
+	}, parser.err()
+}
+
+// metadataColumns finds the named metadata columns in a header row. It
+// returns whether each column holds metadata, and the metadata columns in
+// the order of the names.
+func metadataColumns(header []string, names []string) ([]bool, []int, error) {
+	isMetadata := make([]bool, len(header))
+	var cols []int
+	for _, name := range names {
+		col := -1
+		for j, h := range header[1:] {
+			if cleanName(h) == name {
+				col = j + 1
+				break
+			}
+		}
+		if col < 0 {
+			return nil, nil, fmt.Errorf("metadata column %q not found", name)
+		}
+		isMetadata[col] = true
+		cols = append(cols, col)
+	}
+	return isMetadata, cols, nil
+}
+
+// cleanName removes surrounding spaces and quotes from a variable or object
+// name, e.g. quotes left in headers exported with single quotes.
+func cleanName(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) >= 2 && (name[0] == '"' || name[0] == '\'') && name[len(name)-1] == name[0] {
+		name = strings.TrimSpace(name[1 : len(name)-1])
+	}
+	return name
 }
 
 // convertToFloats converts a slice of strings to a slice of float64.
-// An error is returned if any string cannot be converted to a float.
+// Missing values (see isMissing) are converted to NaN. An error is returned
+// if any other string cannot be converted to a float.
 func convertToFloats(strs []string) ([]float64, error) {
 	var floats []float64
 	for _, str := range strs {
-		trimmedStr := strings.TrimSpace(str) // Trim spaces from the string
-		f, err := strconv.ParseFloat(trimmedStr, 64)
+		f, err := parseCell(str, '.')
 		if err != nil {
-			return nil, fmt.Errorf("error parsing float in convertToFloats: %v", err)
+			return nil, fmt.Errorf("error parsing float in convertToFloats: %q: %v", str, err)
 		}
 		floats = append(floats, f)
 	}
 	return floats, nil
 }
+
+// parseCell converts a cell to a float, with NaN for missing values. Decimal
+// points are always accepted. The error is strconv.ErrSyntax or
+// strconv.ErrRange.
+func parseCell(str string, decimal rune) (float64, error) {
+	trimmedStr := strings.TrimSpace(str) // Trim spaces from the string
+	if isMissing(trimmedStr) {
+		return math.NaN(), nil
+	}
+	if decimal != '.' {
+		trimmedStr = strings.Replace(trimmedStr, string(decimal), ".", 1)
+	}
+	f, err := strconv.ParseFloat(trimmedStr, 64)
+	if numErr, ok := err.(*strconv.NumError); ok {
+		return 0, numErr.Err
+	}
+	return f, err
+}
+
+// isMissing reports whether a (trimmed) cell denotes a missing value. Blank
+// cells and the strings NA, N/A and NaN (in any case) are treated as missing.
+func isMissing(str string) bool {
+	switch strings.ToUpper(str) {
+	case "", "NA", "N/A", "NAN":
+		return true
+	}
+	return false
+}
+
+// ExtractColumn removes the named variable from the data and returns its
+// values together with the remaining data.
+func (d ProcessedData) ExtractColumn(name string) ([]float64, ProcessedData, error) {
+	col := -1
+	for j, v := range d.VariableNames {
+		if v == name {
+			col = j
+			break
+		}
+	}
+	if col < 0 {
+		return nil, ProcessedData{}, fmt.Errorf("variable %q not found", name)
+	}
+
+	values := make([]float64, len(d.Data))
+	remaining := ProcessedData{
+		VariableNames: append(append([]string{}, d.VariableNames[:col]...), d.VariableNames[col+1:]...),
+		ObjectNames:   d.ObjectNames,
+		Data:          make([][]float64, len(d.Data)),
+		Metadata:      d.Metadata,
+	}
+	for i, row := range d.Data {
+		if col >= len(row) {
+			return nil, ProcessedData{}, fmt.Errorf("row %d has too few values", i+1)
+		}
+		values[i] = row[col]
+		remaining.Data[i] = append(append([]float64{}, row[:col]...), row[col+1:]...)
+	}
+	return values, remaining, nil
+}
+
+// ExtractRow removes the named object from the data and returns its values
+// together with the remaining data. This is used for rows holding settings
+// for each variable, e.g. weights, rather than measurements.
+func (d ProcessedData) ExtractRow(name string) ([]float64, ProcessedData, error) {
+	row := -1
+	for i, o := range d.ObjectNames {
+		if o == name {
+			row = i
+			break
+		}
+	}
+	if row < 0 {
+		return nil, ProcessedData{}, fmt.Errorf("object %q not found", name)
+	}
+
+	rows := make([]int, 0, len(d.Data)-1)
+	for i := range d.Data {
+		if i != row {
+			rows = append(rows, i)
+		}
+	}
+	remaining := ProcessedData{
+		VariableNames: d.VariableNames,
+		ObjectNames:   append(append([]string{}, d.ObjectNames[:row]...), d.ObjectNames[row+1:]...),
+		Data:          append(append([][]float64{}, d.Data[:row]...), d.Data[row+1:]...),
+		Metadata:      selectMetadataRows(d.Metadata, rows),
+	}
+	return d.Data[row], remaining, nil
+}
+
+// ReadWeights reads variable weights from a CSV file with a variable name
+// and a weight on each line. A first line that does not hold a number, e.g.
+// "variable,weight", is taken as a header. The returned weights are in the
+// order of variableNames, and variables not in the file get weight 1.
+func ReadWeights(filename string, variableNames []string) ([]float64, error) {
+	records, err := ReadCSV(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int, len(variableNames))
+	weights := make([]float64, len(variableNames))
+	for j, name := range variableNames {
+		index[name] = j
+		weights[j] = 1
+	}
+	for i, record := range records {
+		if len(record) != 2 {
+			return nil, fmt.Errorf("line %d of %s must have a variable name and a weight", i+1, filename)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			if i == 0 {
+				continue // Header
+			}
+			return nil, fmt.Errorf("error parsing weight on line %d of %s: %v", i+1, filename, err)
+		}
+		j, ok := index[strings.TrimSpace(record[0])]
+		if !ok {
+			return nil, fmt.Errorf("variable %q in %s not found in the data", record[0], filename)
+		}
+		weights[j] = w
+	}
+	return weights, nil
+}
+
+// DefaultBlock is the block of variables not assigned to a named block.
+const DefaultBlock = "other"
+
+// BlocksFromPrefix assigns variables to blocks by the prefix of their names,
+// e.g. with separator ":" the variable "NIR:1200" belongs to block "NIR".
+// Variables without the separator belong to DefaultBlock.
+func BlocksFromPrefix(variableNames []string, sep string) []string {
+	blocks := make([]string, len(variableNames))
+	for j, name := range variableNames {
+		blocks[j] = DefaultBlock
+		if prefix, _, found := strings.Cut(name, sep); found && sep != "" {
+			blocks[j] = prefix
+		}
+	}
+	return blocks
+}
+
+// ReadBlocks reads a block definition from a CSV file with a variable name
+// and a block name on each line. A first line "variable,block" is taken as a
+// header. The returned blocks are in the order of variableNames, and
+// variables not in the file belong to DefaultBlock.
+func ReadBlocks(filename string, variableNames []string) ([]string, error) {
+	records, err := ReadCSV(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int, len(variableNames))
+	blocks := make([]string, len(variableNames))
+	for j, name := range variableNames {
+		index[name] = j
+		blocks[j] = DefaultBlock
+	}
+	for i, record := range records {
+		if len(record) != 2 {
+			return nil, fmt.Errorf("line %d of %s must have a variable name and a block name", i+1, filename)
+		}
+		name, block := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if i == 0 && strings.EqualFold(name, "variable") && strings.EqualFold(block, "block") {
+			continue // Header
+		}
+		j, ok := index[name]
+		if !ok {
+			return nil, fmt.Errorf("variable %q in %s not found in the data", name, filename)
+		}
+		blocks[j] = block
+	}
+	return blocks, nil
+}
@@ -16,8 +16,15 @@
 package readdata
 
 import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/bitjungle/goLV/pkg/utils"
 )
 
 // TestProcessCSV tests the ProcessCSV function using main_test_data.csv.
@@ -51,3 +58,151 @@ func TestProcessCSV(t *testing.T) {
 		t.Errorf("ProcessCSV() got = %v, want %v", got, want)
 	}
 }
+
+// TestProcessCSVFrom checks reading CSV data from a reader.
+func TestProcessCSVFrom(t *testing.T) {
+	r := strings.NewReader(",x,y\no1,1,2\no2,3,\n")
+	got, err := ProcessCSVFrom(r, DefaultCSVOptions())
+	if err != nil {
+		t.Fatalf("ProcessCSVFrom() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.VariableNames, []string{"x", "y"}) || !reflect.DeepEqual(got.ObjectNames, []string{"o1", "o2"}) {
+		t.Errorf("ProcessCSVFrom() names = %v, %v", got.VariableNames, got.ObjectNames)
+	}
+	if got.Data[1][0] != 3 || !math.IsNaN(got.Data[1][1]) {
+		t.Errorf("ProcessCSVFrom() data = %v", got.Data)
+	}
+}
+
+// TestConvertToFloatsMissing checks that blank and NA cells become NaN.
+func TestConvertToFloatsMissing(t *testing.T) {
+	got, err := convertToFloats([]string{"1.5", "", " NA ", "nan", "n/a", "-2"})
+	if err != nil {
+		t.Fatalf("convertToFloats() error = %v, wantErr nil", err)
+	}
+	want := []float64{1.5, math.NaN(), math.NaN(), math.NaN(), math.NaN(), -2}
+	if len(got) != len(want) {
+		t.Fatalf("convertToFloats() got %d values, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if math.IsNaN(want[i]) != math.IsNaN(got[i]) || (!math.IsNaN(want[i]) && got[i] != want[i]) {
+			t.Errorf("convertToFloats() value %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := convertToFloats([]string{"1.0", "abc"}); err == nil {
+		t.Errorf("convertToFloats() expected an error for a non-numeric cell")
+	}
+}
+
+// TestReadWeights checks reading weights by variable name, with a header line.
+func TestReadWeights(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "weights.csv")
+	if err := os.WriteFile(filename, []byte("variable,weight\nc,0.5\na,2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadWeights(filename, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("ReadWeights() error = %v", err)
+	}
+	if want := []float64{2, 1, 0.5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadWeights() = %v, want %v", got, want)
+	}
+	if _, err := ReadWeights(filename, []string{"a", "b"}); err == nil {
+		t.Errorf("ReadWeights() expected an error for an unknown variable")
+	}
+}
+
+// TestExtractRow checks that a named row is removed from the data.
+func TestExtractRow(t *testing.T) {
+	d := ProcessedData{
+		VariableNames: []string{"a", "b"},
+		ObjectNames:   []string{"o1", "weight", "o2"},
+		Data:          [][]float64{{1, 2}, {3, 4}, {5, 6}},
+	}
+	values, rest, err := d.ExtractRow("weight")
+	if err != nil {
+		t.Fatalf("ExtractRow() error = %v", err)
+	}
+	if !reflect.DeepEqual(values, []float64{3, 4}) || !reflect.DeepEqual(rest.ObjectNames, []string{"o1", "o2"}) ||
+		!reflect.DeepEqual(rest.Data, [][]float64{{1, 2}, {5, 6}}) {
+		t.Errorf("ExtractRow() = %v, %+v", values, rest)
+	}
+}
+
+// TestBlocks checks assigning variables to blocks by prefix and from a file.
+func TestBlocks(t *testing.T) {
+	names := []string{"NIR:1200", "NIR:1202", "Raman:400", "Temperature"}
+	want := []string{"NIR", "NIR", "Raman", DefaultBlock}
+	if got := BlocksFromPrefix(names, ":"); !reflect.DeepEqual(got, want) {
+		t.Errorf("BlocksFromPrefix() = %v, want %v", got, want)
+	}
+
+	filename := filepath.Join(t.TempDir(), "blocks.csv")
+	if err := os.WriteFile(filename, []byte("variable,block\nNIR:1200,NIR\nNIR:1202,NIR\nRaman:400,Raman\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadBlocks(filename, names)
+	if err != nil {
+		t.Fatalf("ReadBlocks() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadBlocks() = %v, want %v", got, want)
+	}
+}
+
+// benchmarkRows and benchmarkCols are the size of the benchmark data, similar
+// to a large process dataset.
+const benchmarkRows, benchmarkCols = 20000, 50
+
+// BenchmarkProcessCSV measures reading all records as strings, converting
+// them to floats and copying the data to a matrix.
+func BenchmarkProcessCSV(b *testing.B) {
+	data := testCSV(benchmarkRows, benchmarkCols)
+	opts := CSVOptions{Metadata: []string{"class"}}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		records, err := ProcessCSVFrom(bytes.NewReader(data), opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := utils.SliceToDense(records.Data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkStreamCSV measures parsing the records straight into a matrix.
+func BenchmarkStreamCSV(b *testing.B) {
+	data := testCSV(benchmarkRows, benchmarkCols)
+	opts := CSVOptions{Metadata: []string{"class"}}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := StreamCSV(bytes.NewReader(data), opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkStreamCSVFile measures parsing a file straight into a matrix,
+// allocated once from the estimated number of rows.
+func BenchmarkStreamCSVFile(b *testing.B) {
+	data := testCSV(benchmarkRows, benchmarkCols)
+	filename := filepath.Join(b.TempDir(), "data.csv")
+	if err := os.WriteFile(filename, data, 0o644); err != nil {
+		b.Fatal(err)
+	}
+	opts := CSVOptions{Metadata: []string{"class"}}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := StreamCSVFile(filename, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains selection of variables and objects by
+// name, index range, numeric range or regular expression.
+package readdata
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Selection selects variables or objects by patterns. When Include is empty
+// all names are included. Names matching Exclude are then removed.
+//
+// Each pattern is one of:
+//
+//	name        the exact name
+//	#i, #i-j    the i-th name, or the i-th to the j-th name, counting from 1
+//	x, lo-hi    names that are numbers equal to x or from lo to hi, e.g.
+//	            wavelengths 1100-1700
+//	re:expr     names matching the regular expression expr
+type Selection struct {
+	Include []string
+	Exclude []string
+}
+
+// IsEmpty reports whether the selection includes all names.
+func (s Selection) IsEmpty() bool {
+	return len(s.Include) == 0 && len(s.Exclude) == 0
+}
+
+// Select returns the indices of the selected names, in their original order.
+func (s Selection) Select(names []string) ([]int, error) {
+	include := make([]bool, len(names))
+	if len(s.Include) == 0 {
+		for i := range include {
+			include[i] = true
+		}
+	}
+	for _, pattern := range s.Include {
+		matches, err := match(pattern, names)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range matches {
+			include[i] = true
+		}
+	}
+	for _, pattern := range s.Exclude {
+		matches, err := match(pattern, names)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range matches {
+			include[i] = false
+		}
+	}
+
+	var selected []int
+	for i, ok := range include {
+		if ok {
+			selected = append(selected, i)
+		}
+	}
+	return selected, nil
+}
+
+// match returns the indices of the names matching a pattern. A pattern that
+// matches no names is an error, as it is most likely a typing error.
+func match(pattern string, names []string) ([]int, error) {
+	var matches []int
+	switch {
+	case strings.HasPrefix(pattern, "re:"):
+		re, err := regexp.Compile(pattern[3:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression in %q: %v", pattern, err)
+		}
+		for i, name := range names {
+			if re.MatchString(name) {
+				matches = append(matches, i)
+			}
+		}
+	case strings.HasPrefix(pattern, "#"):
+		from, to, err := parseRange(pattern[1:])
+		if err != nil || from != float64(int(from)) || to != float64(int(to)) {
+			return nil, fmt.Errorf("invalid index range %q", pattern)
+		}
+		for i := int(from); i <= int(to); i++ {
+			if i < 1 || i > len(names) {
+				return nil, fmt.Errorf("index %d in %q is out of range 1-%d", i, pattern, len(names))
+			}
+			matches = append(matches, i-1)
+		}
+	default:
+		for i, name := range names {
+			if name == pattern {
+				matches = append(matches, i)
+			}
+		}
+		if len(matches) > 0 {
+			break // Exact names take precedence over numeric ranges
+		}
+		if from, to, err := parseRange(pattern); err == nil {
+			for i, name := range names {
+				v, err := strconv.ParseFloat(strings.TrimSpace(name), 64)
+				if err == nil && v >= from && v <= to {
+					matches = append(matches, i)
+				}
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%q does not match any names", pattern)
+	}
+	return matches, nil
+}
+
+// parseRange parses a single number or a range lo-hi. A leading minus sign
+// is part of the first number.
+func parseRange(s string) (float64, float64, error) {
+	s = strings.TrimSpace(s)
+	cut := -1
+	if len(s) > 1 {
+		if k := strings.Index(s[1:], "-"); k >= 0 {
+			cut = k + 1
+		}
+	}
+	if cut < 0 {
+		v, err := strconv.ParseFloat(s, 64)
+		return v, v, err
+	}
+
+	from, err := strconv.ParseFloat(strings.TrimSpace(s[:cut]), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := strconv.ParseFloat(strings.TrimSpace(s[cut+1:]), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if to < from {
+		return 0, 0, fmt.Errorf("range %q is decreasing", s)
+	}
+	return from, to, nil
+}
+
+// SelectVariables returns the data with only the selected variables, and the
+// names of the excluded variables.
+func (d ProcessedData) SelectVariables(s Selection) (ProcessedData, []string, error) {
+	selected, err := s.Select(d.VariableNames)
+	if err != nil {
+		return ProcessedData{}, nil, fmt.Errorf("error selecting variables: %v", err)
+	}
+	if len(selected) == 0 {
+		return ProcessedData{}, nil, fmt.Errorf("no variables selected")
+	}
+
+	result := ProcessedData{
+		VariableNames: make([]string, len(selected)),
+		ObjectNames:   d.ObjectNames,
+		Data:          make([][]float64, len(d.Data)),
+		Metadata:      d.Metadata,
+	}
+	for k, j := range selected {
+		result.VariableNames[k] = d.VariableNames[j]
+	}
+	for i, row := range d.Data {
+		result.Data[i] = make([]float64, len(selected))
+		for k, j := range selected {
+			if j >= len(row) {
+				return ProcessedData{}, nil, fmt.Errorf("row %d has too few values", i+1)
+			}
+			result.Data[i][k] = row[j]
+		}
+	}
+	return result, excludedNames(d.VariableNames, selected), nil
+}
+
+// SelectObjects returns the data with only the selected objects, and the
+// names of the excluded objects.
+func (d ProcessedData) SelectObjects(s Selection) (ProcessedData, []string, error) {
+	selected, err := s.Select(d.ObjectNames)
+	if err != nil {
+		return ProcessedData{}, nil, fmt.Errorf("error selecting objects: %v", err)
+	}
+	if len(selected) == 0 {
+		return ProcessedData{}, nil, fmt.Errorf("no objects selected")
+	}
+
+	result := ProcessedData{
+		VariableNames: d.VariableNames,
+		ObjectNames:   make([]string, len(selected)),
+		Data:          make([][]float64, len(selected)),
+		Metadata:      selectMetadataRows(d.Metadata, selected),
+	}
+	for k, i := range selected {
+		result.ObjectNames[k] = d.ObjectNames[i]
+		result.Data[k] = d.Data[i]
+	}
+	return result, excludedNames(d.ObjectNames, selected), nil
+}
+
+// excludedNames returns the names whose indices are not selected.
+func excludedNames(names []string, selected []int) []string {
+	var excluded []string
+	k := 0
+	for i, name := range names {
+		if k < len(selected) && selected[k] == i {
+			k++
+			continue
+		}
+		excluded = append(excluded, name)
+	}
+	return excluded
+}
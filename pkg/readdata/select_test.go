@@ -0,0 +1,81 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the selection of variables and objects.
+package readdata
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestSelect checks the selection patterns.
+func TestSelect(t *testing.T) {
+	names := []string{"ID", "1000", "1100", "1400.5", "1700", "1800", "Temp"}
+
+	testCases := []struct {
+		name    string
+		sel     Selection
+		want    []int
+		wantErr bool
+	}{
+		{"all", Selection{}, []int{0, 1, 2, 3, 4, 5, 6}, false},
+		{"names", Selection{Include: []string{"Temp", "ID"}}, []int{0, 6}, false},
+		{"numeric range", Selection{Include: []string{"1100-1700"}}, []int{2, 3, 4}, false},
+		{"index range", Selection{Include: []string{"#2-3"}, Exclude: []string{"1000"}}, []int{2}, false},
+		{"regex", Selection{Exclude: []string{"re:^[A-Z]"}}, []int{1, 2, 3, 4, 5}, false},
+		{"no match", Selection{Exclude: []string{"Pressure"}}, nil, true},
+		{"index out of range", Selection{Include: []string{"#5-9"}}, nil, true},
+		{"bad regex", Selection{Include: []string{"re:("}}, nil, true},
+	}
+	for _, tc := range testCases {
+		got, err := tc.sel.Select(names)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: Select() error = %v, wantErr %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: Select() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestSelectVariablesAndObjects checks that the data follows the selection
+// and that the excluded names are returned.
+func TestSelectVariablesAndObjects(t *testing.T) {
+	d := ProcessedData{
+		VariableNames: []string{"a", "b", "c"},
+		ObjectNames:   []string{"o1", "o2", "o3"},
+		Data:          [][]float64{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}},
+	}
+	vars, excluded, err := d.SelectVariables(Selection{Exclude: []string{"b"}})
+	if err != nil {
+		t.Fatalf("SelectVariables() error = %v", err)
+	}
+	if !reflect.DeepEqual(vars.Data, [][]float64{{1, 3}, {4, 6}, {7, 9}}) || !reflect.DeepEqual(excluded, []string{"b"}) {
+		t.Errorf("SelectVariables() = %v, excluded %v", vars.Data, excluded)
+	}
+
+	objs, excluded, err := d.SelectObjects(Selection{Include: []string{"re:o[13]"}})
+	if err != nil {
+		t.Fatalf("SelectObjects() error = %v", err)
+	}
+	if !reflect.DeepEqual(objs.ObjectNames, []string{"o1", "o3"}) || !reflect.DeepEqual(excluded, []string{"o2"}) {
+		t.Errorf("SelectObjects() = %v, excluded %v", objs.ObjectNames, excluded)
+	}
+
+	if _, _, err := d.SelectVariables(Selection{Exclude: []string{"#1-3"}}); err == nil {
+		t.Errorf("SelectVariables() expected an error when no variables are selected")
+	}
+}
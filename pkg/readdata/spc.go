@@ -0,0 +1,203 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains a reader for Galactic (Thermo) SPC spectra.
+package readdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// SPC file type flags, the first byte of the header.
+const (
+	spcShortY  = 0x01 // Y values are 16-bit integers
+	spcMulti   = 0x04 // The file holds several subfiles
+	spcXYXYS   = 0x40 // Each subfile has its own x values
+	spcXValues = 0x80 // The x values follow the header, rather than being evenly spaced
+)
+
+// SPC format versions, the second byte of the header.
+const (
+	spcNewLSB = 0x4B // New format, little endian
+	spcNewMSB = 0x4C // New format, big endian
+	spcOld    = 0x4D // Old format
+)
+
+const (
+	spcHeaderSize    = 512
+	spcSubheaderSize = 32
+	spcFloatExponent = -128 // Exponent marking IEEE float y values
+)
+
+// ReadSPC reads the spectra in a Galactic SPC file of the new, little endian
+// format, with one spectrum for each subfile. The x values are evenly spaced
+// from the first to the last x value of the header, unless the file stores
+// them. Integer y values are scaled by the exponent of the file or subfile.
+// The title is the comment of the header.
+func ReadSPC(r io.Reader) ([]Spectrum, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < spcHeaderSize {
+		return nil, fmt.Errorf("file is too short for an SPC header")
+	}
+
+	flags, version := data[0], data[1]
+	switch version {
+	case spcNewLSB:
+	case spcNewMSB:
+		return nil, fmt.Errorf("big endian SPC files are not supported")
+	case spcOld:
+		return nil, fmt.Errorf("old format SPC files are not supported")
+	default:
+		return nil, fmt.Errorf("not an SPC file (version byte %#x)", version)
+	}
+
+	le := binary.LittleEndian
+	exponent := int8(data[3])
+	nPoints := int(int32(le.Uint32(data[4:])))
+	firstX := math.Float64frombits(le.Uint64(data[8:]))
+	lastX := math.Float64frombits(le.Uint64(data[16:]))
+	nSub := int(int32(le.Uint32(data[24:])))
+	title := spcString(data[88:218])
+	if flags&spcMulti == 0 {
+		nSub = 1
+	}
+	if nSub < 1 || (nPoints < 1 && flags&spcXYXYS == 0) {
+		return nil, fmt.Errorf("invalid SPC header with %d points and %d subfiles", nPoints, nSub)
+	}
+	// Check the counts against the file size before allocating: each
+	// subfile has a subheader, and each y value takes at least 2 bytes
+	remaining := len(data) - spcHeaderSize
+	if nSub > remaining/spcSubheaderSize || (flags&spcXYXYS == 0 && nPoints > remaining/2) {
+		return nil, fmt.Errorf("SPC header with %d points and %d subfiles does not fit in a file of %d bytes", nPoints, nSub, len(data))
+	}
+
+	offset := spcHeaderSize
+	read := func(n int) ([]byte, error) {
+		if n < 0 || offset+n > len(data) {
+			return nil, fmt.Errorf("file is truncated at byte %d", offset)
+		}
+		b := data[offset : offset+n]
+		offset += n
+		return b, nil
+	}
+
+	var x []float64
+	if flags&spcXYXYS == 0 {
+		if flags&spcXValues != 0 {
+			b, err := read(4 * nPoints)
+			if err != nil {
+				return nil, err
+			}
+			x = spcFloats(b)
+		} else {
+			x = make([]float64, nPoints)
+			for i := range x {
+				x[i] = firstX
+				if nPoints > 1 {
+					x[i] += float64(i) * (lastX - firstX) / float64(nPoints-1)
+				}
+			}
+		}
+	}
+
+	spectra := make([]Spectrum, nSub)
+	for k := range spectra {
+		sub, err := read(spcSubheaderSize)
+		if err != nil {
+			return nil, err
+		}
+		subExponent := exponent
+		if flags&spcMulti != 0 {
+			subExponent = int8(sub[1])
+		}
+
+		subX := x
+		if flags&spcXYXYS != 0 {
+			n := int(int32(le.Uint32(sub[16:])))
+			b, err := read(4 * n)
+			if err != nil {
+				return nil, err
+			}
+			subX = spcFloats(b)
+		}
+
+		y, err := spcYValues(read, len(subX), flags, subExponent)
+		if err != nil {
+			return nil, err
+		}
+		spectra[k] = Spectrum{Title: title, X: subX, Y: y}
+	}
+	return spectra, nil
+}
+
+// spcYValues reads n y values, as floats or as integers scaled by the
+// exponent.
+func spcYValues(read func(int) ([]byte, error), n int, flags byte, exponent int8) ([]float64, error) {
+	le := binary.LittleEndian
+	var y []float64
+	switch {
+	case exponent == spcFloatExponent:
+		b, err := read(4 * n)
+		if err != nil {
+			return nil, err
+		}
+		return spcFloats(b), nil
+	case flags&spcShortY != 0:
+		b, err := read(2 * n)
+		if err != nil {
+			return nil, err
+		}
+		scale := math.Ldexp(1, int(exponent)-16)
+		y = make([]float64, n)
+		for i := range y {
+			y[i] = float64(int16(le.Uint16(b[2*i:]))) * scale
+		}
+	default:
+		b, err := read(4 * n)
+		if err != nil {
+			return nil, err
+		}
+		scale := math.Ldexp(1, int(exponent)-32)
+		y = make([]float64, n)
+		for i := range y {
+			y[i] = float64(int32(le.Uint32(b[4*i:]))) * scale
+		}
+	}
+	return y, nil
+}
+
+// spcFloats converts little endian 32-bit floats.
+func spcFloats(b []byte) []float64 {
+	floats := make([]float64, len(b)/4)
+	for i := range floats {
+		floats[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:])))
+	}
+	return floats
+}
+
+// spcString returns the text of a zero terminated string field.
+func spcString(b []byte) string {
+	if k := bytes.IndexByte(b, 0); k >= 0 {
+		b = b[:k]
+	}
+	return strings.TrimSpace(string(b))
+}
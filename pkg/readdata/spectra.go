@@ -0,0 +1,177 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains loading of spectra from spectral file
+// formats (JCAMP-DX and SPC) into one data matrix.
+package readdata
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Spectrum is a single spectrum read from a spectral file.
+type Spectrum struct {
+	Title string    // Title or comment stored in the file, if any
+	X     []float64 // Wavelengths, wavenumbers or other x-axis values
+	Y     []float64 // Intensities
+}
+
+// Object naming of spectra.
+const (
+	SpectrumNameFile  = "file"  // The file name without extension
+	SpectrumNameTitle = "title" // The title stored in the file, or the file name if none
+)
+
+// SpectraOptions controls how spectral files are combined into data.
+type SpectraOptions struct {
+	NameBy string // One of the SpectrumName constants, SpectrumNameFile if empty
+}
+
+// IsSpectralFile reports whether the file has the extension of a supported
+// spectral file format: .jdx, .dx or .jcamp for JCAMP-DX, and .spc for SPC.
+func IsSpectralFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jdx", ".dx", ".jcamp", ".spc":
+		return true
+	}
+	return false
+}
+
+// ReadSpectrumFile reads the spectra in a JCAMP-DX or SPC file, chosen by
+// the file extension.
+func ReadSpectrumFile(filename string) ([]Spectrum, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var spectra []Spectrum
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jdx", ".dx", ".jcamp":
+		spectra, err = ReadJCAMP(file)
+	case ".spc":
+		spectra, err = ReadSPC(file)
+	default:
+		return nil, fmt.Errorf("%s is not a JCAMP-DX or SPC file", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", filename, err)
+	}
+	return spectra, nil
+}
+
+// ProcessSpectra reads spectral files into one data matrix, with a row for
+// each spectrum and the x-axis values as variable names. Paths that are
+// directories are expanded to the spectral files they contain, in order of
+// name. All spectra must have the same x-axis.
+//
+// Objects are named by the file name or the stored title (see
+// SpectraOptions). Files holding several spectra get the spectrum number
+// appended to the name, e.g. "run1#2".
+func ProcessSpectra(paths []string, opts SpectraOptions) (ProcessedData, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return ProcessedData{}, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return ProcessedData{}, err
+		}
+		var found []string
+		for _, e := range entries {
+			if !e.IsDir() && IsSpectralFile(e.Name()) {
+				found = append(found, filepath.Join(p, e.Name()))
+			}
+		}
+		sort.Strings(found)
+		files = append(files, found...)
+	}
+	if len(files) == 0 {
+		return ProcessedData{}, fmt.Errorf("no spectral files found")
+	}
+
+	var spectra []Spectrum
+	var names []string
+	for _, f := range files {
+		s, err := ReadSpectrumFile(f)
+		if err != nil {
+			return ProcessedData{}, err
+		}
+		base := strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+		for i, spectrum := range s {
+			name := base
+			if opts.NameBy == SpectrumNameTitle && spectrum.Title != "" {
+				name = spectrum.Title
+			}
+			if len(s) > 1 {
+				name = fmt.Sprintf("%s#%d", name, i+1)
+			}
+			names = append(names, name)
+		}
+		spectra = append(spectra, s...)
+	}
+	return SpectraToData(spectra, names)
+}
+
+// SpectraToData combines spectra with the same x-axis into data, with the
+// given object names and the x-axis values as variable names.
+func SpectraToData(spectra []Spectrum, objectNames []string) (ProcessedData, error) {
+	if len(spectra) == 0 {
+		return ProcessedData{}, fmt.Errorf("no spectra")
+	}
+	if len(objectNames) != len(spectra) {
+		return ProcessedData{}, fmt.Errorf("%d object names for %d spectra", len(objectNames), len(spectra))
+	}
+
+	x := spectra[0].X
+	if len(x) == 0 {
+		return ProcessedData{}, fmt.Errorf("spectrum %s has no points", objectNames[0])
+	}
+	tol := 1e-6 * (math.Abs(x[len(x)-1]-x[0]) + 1e-12) // Allow rounding differences
+	data := make([][]float64, len(spectra))
+	for i, s := range spectra {
+		if len(s.X) != len(x) || len(s.Y) != len(x) {
+			return ProcessedData{}, fmt.Errorf("spectrum %s has %d points, %s has %d", objectNames[i], len(s.Y), objectNames[0], len(x))
+		}
+		for j := range x {
+			if math.Abs(s.X[j]-x[j]) > tol {
+				return ProcessedData{}, fmt.Errorf("spectrum %s has a different x-axis than %s, at point %d: %v and %v", objectNames[i], objectNames[0], j+1, s.X[j], x[j])
+			}
+		}
+		data[i] = s.Y
+	}
+
+	variableNames := make([]string, len(x))
+	for j, v := range x {
+		variableNames[j] = strconv.FormatFloat(v, 'g', 10, 64)
+	}
+	return ProcessedData{
+		VariableNames: variableNames,
+		ObjectNames:   objectNames,
+		Data:          data,
+	}, nil
+}
@@ -0,0 +1,211 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the JCAMP-DX and SPC readers.
+package readdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// jcampAFFN is a JCAMP-DX spectrum with a plain XYDATA table.
+const jcampAFFN = `##TITLE=Sample A
+##JCAMP-DX=4.24
+##DATA TYPE=INFRARED SPECTRUM
+##XUNITS=1/CM
+##FIRSTX=1000
+##LASTX=1004
+##XFACTOR=1
+##YFACTOR=0.5
+##NPOINTS=5
+##XYDATA=(X++(Y..Y))
+1000 2 4 6 $$ first line
+1003 8 10
+##END=
+`
+
+// jcampASDF is a JCAMP-DX spectrum in DIFDUP form, with a y check value at
+// the start of the second line.
+const jcampASDF = `##TITLE=Sample B
+##FIRSTX=1
+##LASTX=7
+##NPOINTS=7
+##XYDATA=(X++(Y..Y))
+1 A0JT%Tj
+6 A1J
+##END=
+`
+
+// TestReadJCAMP checks plain and compressed data tables and XYPOINTS.
+func TestReadJCAMP(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		title string
+		x, y  []float64
+	}{
+		{"AFFN", jcampAFFN, "Sample A", []float64{1000, 1001, 1002, 1003, 1004}, []float64{1, 2, 3, 4, 5}},
+		{"ASDF", jcampASDF, "Sample B", []float64{1, 2, 3, 4, 5, 6, 7}, []float64{10, 11, 12, 12, 12, 11, 12}},
+		{"XYPOINTS", "##TITLE=P\n##XFACTOR=2\n##XYPOINTS=(XY..XY)\n1, 1.5E+01; 2, -3\n##END=\n", "P", []float64{2, 4}, []float64{15, -3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spectra, err := ReadJCAMP(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("ReadJCAMP() error = %v", err)
+			}
+			if len(spectra) != 1 {
+				t.Fatalf("ReadJCAMP() returned %d spectra, want 1", len(spectra))
+			}
+			s := spectra[0]
+			if s.Title != tt.title || !reflect.DeepEqual(s.X, tt.x) || !reflect.DeepEqual(s.Y, tt.y) {
+				t.Errorf("ReadJCAMP() = %+v, want title %q, x %v, y %v", s, tt.title, tt.x, tt.y)
+			}
+		})
+	}
+
+	if _, err := ReadJCAMP(strings.NewReader(strings.Replace(jcampAFFN, "NPOINTS=5", "NPOINTS=6", 1))); err == nil {
+		t.Errorf("ReadJCAMP() expected an error for a wrong NPOINTS")
+	}
+	if _, err := ReadJCAMP(strings.NewReader(strings.Replace(jcampASDF, "6 A1J", "6 A2J", 1))); err == nil {
+		t.Errorf("ReadJCAMP() expected an error for a wrong y check value")
+	}
+}
+
+// testSPC returns an SPC file with evenly spaced x values and the given
+// subfiles of int32 y values with exponent 0, or float y values.
+func testSPC(firstX, lastX float64, float bool, subfiles ...[]int32) []byte {
+	header := make([]byte, spcHeaderSize)
+	header[1] = spcNewLSB
+	if len(subfiles) > 1 {
+		header[0] = spcMulti
+	}
+	if float {
+		header[3] = 0x80
+	}
+	le := binary.LittleEndian
+	le.PutUint32(header[4:], uint32(len(subfiles[0])))
+	le.PutUint64(header[8:], math.Float64bits(firstX))
+	le.PutUint64(header[16:], math.Float64bits(lastX))
+	le.PutUint32(header[24:], uint32(len(subfiles)))
+	copy(header[88:], "test spectrum")
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	for _, y := range subfiles {
+		sub := make([]byte, spcSubheaderSize)
+		sub[1] = header[3]
+		buf.Write(sub)
+		for _, v := range y {
+			if float {
+				binary.Write(&buf, binary.LittleEndian, float32(v))
+			} else {
+				binary.Write(&buf, binary.LittleEndian, v) // Exponent 0: y = v / 2^32
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// TestReadSPC checks integer and float y values and multifile SPC files.
+func TestReadSPC(t *testing.T) {
+	spectra, err := ReadSPC(bytes.NewReader(testSPC(900, 1000, true, []int32{1, 2, 3})))
+	if err != nil {
+		t.Fatalf("ReadSPC() error = %v", err)
+	}
+	want := Spectrum{Title: "test spectrum", X: []float64{900, 950, 1000}, Y: []float64{1, 2, 3}}
+	if len(spectra) != 1 || !reflect.DeepEqual(spectra[0], want) {
+		t.Errorf("ReadSPC() = %+v, want %+v", spectra, want)
+	}
+
+	spectra, err = ReadSPC(bytes.NewReader(testSPC(1, 2, false, []int32{1 << 30, -(1 << 30)}, []int32{0, 1 << 30})))
+	if err != nil {
+		t.Fatalf("ReadSPC() error = %v", err)
+	}
+	if len(spectra) != 2 || !reflect.DeepEqual(spectra[0].Y, []float64{0.25, -0.25}) || !reflect.DeepEqual(spectra[1].Y, []float64{0, 0.25}) {
+		t.Errorf("ReadSPC() = %+v, want y [0.25 -0.25] and [0 0.25]", spectra)
+	}
+
+	data := testSPC(1, 2, true, []int32{1, 2})
+	if _, err := ReadSPC(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Errorf("ReadSPC() expected an error for a truncated file")
+	}
+	data[1] = spcOld
+	if _, err := ReadSPC(bytes.NewReader(data)); err == nil {
+		t.Errorf("ReadSPC() expected an error for an old format file")
+	}
+
+	// Counts far beyond the size of the file
+	for _, offset := range []int{4, 24} {
+		data := testSPC(1, 2, true, []int32{1, 2})
+		data[0] = spcMulti
+		binary.LittleEndian.PutUint32(data[offset:], 1<<30)
+		if _, err := ReadSPC(bytes.NewReader(data)); err == nil {
+			t.Errorf("ReadSPC() expected an error for a count of 2^30 at byte %d", offset)
+		}
+	}
+}
+
+// TestProcessSpectra checks that a directory of spectra becomes one data
+// matrix, and that spectra with different x-axes are rejected.
+func TestProcessSpectra(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"a.jdx":     []byte(jcampAFFN),
+		"b.dx":      []byte(strings.Replace(jcampAFFN, "Sample A", "Sample C", 1)),
+		"c.spc":     testSPC(1000, 1004, true, []int32{5, 4, 3, 2, 1}),
+		"notes.txt": []byte("not a spectrum"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ProcessSpectra([]string{dir}, SpectraOptions{})
+	if err != nil {
+		t.Fatalf("ProcessSpectra() error = %v", err)
+	}
+	want := ProcessedData{
+		VariableNames: []string{"1000", "1001", "1002", "1003", "1004"},
+		ObjectNames:   []string{"a", "b", "c"},
+		Data:          [][]float64{{1, 2, 3, 4, 5}, {1, 2, 3, 4, 5}, {5, 4, 3, 2, 1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessSpectra() = %+v, want %+v", got, want)
+	}
+
+	got, err = ProcessSpectra([]string{filepath.Join(dir, "a.jdx"), filepath.Join(dir, "b.dx")}, SpectraOptions{NameBy: SpectrumNameTitle})
+	if err != nil {
+		t.Fatalf("ProcessSpectra() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.ObjectNames, []string{"Sample A", "Sample C"}) {
+		t.Errorf("ProcessSpectra() object names = %v, want titles", got.ObjectNames)
+	}
+
+	other := filepath.Join(t.TempDir(), "d.jdx")
+	if err := os.WriteFile(other, []byte(strings.Replace(jcampAFFN, "LASTX=1004", "LASTX=1008", 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ProcessSpectra([]string{dir, other}, SpectraOptions{}); err == nil {
+		t.Errorf("ProcessSpectra() expected an error for different x-axes")
+	}
+}
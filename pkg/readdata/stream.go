@@ -0,0 +1,187 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains a streaming CSV loader, which parses one
+// record at a time straight into a matrix.
+package readdata
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// streamChunkRows is the number of rows read before the size of the input,
+// when known, is used to estimate the number of rows and allocate the matrix.
+const streamChunkRows = 1024
+
+// streamChunkSize is the number of values in each chunk the data is read
+// into, rounded down to whole rows, when the number of rows is not known.
+const streamChunkSize = 64 * 1024
+
+// streamBufferSize is the size of the read buffers, and the most data
+// inspected to detect the delimiter and decimal separator.
+const streamBufferSize = 64 * 1024
+
+// StreamCSVFile reads data from a CSV file like ProcessCSVWithOptions, but
+// parses one record at a time straight into a matrix (see StreamCSV). The
+// file size is used to allocate the matrix once.
+func StreamCSVFile(filename string, opts CSVOptions) (ProcessedData, *mat.Dense, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return ProcessedData{}, nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return ProcessedData{}, nil, err
+	}
+	return streamCSV(file, opts, info.Size())
+}
+
+// StreamCSV reads data from r like ProcessCSVFrom, but parses one record at
+// a time straight into a matrix, without holding the records as strings.
+// The rows of the returned data share memory with the matrix, so the data
+// takes up memory once.
+func StreamCSV(r io.Reader, opts CSVOptions) (ProcessedData, *mat.Dense, error) {
+	return streamCSV(r, opts, 0)
+}
+
+// streamCSV reads data from r. The size of the input is used to estimate
+// the number of rows if it is positive.
+func streamCSV(r io.Reader, opts CSVOptions, size int64) (ProcessedData, *mat.Dense, error) {
+	errNoData := fmt.Errorf("data must contain at least one row and one column of data")
+	reader, opts, err := opts.newReader(r)
+	if err != nil {
+		return ProcessedData{}, nil, err
+	}
+	reader.ReuseRecord = true
+	reader.FieldsPerRecord = -1 // Checked by the parser, with the line
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return ProcessedData{}, nil, errNoData
+	} else if err != nil {
+		return ProcessedData{}, nil, err
+	}
+	header = append([]string(nil), header...)
+	if len(header) < 2 {
+		return ProcessedData{}, nil, errNoData
+	}
+	isMetadata, metadataCols, err := metadataColumns(header, opts.Metadata)
+	if err != nil {
+		return ProcessedData{}, nil, err
+	}
+	var variableNames []string
+	for j, name := range header[1:] {
+		if !isMetadata[j+1] {
+			variableNames = append(variableNames, cleanName(name))
+		}
+	}
+	cols := len(variableNames)
+	if cols == 0 {
+		return ProcessedData{}, nil, errNoData
+	}
+
+	// The values are read into chunks of fixed size, which are joined when
+	// all rows are read, rather than grown by copying them repeatedly
+	chunkRows := max(streamChunkSize/cols, 1)
+	values := make([]float64, 0, chunkRows*cols)
+	var chunks [][]float64
+	var objectNames []string
+	metadataValues := make([][]string, len(metadataCols))
+	parser := recordParser{header: header, isMetadata: isMetadata, decimal: opts.DecimalSeparator(), collect: opts.CollectErrors}
+	line := func(field int) int {
+		line, _ := reader.FieldPos(field)
+		return line
+	}
+	rows := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return ProcessedData{}, nil, err
+		}
+
+		if cap(values)-len(values) < cols {
+			chunks = append(chunks, values)
+			values = make([]float64, 0, chunkRows*cols)
+		}
+		var ok bool
+		values, ok, err = parser.parse(values, record, line)
+		if err != nil {
+			return ProcessedData{}, nil, err
+		} else if !ok {
+			continue
+		}
+		// Clone the names, as the fields share memory with the whole record
+		objectNames = append(objectNames, strings.Clone(cleanName(record[0])))
+		for k, col := range metadataCols {
+			metadataValues[k] = append(metadataValues[k], strings.Clone(record[col]))
+		}
+		rows++
+
+		// Allocate the matrix for the estimated number of rows, so that
+		// the chunks need not be joined
+		if rows == streamChunkRows && size > 0 {
+			if offset := reader.InputOffset(); offset > 0 {
+				estimate := int(float64(size)/float64(offset)*float64(rows)*1.05) + 1
+				if estimate*cols > cap(values) {
+					values = joinChunks(chunks, values, estimate*cols)
+					chunks = nil
+				}
+			}
+		}
+	}
+	if rows == 0 {
+		if err := parser.err(); err != nil {
+			return ProcessedData{}, nil, err
+		}
+		return ProcessedData{}, nil, errNoData
+	}
+
+	if chunks != nil {
+		values = joinChunks(chunks, values, rows*cols)
+	}
+	X := mat.NewDense(rows, cols, values)
+	data := make([][]float64, rows)
+	for i := range data {
+		data[i] = values[i*cols : (i+1)*cols : (i+1)*cols]
+	}
+	var metadata []Metadata
+	for k, col := range metadataCols {
+		metadata = append(metadata, newMetadata(cleanName(header[col]), metadataValues[k]))
+	}
+	return ProcessedData{
+		VariableNames: variableNames,
+		ObjectNames:   objectNames,
+		Data:          data,
+		Metadata:      metadata,
+	}, X, parser.err()
+}
+
+// joinChunks returns the values of the chunks followed by the last, partly
+// filled chunk in one slice with the given capacity.
+func joinChunks(chunks [][]float64, last []float64, capacity int) []float64 {
+	values := make([]float64, 0, capacity)
+	for _, chunk := range chunks {
+		values = append(values, chunk...)
+	}
+	return append(values, last...)
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the streaming CSV loader.
+package readdata
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// testCSV returns CSV data with the given number of rows and columns of
+// numbers, and a class column.
+func testCSV(rows, cols int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("object")
+	for j := 0; j < cols; j++ {
+		fmt.Fprintf(&buf, ",v%d", j+1)
+	}
+	buf.WriteString(",class\n")
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&buf, "o%d", i+1)
+		for j := 0; j < cols; j++ {
+			fmt.Fprintf(&buf, ",%.6f", math.Sin(float64(i*cols+j)))
+		}
+		fmt.Fprintf(&buf, ",c%d\n", i%3)
+	}
+	return buf.Bytes()
+}
+
+// equalData reports whether two data sets are equal, with NaN equal to NaN.
+func equalData(a, b ProcessedData) bool {
+	if !reflect.DeepEqual(a.VariableNames, b.VariableNames) || !reflect.DeepEqual(a.ObjectNames, b.ObjectNames) ||
+		!reflect.DeepEqual(a.Metadata, b.Metadata) || len(a.Data) != len(b.Data) {
+		return false
+	}
+	for i := range a.Data {
+		if len(a.Data[i]) != len(b.Data[i]) {
+			return false
+		}
+		for j := range a.Data[i] {
+			x, y := a.Data[i][j], b.Data[i][j]
+			if x != y && !(math.IsNaN(x) && math.IsNaN(y)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TestStreamCSV checks that the streaming loader reads the same data as
+// ProcessCSVFrom, in several dialects and encodings.
+func TestStreamCSV(t *testing.T) {
+	utf16LE := []byte{0xFF, 0xFE}
+	for _, u := range utf16.Encode([]rune(",x,y\nø1,1,2\n😀,3,NA\n")) {
+		utf16LE = append(utf16LE, byte(u), byte(u>>8))
+	}
+	tests := []struct {
+		name string
+		data []byte
+		opts CSVOptions
+	}{
+		{"default", []byte(",x,y\no1,1,2\no2,3,\n"), DefaultCSVOptions()},
+		{"european", []byte("\xEF\xBB\xBF# comment\n;x;y\no1;1,5;2\n"), CSVOptions{Comment: '#'}},
+		{"windows-1252", []byte(",x,y\nPr\xF8ve \x80,1,2\n"), CSVOptions{}},
+		{"latin1", []byte(",x,y\nPr\xF8ve,1,2\n"), CSVOptions{Encoding: EncodingLatin1}},
+		{"utf-16", utf16LE, CSVOptions{}},
+		{"metadata", testCSV(1500, 4), CSVOptions{Metadata: []string{"class"}}},
+		{"chunks", testCSV(3000, 50), CSVOptions{Metadata: []string{"class"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := ProcessCSVFrom(bytes.NewReader(tt.data), tt.opts)
+			if err != nil {
+				t.Fatalf("ProcessCSVFrom() error = %v", err)
+			}
+			got, X, err := StreamCSV(bytes.NewReader(tt.data), tt.opts)
+			if err != nil {
+				t.Fatalf("StreamCSV() error = %v", err)
+			}
+			if !equalData(got, want) {
+				t.Errorf("StreamCSV() = %+v, want %+v", got, want)
+			}
+			if r, c := X.Dims(); r != len(want.Data) || c != len(want.VariableNames) {
+				t.Errorf("StreamCSV() matrix is %d x %d, want %d x %d", r, c, len(want.Data), len(want.VariableNames))
+			}
+		})
+	}
+
+	for _, data := range []string{"", ",x\n", "o1,o2\n", ",x\no1,abc\n"} {
+		if _, _, err := StreamCSV(strings.NewReader(data), DefaultCSVOptions()); err == nil {
+			t.Errorf("StreamCSV(%q) expected an error", data)
+		}
+	}
+}
+
+// TestStreamCSVFile checks that the rows of the data share memory with the
+// matrix, for a file large enough to estimate the number of rows.
+func TestStreamCSVFile(t *testing.T) {
+	filename := writeTestFile(t, testCSV(3000, 5))
+	got, X, err := StreamCSVFile(filename, CSVOptions{Metadata: []string{"class"}})
+	if err != nil {
+		t.Fatalf("StreamCSVFile() error = %v", err)
+	}
+	if r, c := X.Dims(); r != 3000 || c != 5 {
+		t.Fatalf("StreamCSVFile() matrix is %d x %d, want 3000 x 5", r, c)
+	}
+	X.Set(2999, 4, 42)
+	if got.Data[2999][4] != 42 {
+		t.Errorf("data rows do not share memory with the matrix")
+	}
+	if got.ObjectNames[2999] != "o3000" || got.Metadata[0].Values[2] != "c2" {
+		t.Errorf("StreamCSVFile() names = %v, metadata = %v", got.ObjectNames[2999], got.Metadata[0].Values[2])
+	}
+}
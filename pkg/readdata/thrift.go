@@ -0,0 +1,223 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains a decoder for the Thrift compact protocol,
+// used for the metadata of Parquet files.
+package readdata
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Thrift compact protocol types.
+const (
+	thriftStop      = 0
+	thriftTrue      = 1
+	thriftFalse     = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftMap       = 11
+	thriftStruct    = 12
+	thriftMaxDepth  = 32
+	thriftMaxLength = 1 << 28
+)
+
+// thriftFields is a decoded Thrift struct, with the values by field id.
+// Integers are int64, binaries and strings []byte, lists and sets []any,
+// and structs thriftFields. Maps are skipped.
+type thriftFields map[int16]any
+
+// int returns the integer field id, and false if it is absent.
+func (f thriftFields) int(id int16) (int64, bool) {
+	v, ok := f[id].(int64)
+	return v, ok
+}
+
+// bool returns the boolean field id, or def if it is absent.
+func (f thriftFields) bool(id int16, def bool) bool {
+	if v, ok := f[id].(bool); ok {
+		return v
+	}
+	return def
+}
+
+// string returns the binary field id as a string.
+func (f thriftFields) string(id int16) string {
+	v, _ := f[id].([]byte)
+	return string(v)
+}
+
+// list returns the list field id.
+func (f thriftFields) list(id int16) []any {
+	v, _ := f[id].([]any)
+	return v
+}
+
+// child returns the struct field id, nil if it is absent.
+func (f thriftFields) child(id int16) thriftFields {
+	v, _ := f[id].(thriftFields)
+	return v
+}
+
+// thriftReader decodes the Thrift compact protocol. The first error stops
+// decoding and is kept in err.
+type thriftReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// readStruct decodes a struct at the current position.
+func (r *thriftReader) readStruct() (thriftFields, error) {
+	fields := r.structAt(0)
+	return fields, r.err
+}
+
+// fail records the first error.
+func (r *thriftReader) fail(format string, args ...any) {
+	if r.err == nil {
+		r.err = fmt.Errorf("invalid Parquet metadata: "+format, args...)
+	}
+}
+
+// byte reads a byte.
+func (r *thriftReader) byte() byte {
+	if r.err != nil || r.pos >= len(r.data) {
+		r.fail("unexpected end of data")
+		return 0
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+// varint reads an unsigned variable length integer.
+func (r *thriftReader) varint() uint64 {
+	var v uint64
+	for shift := 0; shift < 64; shift += 7 {
+		b := r.byte()
+		v |= uint64(b&0x7f) << shift
+		if b < 0x80 || r.err != nil {
+			return v
+		}
+	}
+	r.fail("varint is too long")
+	return 0
+}
+
+// zigzag reads a signed variable length integer.
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// length reads a length and checks it against the remaining data.
+func (r *thriftReader) length() int {
+	n := r.varint()
+	if n > thriftMaxLength || int(n) > len(r.data)-r.pos {
+		r.fail("length %d is out of range", n)
+		return 0
+	}
+	return int(n)
+}
+
+// structAt decodes a struct, nested depth levels deep.
+func (r *thriftReader) structAt(depth int) thriftFields {
+	if depth > thriftMaxDepth {
+		r.fail("structs are nested too deeply")
+		return nil
+	}
+	fields := make(thriftFields)
+	var id int16
+	for r.err == nil {
+		header := r.byte()
+		typ := header & 0x0f
+		if typ == thriftStop {
+			break
+		}
+		if delta := header >> 4; delta != 0 {
+			id += int16(delta)
+		} else {
+			id = int16(r.zigzag())
+		}
+		switch typ {
+		case thriftTrue:
+			fields[id] = true
+		case thriftFalse:
+			fields[id] = false
+		default:
+			fields[id] = r.value(typ, depth)
+		}
+	}
+	return fields
+}
+
+// value decodes a value of the given type.
+func (r *thriftReader) value(typ byte, depth int) any {
+	switch typ {
+	case thriftTrue, thriftFalse: // Booleans in lists are one byte each
+		return r.byte() == thriftTrue
+	case thriftByte:
+		return int64(int8(r.byte()))
+	case thriftI16, thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftDouble:
+		if r.pos+8 > len(r.data) {
+			r.fail("unexpected end of data")
+			return 0.0
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos:]))
+		r.pos += 8
+		return v
+	case thriftBinary:
+		n := r.length()
+		v := r.data[r.pos : r.pos+n]
+		r.pos += n
+		return v
+	case thriftList, thriftSet:
+		header := r.byte()
+		n := int(header >> 4)
+		if n == 15 {
+			n = r.length()
+		}
+		list := make([]any, 0, min(n, 1024))
+		for k := 0; k < n && r.err == nil; k++ {
+			list = append(list, r.value(header&0x0f, depth+1))
+		}
+		return list
+	case thriftMap:
+		n := r.length()
+		if n == 0 {
+			return nil
+		}
+		types := r.byte()
+		for k := 0; k < n && r.err == nil; k++ {
+			r.value(types>>4, depth+1)
+			r.value(types&0x0f, depth+1)
+		}
+		return nil
+	case thriftStruct:
+		return r.structAt(depth + 1)
+	}
+	r.fail("unknown type %d", typ)
+	return nil
+}
@@ -0,0 +1,371 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains a reader for Excel .xlsx workbooks, using
+// only the standard library (an xlsx file is a zip archive of XML parts).
+package readdata
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// XLSXOptions selects the cells to read from a workbook.
+type XLSXOptions struct {
+	Sheet         string   // Sheet name, the first sheet if empty
+	Range         string   // Cell range such as "A2:F9", all used cells if empty
+	Metadata      []string // Names of non-numeric columns, kept as Metadata
+	CollectErrors bool     // Report every bad cell as ParseErrors, rather than stop at the first
+}
+
+// ReadXLSX reads the cells of a sheet in an .xlsx file as strings, like
+// ReadCSV. Empty rows are skipped.
+func ReadXLSX(filename string, opts XLSXOptions) ([][]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return ReadXLSXFrom(file, info.Size(), opts)
+}
+
+// ReadXLSXFrom reads the cells of a sheet in an .xlsx workbook of the given
+// size from r.
+func ReadXLSXFrom(r io.ReaderAt, size int64, opts XLSXOptions) ([][]string, error) {
+	records, _, err := readXLSX(r, size, opts)
+	return records, err
+}
+
+// readXLSX reads the cells of a sheet like ReadXLSXFrom, and returns the
+// 1-based row number of each record in the sheet.
+func readXLSX(r io.ReaderAt, size int64, opts XLSXOptions) ([][]string, []int, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening workbook: %v", err)
+	}
+	sheetPath, err := findSheet(archive, opts.Sheet)
+	if err != nil {
+		return nil, nil, err
+	}
+	sharedStrings, err := readSharedStrings(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	cells, err := readSheet(archive, sheetPath, sharedStrings)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	area := usedRange(cells)
+	if opts.Range != "" {
+		requested, err := parseCellRange(opts.Range)
+		if err != nil {
+			return nil, nil, err
+		}
+		area = requested.clip(area)
+	}
+
+	var records [][]string
+	var rows []int
+	if area.lastRow < area.firstRow || area.lastCol < area.firstCol {
+		return records, rows, nil
+	}
+	for row := area.firstRow; row <= area.lastRow; row++ {
+		record := make([]string, area.lastCol-area.firstCol+1)
+		empty := true
+		for col := area.firstCol; col <= area.lastCol; col++ {
+			v := cells[cellRef{row, col}]
+			record[col-area.firstCol] = v
+			empty = empty && v == ""
+		}
+		if !empty {
+			records = append(records, record)
+			rows = append(rows, row+1)
+		}
+	}
+	return records, rows, nil
+}
+
+// ProcessXLSX reads data from a sheet in an .xlsx file like ProcessCSV. The
+// first row of the range holds the variable names, and the first column the
+// object names.
+func ProcessXLSX(filename string, opts XLSXOptions) (ProcessedData, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return ProcessedData{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return ProcessedData{}, err
+	}
+	records, rows, err := readXLSX(file, info.Size(), opts)
+	if err != nil {
+		return ProcessedData{}, err
+	}
+	row := func(i, field int) int { return rows[i] }
+	return processRecords(records, row, CSVOptions{Decimal: '.', Metadata: opts.Metadata, CollectErrors: opts.CollectErrors})
+}
+
+// xlsxWorkbook is the part of xl/workbook.xml listing the sheets.
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// xlsxRelationships is the content of xl/_rels/workbook.xml.rels.
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxText is a string item, plain or with formatted runs.
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+// String returns the text of the item without formatting.
+func (t xlsxText) String() string {
+	s := t.T
+	for _, r := range t.Runs {
+		s += r.T
+	}
+	return s
+}
+
+// xlsxSheet is the part of a worksheet holding the cell values.
+type xlsxSheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R      string   `xml:"r,attr"`
+			T      string   `xml:"t,attr"`
+			V      string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// decodePart decodes the XML part with the given name in the archive. It
+// returns false if the part does not exist.
+func decodePart(archive *zip.Reader, name string, v any) (bool, error) {
+	for _, f := range archive.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return true, err
+		}
+		defer rc.Close()
+		if err := xml.NewDecoder(rc).Decode(v); err != nil {
+			return true, fmt.Errorf("error decoding %s: %v", name, err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// findSheet returns the archive path of the named sheet, or the first sheet
+// if name is empty.
+func findSheet(archive *zip.Reader, name string) (string, error) {
+	var wb xlsxWorkbook
+	if ok, err := decodePart(archive, "xl/workbook.xml", &wb); err != nil {
+		return "", err
+	} else if !ok || len(wb.Sheets) == 0 {
+		return "", fmt.Errorf("workbook has no sheets")
+	}
+	var rels xlsxRelationships
+	if _, err := decodePart(archive, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return "", err
+	}
+
+	var names []string
+	for _, sheet := range wb.Sheets {
+		names = append(names, sheet.Name)
+		if name != "" && sheet.Name != name {
+			continue
+		}
+		for _, rel := range rels.Relationships {
+			if rel.ID != sheet.ID {
+				continue
+			}
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/"), nil
+			}
+			return path.Join("xl", rel.Target), nil
+		}
+		return "", fmt.Errorf("sheet %q not found in the workbook relationships", sheet.Name)
+	}
+	return "", fmt.Errorf("sheet %q not found, the workbook has %s", name, strings.Join(names, ", "))
+}
+
+// readSharedStrings reads the shared string table, if any.
+func readSharedStrings(archive *zip.Reader) ([]string, error) {
+	var sst struct {
+		Items []xlsxText `xml:"si"`
+	}
+	if _, err := decodePart(archive, "xl/sharedStrings.xml", &sst); err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(sst.Items))
+	for i, item := range sst.Items {
+		strs[i] = item.String()
+	}
+	return strs, nil
+}
+
+// cellRef is the zero based row and column of a cell.
+type cellRef struct {
+	row, col int
+}
+
+// cellRange is an inclusive range of cells.
+type cellRange struct {
+	firstRow, firstCol, lastRow, lastCol int
+}
+
+// readSheet reads the non-empty cells of a worksheet as strings. Numbers are
+// kept as written in the file, booleans become 0 or 1, and error values such
+// as #N/A become empty (missing).
+func readSheet(archive *zip.Reader, name string, sharedStrings []string) (map[cellRef]string, error) {
+	var sheet xlsxSheet
+	if ok, err := decodePart(archive, name, &sheet); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("worksheet %s not found", name)
+	}
+
+	cells := make(map[cellRef]string)
+	for i, row := range sheet.Rows {
+		r := i
+		if row.R > 0 {
+			r = row.R - 1
+		}
+		for j, c := range row.Cells {
+			ref := cellRef{r, j}
+			if c.R != "" {
+				var err error
+				if ref, err = parseCellRef(c.R); err != nil {
+					return nil, err
+				}
+			}
+
+			var value string
+			switch c.T {
+			case "s":
+				var k int
+				if _, err := fmt.Sscan(c.V, &k); err != nil || k < 0 || k >= len(sharedStrings) {
+					return nil, fmt.Errorf("invalid shared string %q in cell %s", c.V, c.R)
+				}
+				value = sharedStrings[k]
+			case "inlineStr":
+				value = c.Inline.String()
+			case "e":
+				value = "" // Errors such as #N/A or #DIV/0! are missing values
+			default: // Numbers, booleans and formula strings
+				value = c.V
+			}
+			if value != "" {
+				cells[ref] = value
+			}
+		}
+	}
+	return cells, nil
+}
+
+// parseCellRef parses an A1 style cell reference, e.g. "B3". Dollar signs
+// of absolute references are ignored.
+func parseCellRef(s string) (cellRef, error) {
+	s = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(s), "$", ""))
+	col, i := 0, 0
+	for ; i < len(s) && s[i] >= 'A' && s[i] <= 'Z'; i++ {
+		col = col*26 + int(s[i]-'A'+1)
+	}
+	row := 0
+	for j := i; j < len(s); j++ {
+		if s[j] < '0' || s[j] > '9' {
+			return cellRef{}, fmt.Errorf("invalid cell reference %q", s)
+		}
+		row = row*10 + int(s[j]-'0')
+	}
+	if i == 0 || i == len(s) || row == 0 {
+		return cellRef{}, fmt.Errorf("invalid cell reference %q", s)
+	}
+	return cellRef{row - 1, col - 1}, nil
+}
+
+// parseCellRange parses a cell range such as "A2:F9".
+func parseCellRange(s string) (cellRange, error) {
+	first, last, ok := strings.Cut(s, ":")
+	if !ok {
+		return cellRange{}, fmt.Errorf("invalid cell range %q, use e.g. A2:F9", s)
+	}
+	a, err := parseCellRef(first)
+	if err != nil {
+		return cellRange{}, err
+	}
+	b, err := parseCellRef(last)
+	if err != nil {
+		return cellRange{}, err
+	}
+	return cellRange{min(a.row, b.row), min(a.col, b.col), max(a.row, b.row), max(a.col, b.col)}, nil
+}
+
+// clip returns the part of a requested range that can hold non-empty cells
+// of the used range, so a huge range such as A1:XFD1048576 is not visited
+// cell by cell. The first column is kept, as it holds the object names.
+func (a cellRange) clip(used cellRange) cellRange {
+	a.firstRow = max(a.firstRow, used.firstRow)
+	a.lastRow = min(a.lastRow, used.lastRow)
+	a.lastCol = min(a.lastCol, used.lastCol)
+	return a
+}
+
+// usedRange returns the smallest range holding all non-empty cells.
+func usedRange(cells map[cellRef]string) cellRange {
+	if len(cells) == 0 {
+		return cellRange{0, 0, -1, -1}
+	}
+	area := cellRange{firstRow: -1}
+	for ref := range cells {
+		if area.firstRow < 0 {
+			area = cellRange{ref.row, ref.col, ref.row, ref.col}
+			continue
+		}
+		area.firstRow = min(area.firstRow, ref.row)
+		area.firstCol = min(area.firstCol, ref.col)
+		area.lastRow = max(area.lastRow, ref.row)
+		area.lastCol = max(area.lastCol, ref.col)
+	}
+	return area
+}
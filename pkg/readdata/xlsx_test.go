@@ -0,0 +1,80 @@
+// Copyright (C) 2024 BITJUNGLE Rune Mathisen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Description: This file contains tests for the xlsx reader.
+package readdata
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestProcessXLSX checks that the original data in the reference workbook
+// matches test_data.csv.
+func TestProcessXLSX(t *testing.T) {
+	got, err := ProcessXLSX("../../data/mean_center_variance_scale.xlsx", XLSXOptions{Sheet: "Ark1", Range: "A2:F9"})
+	if err != nil {
+		t.Fatalf("ProcessXLSX() error = %v", err)
+	}
+	want, err := ProcessCSV("../../data/test_data.csv")
+	if err != nil {
+		t.Fatalf("ProcessCSV() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.Data, want.Data) {
+		t.Errorf("ProcessXLSX() data = %v, want %v", got.Data, want.Data)
+	}
+	if len(got.VariableNames) != 5 || got.VariableNames[0] != "V1" || got.ObjectNames[6] != "S7" {
+		t.Errorf("ProcessXLSX() names = %v, %v", got.VariableNames, got.ObjectNames)
+	}
+
+	wide, err := ProcessXLSX("../../data/mean_center_variance_scale.xlsx", XLSXOptions{Sheet: "Ark1", Range: "A2:F1048576"})
+	if err != nil {
+		t.Fatalf("ProcessXLSX() error = %v", err)
+	}
+	if len(wide.Data) != 9 || !reflect.DeepEqual(wide.Data[:7], want.Data) {
+		t.Errorf("ProcessXLSX() data of a long range = %v, want %v and the mean and std rows", wide.Data, want.Data)
+	}
+
+	if _, err := ProcessXLSX("../../data/mean_center_variance_scale.xlsx", XLSXOptions{Sheet: "Missing"}); err == nil {
+		t.Errorf("ProcessXLSX() expected an error for a missing sheet")
+	}
+}
+
+// TestParseCellRange checks A1 style cell references and ranges.
+func TestParseCellRange(t *testing.T) {
+	got, err := parseCellRange("$B$3:AA10")
+	if err != nil {
+		t.Fatalf("parseCellRange() error = %v", err)
+	}
+	if want := (cellRange{2, 1, 9, 26}); got != want {
+		t.Errorf("parseCellRange() = %+v, want %+v", got, want)
+	}
+	for _, s := range []string{"A1", "1A:B2", "A0:B2", "A1:B2C"} {
+		if _, err := parseCellRange(s); err == nil {
+			t.Errorf("parseCellRange(%q) expected an error", s)
+		}
+	}
+}
+
+// TestCellRangeClip checks that a requested range is clipped to the used
+// cells, keeping its first column.
+func TestCellRangeClip(t *testing.T) {
+	requested := cellRange{0, 0, 1048575, 16383}
+	if got, want := requested.clip(cellRange{1, 2, 9, 5}), (cellRange{1, 0, 9, 5}); got != want {
+		t.Errorf("clip() = %+v, want %+v", got, want)
+	}
+	if got := (cellRange{20, 0, 30, 3}).clip(cellRange{1, 0, 9, 5}); got.lastRow >= got.firstRow {
+		t.Errorf("clip() = %+v, want an empty range", got)
+	}
+}
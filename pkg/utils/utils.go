@@ -16,15 +16,19 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"os"
 
 	"gonum.org/v1/gonum/mat"
 )
 
-// SliceToDense converts a [][]float64 to a *mat.Dense matrix.
-func SliceToDense(data [][]float64) *mat.Dense {
+// SliceToDense converts a [][]float64 to a *mat.Dense matrix. An error is
+// returned if there is no data or the rows are of unequal lengths.
+func SliceToDense(data [][]float64) (*mat.Dense, error) {
 	if len(data) == 0 || len(data[0]) == 0 {
-		return mat.NewDense(0, 0, nil)
+		return nil, fmt.Errorf("data must contain at least one row and one column")
 	}
 
 	rows := len(data)
@@ -32,17 +36,15 @@ func SliceToDense(data [][]float64) *mat.Dense {
 
 	// Flatten the [][]float64 into a []float64
 	flatData := make([]float64, 0, rows*cols)
-	for _, row := range data {
+	for i, row := range data {
 		if len(row) != cols {
-			// Handle inconsistent row length
-			fmt.Println("Error: Rows of unequal lengths")
-			return nil
+			return nil, fmt.Errorf("row %d has %d values, the first row has %d", i+1, len(row), cols)
 		}
 		flatData = append(flatData, row...)
 	}
 
 	// Create a new mat.Dense matrix with the flattened data
-	return mat.NewDense(rows, cols, flatData)
+	return mat.NewDense(rows, cols, flatData), nil
 }
 
 // DenseToSlice converts a *mat.Dense matrix to a [][]float64.
@@ -58,6 +60,63 @@ func DenseToSlice(d *mat.Dense) [][]float64 {
 	return data
 }
 
+// HasMissing reports whether a matrix contains missing (NaN) values.
+func HasMissing(X mat.Matrix) bool {
+	r, c := X.Dims()
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			if math.IsNaN(X.At(i, j)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HighestVarianceColumn returns the column of X with the highest variance,
+// e.g. as the initial score vector of NIPALS. Missing (NaN) values are
+// ignored when calculating the variance, and are set to zero in the returned
+// column.
+func HighestVarianceColumn(X *mat.Dense) *mat.Dense {
+	rows, cols := X.Dims()
+	maxVariance := 0.0
+	var columnIndex int
+
+	for j := 0; j < cols; j++ {
+		var mean, variance float64
+		var n int
+		for i := 0; i < rows; i++ {
+			value := X.At(i, j)
+			if math.IsNaN(value) {
+				continue
+			}
+			mean += value             // Accumulate the sum of values
+			variance += value * value // Accumulate the sum of squares
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		mean /= float64(n)                         // Calculate the mean
+		variance = variance/float64(n) - mean*mean // Calculate the variance
+
+		if variance > maxVariance { // Check if this column has higher variance
+			maxVariance = variance
+			columnIndex = j
+		}
+	}
+
+	// Extract the column with the highest variance
+	highestVarianceColumn := mat.NewDense(rows, 1, nil)
+	mat.Col(highestVarianceColumn.RawMatrix().Data, columnIndex, X)
+	for i := 0; i < rows; i++ {
+		if math.IsNaN(highestVarianceColumn.At(i, 0)) {
+			highestVarianceColumn.Set(i, 0, 0)
+		}
+	}
+	return highestVarianceColumn
+}
+
 // CreateFilledSlice creates a slice of float64 of a specified length, filled with a given value.
 func CreateFilledSlice(length int, value float64) ([]float64, error) {
 	if length < 0 {
@@ -110,3 +169,100 @@ func PrettyPrintSlice(slice [][]float64, tit ...string) {
 	}
 	fmt.Println("---")
 }
+
+// SaveJSON writes v as JSON to a file, which is created or truncated.
+func SaveJSON(filename string, v any) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(v); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// JSONMatrix is a matrix as a slice of rows, which is encoded in JSON with
+// null for missing (NaN) values, as JSON has no NaN. Null is decoded as NaN.
+type JSONMatrix [][]float64
+
+// MarshalJSON encodes the matrix, with null for NaN.
+func (m JSONMatrix) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+	rows := make([][]*float64, len(m))
+	for i, row := range m {
+		rows[i] = make([]*float64, len(row))
+		for j := range row {
+			if !math.IsNaN(row[j]) {
+				rows[i][j] = &row[j]
+			}
+		}
+	}
+	return json.Marshal(rows)
+}
+
+// UnmarshalJSON decodes the matrix, with NaN for null.
+func (m *JSONMatrix) UnmarshalJSON(b []byte) error {
+	var rows [][]*float64
+	if err := json.Unmarshal(b, &rows); err != nil {
+		return err
+	}
+	if rows == nil {
+		*m = nil
+		return nil
+	}
+	*m = make(JSONMatrix, len(rows))
+	for i, row := range rows {
+		(*m)[i] = make([]float64, len(row))
+		for j, v := range row {
+			if v == nil {
+				(*m)[i][j] = math.NaN()
+			} else {
+				(*m)[i][j] = *v
+			}
+		}
+	}
+	return nil
+}
+
+// JSONVector is a vector encoded in JSON with null for missing (NaN) values,
+// like JSONMatrix.
+type JSONVector []float64
+
+// MarshalJSON encodes the vector, with null for NaN.
+func (v JSONVector) MarshalJSON() ([]byte, error) {
+	if v == nil {
+		return []byte("null"), nil
+	}
+	values := make([]*float64, len(v))
+	for i := range v {
+		if !math.IsNaN(v[i]) {
+			values[i] = &v[i]
+		}
+	}
+	return json.Marshal(values)
+}
+
+// UnmarshalJSON decodes the vector, with NaN for null.
+func (v *JSONVector) UnmarshalJSON(b []byte) error {
+	var values []*float64
+	if err := json.Unmarshal(b, &values); err != nil {
+		return err
+	}
+	if values == nil {
+		*v = nil
+		return nil
+	}
+	*v = make(JSONVector, len(values))
+	for i, value := range values {
+		if value == nil {
+			(*v)[i] = math.NaN()
+		} else {
+			(*v)[i] = *value
+		}
+	}
+	return nil
+}
@@ -14,8 +14,14 @@
 package utils
 
 import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+
+	"gonum.org/v1/gonum/mat"
 )
 
 // TestCreateFilledSlice tests the CreateFilledSlice function.
@@ -55,7 +61,7 @@ func TestNormalize(t *testing.T) {
 		{0.59, 0.99, 0.7, 1.06, 1.05},
 		{1.77, 1.65, 1.99, 0.81, 1.21},
 	}
-	utils.PrettyPrintSlice(data)
+	PrettyPrintSlice(data)
 	// dataD := utils.SliceToDense(data)
 	// got := utils.Normalize(dataD)
 	// want := [][]float64{
@@ -69,3 +75,95 @@ func TestNormalize(t *testing.T) {
 	// }
 
 }
+
+// TestSliceToDense checks the conversion to a matrix, and the errors for
+// rows of unequal lengths and no data.
+func TestSliceToDense(t *testing.T) {
+	got, err := SliceToDense([][]float64{{1, 2}, {3, 4}, {5, 6}})
+	if err != nil {
+		t.Fatalf("SliceToDense() error = %v", err)
+	}
+	if want := mat.NewDense(3, 2, []float64{1, 2, 3, 4, 5, 6}); !mat.Equal(got, want) {
+		t.Errorf("SliceToDense() = %v, want %v", mat.Formatted(got), mat.Formatted(want))
+	}
+
+	for _, data := range [][][]float64{{{1, 2}, {3}}, nil, {{}}} {
+		if _, err := SliceToDense(data); err == nil {
+			t.Errorf("SliceToDense(%v) expected an error", data)
+		}
+	}
+}
+
+// TestHighestVarianceColumn checks that missing values are ignored when
+// choosing the column, and set to zero in it.
+func TestHighestVarianceColumn(t *testing.T) {
+	nan := math.NaN()
+	X := mat.NewDense(4, 3, []float64{
+		1, 0, 100,
+		2, 10, nan,
+		3, -10, nan,
+		4, nan, nan,
+	})
+	got := HighestVarianceColumn(X)
+	if want := mat.NewDense(4, 1, []float64{0, 10, -10, 0}); !mat.Equal(got, want) {
+		t.Errorf("HighestVarianceColumn() = %v, want %v", mat.Formatted(got.T()), mat.Formatted(want.T()))
+	}
+}
+
+// TestSaveJSON checks that values are written as JSON, and that values JSON
+// cannot represent give an error.
+func TestSaveJSON(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "results.json")
+	if err := SaveJSON(filename, map[string][]float64{"x": {1, 2}}); err != nil {
+		t.Fatalf("SaveJSON() error = %v", err)
+	}
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"x\":[1,2]}\n"; string(got) != want {
+		t.Errorf("SaveJSON() wrote %q, want %q", got, want)
+	}
+	if err := SaveJSON(filename, math.Inf(1)); err == nil {
+		t.Errorf("SaveJSON() accepted an infinite value")
+	}
+}
+
+// TestJSONMatrix checks that missing values are encoded as null and decoded
+// as NaN.
+func TestJSONMatrix(t *testing.T) {
+	m := JSONMatrix{{1, math.NaN()}, {math.NaN(), -2.5}}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if want := "[[1,null],[null,-2.5]]"; string(b) != want {
+		t.Errorf("json.Marshal() = %s, want %s", b, want)
+	}
+	var got JSONMatrix
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if len(got) != 2 || got[0][0] != 1 || !math.IsNaN(got[0][1]) || !math.IsNaN(got[1][0]) || got[1][1] != -2.5 {
+		t.Errorf("json.Unmarshal() = %v, want %v", got, m)
+	}
+}
+
+// TestJSONVector checks that missing values are encoded as null and decoded
+// as NaN.
+func TestJSONVector(t *testing.T) {
+	b, err := json.Marshal(JSONVector{1, math.NaN()})
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if want := "[1,null]"; string(b) != want {
+		t.Errorf("json.Marshal() = %s, want %s", b, want)
+	}
+	var got JSONVector
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if len(got) != 2 || got[0] != 1 || !math.IsNaN(got[1]) {
+		t.Errorf("json.Unmarshal() = %v, want [1 NaN]", got)
+	}
+}